@@ -1,17 +1,23 @@
 package main
 
 import (
-	"bufio"
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/AmirMahdyJebreily/hili-cat/internal/config"
+	"github.com/AmirMahdyJebreily/hili-cat/internal/git"
 	"github.com/AmirMahdyJebreily/hili-cat/internal/highlighter"
 	fileio "github.com/AmirMahdyJebreily/hili-cat/internal/io" // Renamed to avoid conflict with standard io
+	"github.com/AmirMahdyJebreily/hili-cat/pkg/ansi"
 )
 
 // Default buffer sizes for performance optimization
@@ -20,6 +26,10 @@ const (
 	channelBufferSize = 1000
 )
 
+// plainText is the language of files compared with --diff that are in no
+// configured language
+const plainText = "text"
+
 // printUsage prints the program's usage information
 func printUsage() {
 	fmt.Fprintf(os.Stderr, "Usage: hili-cat [options] [file...]\n\n")
@@ -30,6 +40,16 @@ func printUsage() {
 	fmt.Fprintf(os.Stderr, "  cat file.json | hili-cat --lang json # Highlight JSON from stdin\n")
 	fmt.Fprintf(os.Stderr, "  hili-cat --config /path/to/config.json file.py # Use custom config\n")
 	fmt.Fprintf(os.Stderr, "  hili-cat --less large_file.go       # View highlighted file with pagination\n")
+	fmt.Fprintf(os.Stderr, "  hili-cat --format html --standalone file.go > file.html # Write an HTML page\n")
+	fmt.Fprintf(os.Stderr, "  hili-cat --theme light file.go       # Use colors for a light background\n")
+	fmt.Fprintf(os.Stderr, "  hili-cat --semantic main.go          # Color Go identifiers by what they denote\n")
+	fmt.Fprintf(os.Stderr, "  hili-cat --format-source min.json    # Pretty-print before highlighting\n")
+	fmt.Fprintf(os.Stderr, "  hili-cat --table data.csv            # Align CSV columns into a table\n")
+	fmt.Fprintf(os.Stderr, "  hili-cat --render README.md          # Read Markdown with its markup applied\n")
+	fmt.Fprintf(os.Stderr, "  hili-cat --diff old.go new.go        # Compare two files side by side\n")
+	fmt.Fprintf(os.Stderr, "  hili-cat -n --gutter head main.go    # Mark the lines changed since the last commit\n")
+	fmt.Fprintf(os.Stderr, "  hili-cat --blame main.go             # Show who last changed each line, and when\n")
+	fmt.Fprintf(os.Stderr, "  git diff | hili-cat --lang diff      # Highlight a diff, each file in its language\n")
 	fmt.Fprintf(os.Stderr, "\nNote: hili-cat is designed for Linux systems only and requires the 'less' command for pagination.\n")
 }
 
@@ -43,6 +63,18 @@ func main() {
 	squeezeBlank := flag.Bool("s", false, "Suppress repeated empty output lines")
 	showEnds := flag.Bool("E", false, "Display $ at end of each line")
 	useLess := flag.Bool("less", false, "Pipe output to 'less -R' command for paged viewing")
+	format := flag.String("format", "ansi", "Output format (ansi, html)")
+	cssClasses := flag.Bool("css-classes", false, "Style HTML output with CSS classes instead of inline styles")
+	standalone := flag.Bool("standalone", false, "Write HTML output as a complete document")
+	colors := flag.String("colors", "auto", "Colors of the terminal (auto, truecolor, 256, 16)")
+	themeName := flag.String("theme", "", "Color theme: "+strings.Join(config.BuiltinThemes(), ", ")+", or the path of a theme file")
+	semantic := flag.Bool("semantic", false, "Color the identifiers of Go files by what they denote, using go/types")
+	formatSource := flag.Bool("format-source", false, "Pretty-print JSON, XML and Go before highlighting")
+	table := flag.Bool("table", false, "Align the columns of CSV and TSV files into a table at the terminal width")
+	render := flag.Bool("render", false, "Render Markdown with its markup applied instead of highlighting its source")
+	gutter := flag.String("gutter", "", "Mark the lines changed since git's index (index) or last commit (head) in a gutter")
+	blame := flag.Bool("blame", false, "Annotate each line with the commit, author and age git blame gives it")
+	diffFiles := flag.Bool("diff", false, "Compare two files side by side, with the lines that changed tinted")
 	help := flag.Bool("help", false, "Show help message")
 
 	// Add long-form flags
@@ -71,30 +103,269 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Load the theme named on the command line, in the configuration, or the default
+	theme, err := config.LoadTheme(selectTheme(*themeName, cfg.Theme))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Initialize the reader
 	reader := fileio.NewReader(defaultBufferSize)
 
 	// Get the file arguments
 	args := flag.Args()
 
+	// Select the output formatter
+	depth, err := colorDepth(*colors, os.Getenv("COLORTERM"), os.Getenv("TERM"))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	formatter, err := newFormatter(*format, depth, *cssClasses, *standalone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
 	// Create highlighter options
 	opts := highlighter.Options{
 		NumberLines:    *numberLines,
 		NumberNonBlank: *numberNonBlank,
 		SqueezeBlank:   *squeezeBlank,
 		ShowEnds:       *showEnds,
+		Formatter:      formatter,
+	}
+
+	// Modes that transform the whole source before highlighting it
+	modes := sourceModes{
+		semantic: *semantic,
+		reformat: *formatSource,
+		table:    *table,
+		render:   *render,
+		gutter:   *gutter,
+		blame:    *blame,
+		width:    fileio.TerminalWidth(os.Stdout),
+	}
+	if *gutter != "" && *gutter != git.Index && *gutter != git.Head {
+		fmt.Fprintf(os.Stderr, "Error: unknown version for --gutter: %s (use %s or %s)\n", *gutter, git.Index, git.Head)
+		os.Exit(1)
+	}
+	if *gutter != "" && *blame {
+		fmt.Fprintln(os.Stderr, "Error: --gutter and --blame cannot be used together")
+		os.Exit(1)
+	}
+
+	// Compare two files instead of showing them
+	if *diffFiles {
+		processDiff(cfg, theme, args, *lang, opts, *useLess, modes.width)
+		return
 	}
 
 	// Determine if we're reading from stdin or files
 	if len(args) == 0 {
-		processStdin(reader, cfg, *lang, *lineEnding, opts, *useLess)
+		processStdin(reader, cfg, theme, *lang, *lineEnding, opts, *useLess, modes)
 	} else {
-		processFiles(reader, cfg, args, *lang, *lineEnding, opts, *useLess)
+		processFiles(reader, cfg, theme, args, *lang, *lineEnding, opts, *useLess, modes)
+	}
+}
+
+// selectTheme returns the theme named by the --theme flag, or else the one named
+// in the configuration, or else the default theme
+func selectTheme(flagTheme, configTheme string) string {
+	if flagTheme != "" {
+		return flagTheme
+	}
+	if configTheme != "" {
+		return configTheme
+	}
+	return config.DefaultTheme
+}
+
+// colorDepth returns the color depth named by the --colors flag. For auto it is
+// detected from the COLORTERM and TERM environment variables.
+func colorDepth(colors, colorterm, term string) (ansi.ColorDepth, error) {
+	switch colors {
+	case "truecolor", "24bit":
+		return ansi.ColorsTrue, nil
+	case "256":
+		return ansi.Colors256, nil
+	case "16":
+		return ansi.Colors16, nil
+	case "auto":
+		if colorterm == "truecolor" || colorterm == "24bit" {
+			return ansi.ColorsTrue, nil
+		}
+		if strings.Contains(term, "256color") {
+			return ansi.Colors256, nil
+		}
+		return ansi.Colors16, nil
+	default:
+		return 0, fmt.Errorf("unknown color depth: %s", colors)
+	}
+}
+
+// newFormatter creates the formatter for an output format name
+func newFormatter(format string, depth ansi.ColorDepth, cssClasses, standalone bool) (highlighter.Formatter, error) {
+	switch format {
+	case "ansi":
+		return highlighter.ANSIFormatter{Colors: depth}, nil
+	case "html":
+		return highlighter.HTMLFormatter{Classes: cssClasses, Standalone: standalone}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format: %s", format)
+	}
+}
+
+// titledOptions returns opts with the document title of an HTML formatter set to title
+func titledOptions(opts highlighter.Options, title string) highlighter.Options {
+	if formatter, ok := opts.Formatter.(highlighter.HTMLFormatter); ok {
+		formatter.Title = title
+		opts.Formatter = formatter
+	}
+	return opts
+}
+
+// semanticLexer returns a lexer that styles the identifiers of a Go file by what
+// they denote, or nil for files in other languages, which --semantic leaves alone.
+// It analyzes src, or the content of the file when src is nil.
+func semanticLexer(cfg config.Config, lang, filePath string, src []byte) (highlighter.Lexer, error) {
+	if cfg.Languages[lang].Lexer != highlighter.LexerGo && filepath.Ext(filePath) != ".go" {
+		return nil, nil
+	}
+	if src == nil {
+		var err error
+		if src, err = os.ReadFile(filePath); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", filePath, err)
+		}
+	}
+	return highlighter.NewSemanticGoLexer(lang, filePath, src), nil
+}
+
+// fileGutter returns the gutter of --gutter or --blame for a file, or nil for
+// files outside of a git work tree with --gutter. data is the content of the
+// file, read from it when nil, and src the text shown in its place, which has
+// no gutter when it is not the same as data, such as with --format-source.
+func fileGutter(filePath string, modes sourceModes, data, src []byte) (highlighter.Gutter, error) {
+	if data != nil && !bytes.Equal(data, src) {
+		return nil, errors.New("it is shown reformatted or rendered")
+	}
+	if modes.blame {
+		return blameGutter(filePath)
+	}
+	return changeGutter(filePath, modes.gutter, data)
+}
+
+// changeGutter returns a gutter that marks the lines of a file changed since
+// the version of it in git, or nil for files outside of a git work tree
+func changeGutter(filePath, version string, data []byte) (highlighter.Gutter, error) {
+	committed, err := git.Committed(filePath, version)
+	if errors.Is(err, git.ErrNotWorkTree) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if data == nil {
+		if data, err = os.ReadFile(filePath); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", filePath, err)
+		}
+	}
+	return highlighter.NewChangeGutter(committed, data), nil
+}
+
+// blameGutter returns a gutter that annotates each line of a file with the
+// commit that last changed it, as git blame finds
+func blameGutter(filePath string) (highlighter.Gutter, error) {
+	commits, err := git.Blame(filePath)
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]highlighter.BlameLine, len(commits))
+	for i, commit := range commits {
+		lines[i] = highlighter.BlameLine{Commit: commit.Hash, Author: commit.Author, Time: commit.Time}
+	}
+	return highlighter.NewBlameGutter(lines, time.Now()), nil
+}
+
+// sourceModes holds the modes that read the whole source before highlighting it
+type sourceModes struct {
+	semantic bool   // --semantic
+	reformat bool   // --format-source
+	table    bool   // --table
+	render   bool   // --render
+	gutter   string // --gutter: the version of git to mark changes since, or ""
+	blame    bool   // --blame
+	width    int    // terminal width for --table and --render, 0 when unknown
+}
+
+// whole reports whether a mode needs the whole source up front
+func (m sourceModes) whole() bool {
+	return m.reformat || m.table || m.render
+}
+
+// prepareSource applies --format-source, --render and --table to the whole
+// source of a language. It returns the text to highlight, and the lexer to
+// tokenize it with, or nil for the lexer of the language.
+func prepareSource(cfg config.Config, lang, name, lineEnding string, src []byte, modes sourceModes) ([]byte, highlighter.Lexer) {
+	if modes.reformat {
+		src = formatSource(cfg, lang, name, lineEnding, src)
+	}
+	if modes.render {
+		if out, lexer := highlighter.Render(convertConfig(cfg, config.Theme{}), lang, src, modes.width); lexer != nil {
+			return withLineEnding(out, lineEnding), lexer
+		}
+	}
+	if modes.table {
+		return tableSource(cfg, lang, name, lineEnding, src, modes.width)
+	}
+	return src, nil
+}
+
+// formatSource pretty-prints the whole source of a language for --format-source,
+// with the given line ending. Source that cannot be formatted is returned as is,
+// with a warning.
+func formatSource(cfg config.Config, lang, name, lineEnding string, src []byte) []byte {
+	out, err := highlighter.Reformat(convertConfig(cfg, config.Theme{}), lang, src)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not format %s, showing it as is: %v\n", name, err)
+		return src
+	}
+	return withLineEnding(out, lineEnding)
+}
+
+// tableSource lays CSV and TSV out as a table for --table, returning the table
+// and the lexer that styles its columns. Other languages, and values that cannot
+// be parsed, are returned as is with a nil lexer.
+func tableSource(cfg config.Config, lang, name, lineEnding string, src []byte, width int) ([]byte, highlighter.Lexer) {
+	var comma rune
+	switch cfg.Languages[lang].Lexer {
+	case highlighter.LexerCSV:
+		comma = ','
+	case highlighter.LexerTSV:
+		comma = '\t'
+	default:
+		return src, nil
 	}
+
+	out, lexer, err := highlighter.CSVTable(lang, comma, src, width)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not align %s, showing it as is: %v\n", name, err)
+		return src, nil
+	}
+	return withLineEnding(out, lineEnding), lexer
+}
+
+// withLineEnding converts the line endings of generated text, which uses LF, to lineEnding
+func withLineEnding(text []byte, lineEnding string) []byte {
+	if lineEnding != highlighter.CRLF {
+		return text
+	}
+	return bytes.ReplaceAll(bytes.ReplaceAll(text, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
 }
 
 // processStdin handles input from standard input
-func processStdin(reader *fileio.Reader, cfg config.Config, lang, lineEnding string, opts highlighter.Options, useLess bool) {
+func processStdin(reader *fileio.Reader, cfg config.Config, theme config.Theme, lang, lineEnding string, opts highlighter.Options, useLess bool, modes sourceModes) {
 	// Reading from stdin
 	if lang == "" {
 		fmt.Fprintln(os.Stderr, "Error: --lang is required when reading from stdin")
@@ -102,34 +373,51 @@ func processStdin(reader *fileio.Reader, cfg config.Config, lang, lineEnding str
 		os.Exit(1)
 	}
 
+	if modes.gutter != "" || modes.blame {
+		fmt.Fprintln(os.Stderr, "Warning: --gutter and --blame need a file in a git work tree, not stdin")
+	}
+
+	// Formatting, rendering and tables need the whole input up front, which also
+	// lets its line ending be detected without consuming any of it
+	var data []byte
+	input := io.Reader(os.Stdin)
+	whole := modes.whole()
+	if whole {
+		var err error
+		if data, err = io.ReadAll(os.Stdin); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to read stdin: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
 	// Detect or set line ending
 	var detectedLineEnding string
-	if lineEnding == "auto" {
-		// Try to detect from stdin
-		bufReader := bufio.NewReader(os.Stdin)
+	if lineEnding == "auto" && whole {
+		detectedLineEnding = fileio.DetectLineEnding(data[:min(len(data), 1024)])
+	} else if lineEnding == "auto" {
+		// Detect from the first read of stdin, which is then put back in front
+		// of the rest of it
 		buf := make([]byte, 1024)
-		n, _ := bufReader.Read(buf)
+		n, _ := os.Stdin.Read(buf)
 		if n > 0 {
 			detectedLineEnding = fileio.DetectLineEnding(buf[:n])
 		} else {
 			detectedLineEnding = highlighter.LF // Default to LF if no data
 		}
-
-		// Need to reset stdin
-		if n > 0 {
-			// This is a simplified approach; in a real application,
-			// we would need a more robust way to peek at stdin without consuming it
-			fmt.Fprintf(os.Stderr, "Warning: Line ending detection consumed some input data\n")
-			detectedLineEnding = highlighter.LF // Default to LF as fallback
-		}
+		input = io.MultiReader(bytes.NewReader(buf[:n]), os.Stdin)
 	} else if lineEnding == "crlf" {
 		detectedLineEnding = highlighter.CRLF
 	} else {
 		detectedLineEnding = highlighter.LF
 	}
 
+	var src []byte
+	if whole {
+		src, opts.Lexer = prepareSource(cfg, lang, "stdin", detectedLineEnding, data, modes)
+	}
+
 	// Create highlighter
-	h, err := highlighter.NewHighlighter(convertConfig(cfg), lang, detectedLineEnding, opts)
+	h, err := highlighter.NewHighlighter(convertConfig(cfg, theme), lang, detectedLineEnding, opts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
@@ -143,7 +431,11 @@ func processStdin(reader *fileio.Reader, cfg config.Config, lang, lineEnding str
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
-		reader.ProcessFile("", dataCh, nil)
+		if whole {
+			dataCh <- src
+		} else {
+			reader.Process(input, dataCh)
+		}
 		close(dataCh) // Important: close channel when reader is done
 	}()
 
@@ -153,10 +445,11 @@ func processStdin(reader *fileio.Reader, cfg config.Config, lang, lineEnding str
 
 	// Wait for both goroutines to complete
 	wg.Wait()
+	reportSyntaxError("stdin", h)
 }
 
 // processFiles handles input from multiple files
-func processFiles(reader *fileio.Reader, cfg config.Config, files []string, langOverride, lineEnding string, opts highlighter.Options, useLess bool) {
+func processFiles(reader *fileio.Reader, cfg config.Config, theme config.Theme, files []string, langOverride, lineEnding string, opts highlighter.Options, useLess bool, modes sourceModes) {
 	for _, filePath := range files {
 		// Determine language from file extension if not explicitly provided
 		fileLang := langOverride
@@ -193,8 +486,40 @@ func processFiles(reader *fileio.Reader, cfg config.Config, files []string, lang
 			detectedLineEnding = highlighter.LF
 		}
 
+		// Formatting, tables and semantic highlighting need the whole file up front
+		var data, src []byte
+		fileOpts := titledOptions(opts, filePath)
+		whole := modes.whole()
+		if whole {
+			var err error
+			data, err = os.ReadFile(filePath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: failed to read %s: %v\n", filePath, err)
+				continue
+			}
+			src, fileOpts.Lexer = prepareSource(cfg, fileLang, filePath, detectedLineEnding, data, modes)
+		}
+
+		if modes.gutter != "" || modes.blame {
+			gutter, err := fileGutter(filePath, modes, data, src)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: no gutter for %s: %v\n", filePath, err)
+			} else if gutter != nil {
+				fileOpts.Gutter = gutter
+			}
+		}
+
+		if modes.semantic && fileOpts.Lexer == nil {
+			lexer, err := semanticLexer(cfg, fileLang, filePath, src)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				continue
+			}
+			fileOpts.Lexer = lexer
+		}
+
 		// Create highlighter
-		h, err := highlighter.NewHighlighter(convertConfig(cfg), fileLang, detectedLineEnding, opts)
+		h, err := highlighter.NewHighlighter(convertConfig(cfg, theme), fileLang, detectedLineEnding, fileOpts)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			continue
@@ -208,7 +533,11 @@ func processFiles(reader *fileio.Reader, cfg config.Config, files []string, lang
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			reader.ProcessFile(filePath, dataCh, nil)
+			if whole {
+				dataCh <- src
+			} else {
+				reader.ProcessFile(filePath, dataCh, nil)
+			}
 			close(dataCh) // Important: close channel when reader is done
 		}()
 
@@ -218,6 +547,77 @@ func processFiles(reader *fileio.Reader, cfg config.Config, files []string, lang
 
 		// Wait for both goroutines to complete
 		wg.Wait()
+		reportSyntaxError(filePath, h)
+	}
+}
+
+// diffConfig returns the highlighter configuration and the language to compare
+// files in for --diff: lang, or else the language of the new file or of the old
+// one. Files in no configured language, or with --lang text, are compared as
+// plain text, in a language without rules added to the configuration.
+func diffConfig(cfg config.Config, theme config.Theme, files []string, lang string) (highlighter.Config, string) {
+	if lang == "" {
+		if lang = config.DetectLanguage(cfg, files[1]); lang == "" {
+			lang = config.DetectLanguage(cfg, files[0])
+		}
+	}
+
+	hcfg := convertConfig(cfg, theme)
+	if _, ok := hcfg.Languages[lang]; !ok && (lang == "" || lang == plainText) {
+		lang = plainText
+		hcfg.Languages[lang] = highlighter.Language{}
+	}
+	return hcfg, lang
+}
+
+// processDiff shows two files side by side for --diff, highlighted in the
+// language of the new one, with the lines that changed between them tinted.
+// Files in no configured language are compared as plain text.
+func processDiff(cfg config.Config, theme config.Theme, files []string, lang string, opts highlighter.Options, useLess bool, width int) {
+	if len(files) != 2 {
+		fmt.Fprintln(os.Stderr, "Error: --diff compares exactly two files")
+		os.Exit(1)
+	}
+	var srcs [2][]byte
+	for i, filePath := range files {
+		data, err := os.ReadFile(filePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: failed to read %s: %v\n", filePath, err)
+			os.Exit(1)
+		}
+		srcs[i] = data
+	}
+
+	hcfg, lang := diffConfig(cfg, theme, files, lang)
+	text, lexer, err := highlighter.SideBySide(hcfg, lang, files[0], srcs[0], files[1], srcs[1], width)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	opts = titledOptions(opts, files[0]+" → "+files[1])
+	opts.Lexer = lexer
+
+	h, err := highlighter.NewHighlighter(hcfg, lang, highlighter.LF, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	// Set up the pipeline
+	dataCh := make(chan []byte, 1)
+	dataCh <- text
+	close(dataCh)
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go processOutput(dataCh, h, &wg, useLess)
+	wg.Wait()
+}
+
+// reportSyntaxError prints the first syntax error the lexer found in the input, if any
+func reportSyntaxError(name string, h *highlighter.Highlighter) {
+	if err := h.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", name, err)
 	}
 }
 
@@ -237,6 +637,7 @@ func processOutput(dataCh <-chan []byte, h *highlighter.Highlighter, wg *sync.Wa
 			for data := range dataCh {
 				fmt.Print(h.ProcessContent(data))
 			}
+			fmt.Print(h.Finish())
 			return
 		}
 
@@ -251,6 +652,7 @@ func processOutput(dataCh <-chan []byte, h *highlighter.Highlighter, wg *sync.Wa
 			for data := range dataCh {
 				fmt.Print(h.ProcessContent(data))
 			}
+			fmt.Print(h.Finish())
 			return
 		}
 
@@ -263,6 +665,8 @@ func processOutput(dataCh <-chan []byte, h *highlighter.Highlighter, wg *sync.Wa
 			}
 		}
 
+		io.WriteString(stdin, h.Finish())
+
 		// Close stdin to signal EOF to less
 		stdin.Close()
 
@@ -273,34 +677,78 @@ func processOutput(dataCh <-chan []byte, h *highlighter.Highlighter, wg *sync.Wa
 		for data := range dataCh {
 			fmt.Print(h.ProcessContent(data))
 		}
+		fmt.Print(h.Finish())
 	}
 }
 
 // Note: waitForCompletion function has been removed as it's no longer needed
 // The channel closing is now handled directly in the reader goroutines
 
-// convertConfig converts config.Config to highlighter.Config
-func convertConfig(cfg config.Config) highlighter.Config {
+// convertConfig converts config.Config to highlighter.Config, with the styles of theme
+func convertConfig(cfg config.Config, theme config.Theme) highlighter.Config {
 	languages := make(map[string]highlighter.Language)
 
 	for lang, language := range cfg.Languages {
-		rules := make([]highlighter.HighlightRule, len(language.Rules))
-		for i, rule := range language.Rules {
-			rules[i] = highlighter.HighlightRule{
-				Name:    rule.Name,
-				Pattern: rule.Pattern,
-				Style:   rule.Style,
+		var states map[string]highlighter.State
+		if len(language.States) > 0 {
+			states = make(map[string]highlighter.State, len(language.States))
+			for name, state := range language.States {
+				states[name] = highlighter.State{
+					Rules:   convertRules(state.Rules),
+					Regions: convertRegions(state.Regions),
+				}
 			}
 		}
 
 		languages[lang] = highlighter.Language{
 			Extensions: language.Extensions,
-			Rules:      rules,
+			Rules:      convertRules(language.Rules),
+			Regions:    convertRegions(language.Regions),
+			States:     states,
 			Styles:     language.Styles,
+			Overlap:    language.Overlap,
+			Lexer:      language.Lexer,
+			Reformat:   language.Reformat,
+			Render:     language.Render,
 		}
 	}
 
 	return highlighter.Config{
 		Languages: languages,
+		Theme:     theme.Styles,
+	}
+}
+
+// convertRules converts config rules, and their child rules, to highlighter rules
+func convertRules(rules []config.HighlightRule) []highlighter.HighlightRule {
+	converted := make([]highlighter.HighlightRule, len(rules))
+	for i, rule := range rules {
+		converted[i] = highlighter.HighlightRule{
+			Name:     rule.Name,
+			Pattern:  rule.Pattern,
+			Style:    rule.Style,
+			Captures: rule.Captures,
+			Priority: rule.Priority,
+			Rules:    convertRules(rule.Rules),
+			Engine:   rule.Engine,
+		}
+	}
+	return converted
+}
+
+// convertRegions converts config regions to highlighter regions
+func convertRegions(regions []config.Region) []highlighter.Region {
+	var converted []highlighter.Region
+	for _, region := range regions {
+		converted = append(converted, highlighter.Region{
+			Name:            region.Name,
+			Begin:           region.Begin,
+			End:             region.End,
+			Style:           region.Style,
+			State:           region.State,
+			Language:        region.Language,
+			LanguageCapture: region.LanguageCapture,
+		})
 	}
+	return converted
 }
@@ -6,12 +6,14 @@ import (
 	"io"
 	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
 	"sync"
 	"testing"
 
 	"github.com/AmirMahdyJebreily/hili-cat/internal/config"
 	"github.com/AmirMahdyJebreily/hili-cat/internal/highlighter"
+	"github.com/AmirMahdyJebreily/hili-cat/pkg/ansi"
 )
 
 // TestFlagParsing tests the command-line flag parsing functionality
@@ -118,7 +120,10 @@ func TestConvertConfig(t *testing.T) {
 			"go": {
 				Extensions: []string{"go"},
 				Rules: []config.HighlightRule{
-					{Name: "keyword", Pattern: `func`, Style: "cyan"},
+					{Name: "keyword", Pattern: `func(?=\()`, Style: "cyan", Engine: "backtracking"},
+					{Name: "string", Pattern: `"[^"]*"`, Style: "string", Rules: []config.HighlightRule{
+						{Name: "escape", Pattern: `\\.`, Style: "string.escape"},
+					}},
 				},
 				Styles: map[string]string{
 					"keyword": "cyan",
@@ -128,7 +133,7 @@ func TestConvertConfig(t *testing.T) {
 	}
 
 	// Convert the config
-	result := convertConfig(cfg)
+	result := convertConfig(cfg, config.Theme{Styles: map[string]string{"keyword": "blue"}})
 
 	// Verify the conversion
 	if len(result.Languages) != 1 {
@@ -146,13 +151,227 @@ func TestConvertConfig(t *testing.T) {
 		t.Errorf("convertConfig() invalid extensions: %v", goLang.Extensions)
 	}
 
-	if len(goLang.Rules) != 1 || goLang.Rules[0].Name != "keyword" {
+	if len(goLang.Rules) != 2 || goLang.Rules[0].Name != "keyword" || goLang.Rules[0].Engine != highlighter.EngineBacktracking {
 		t.Errorf("convertConfig() invalid rules: %v", goLang.Rules)
+	} else if children := goLang.Rules[1].Rules; len(children) != 1 || children[0].Style != "string.escape" {
+		t.Errorf("convertConfig() invalid child rules: %v", children)
 	}
 
 	if len(goLang.Styles) != 1 || goLang.Styles["keyword"] != "cyan" {
 		t.Errorf("convertConfig() invalid styles: %v", goLang.Styles)
 	}
+
+	if result.Theme["keyword"] != "blue" {
+		t.Errorf("convertConfig() invalid theme: %v", result.Theme)
+	}
+}
+
+// TestNewFormatter tests the selection of the output formatter
+func TestNewFormatter(t *testing.T) {
+	formatter, err := newFormatter("ansi", ansi.Colors256, false, false)
+	if err != nil || formatter != (highlighter.ANSIFormatter{Colors: ansi.Colors256}) {
+		t.Errorf("newFormatter(ansi) = %v, %v", formatter, err)
+	}
+
+	formatter, err = newFormatter("html", ansi.ColorsTrue, true, true)
+	want := highlighter.HTMLFormatter{Classes: true, Standalone: true}
+	if err != nil || formatter != want {
+		t.Errorf("newFormatter(html) = %v, %v, want %v", formatter, err, want)
+	}
+
+	if titled := titledOptions(highlighter.Options{Formatter: formatter}, "a.go"); titled.Formatter.(highlighter.HTMLFormatter).Title != "a.go" {
+		t.Errorf("titledOptions() did not set the title: %v", titled.Formatter)
+	}
+
+	if _, err := newFormatter("pdf", ansi.ColorsTrue, false, false); err == nil {
+		t.Error("newFormatter(pdf) expected error")
+	}
+}
+
+// TestSelectTheme tests the precedence of the theme flag over the configuration
+func TestSelectTheme(t *testing.T) {
+	tests := []struct {
+		flagTheme   string
+		configTheme string
+		want        string
+	}{
+		{"light", "high-contrast", "light"},
+		{"", "high-contrast", "high-contrast"},
+		{"", "", config.DefaultTheme},
+	}
+
+	for _, tt := range tests {
+		if got := selectTheme(tt.flagTheme, tt.configTheme); got != tt.want {
+			t.Errorf("selectTheme(%q, %q) = %q, want %q", tt.flagTheme, tt.configTheme, got, tt.want)
+		}
+	}
+}
+
+// TestSemanticLexer tests that --semantic only applies to Go files
+func TestSemanticLexer(t *testing.T) {
+	cfg := config.Config{Languages: map[string]config.Language{
+		"go":   {Extensions: []string{"go"}, Lexer: highlighter.LexerGo},
+		"json": {Extensions: []string{"json"}},
+	}}
+	dir := t.TempDir()
+	goFile := filepath.Join(dir, "main.go")
+	if err := os.WriteFile(goFile, []byte("package main\n\nfunc main() {}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	lexer, err := semanticLexer(cfg, "go", goFile, nil)
+	if err != nil || lexer == nil {
+		t.Fatalf("semanticLexer(go) = %v, %v", lexer, err)
+	}
+	tokens := lexer.Tokenize("package main")
+	if len(tokens) != 2 || tokens[1].Style != "namespace" {
+		t.Errorf("Tokenize() = %+v, want the package name styled as namespace", tokens)
+	}
+
+	if lexer, err := semanticLexer(cfg, "json", filepath.Join(dir, "data.json"), nil); lexer != nil || err != nil {
+		t.Errorf("semanticLexer(json) = %v, %v, want nil", lexer, err)
+	}
+	if _, err := semanticLexer(cfg, "go", filepath.Join(dir, "missing.go"), nil); err == nil {
+		t.Error("semanticLexer() expected error for a missing file")
+	}
+}
+
+// TestFormatSource tests that --format-source keeps the line ending and falls
+// back to the source when it cannot be formatted
+func TestFormatSource(t *testing.T) {
+	cfg := config.Config{Languages: map[string]config.Language{
+		"json": {Extensions: []string{"json"}, Reformat: highlighter.ReformatJSON},
+	}}
+
+	tests := []struct {
+		name       string
+		lineEnding string
+		src        string
+		want       string
+	}{
+		{"lf", highlighter.LF, `{"a":1}`, "{\n  \"a\": 1\n}\n"},
+		{"crlf", highlighter.CRLF, "{\"a\":1}\r\n", "{\r\n  \"a\": 1\r\n}\r\n"},
+		{"invalid", highlighter.LF, `{"a":`, `{"a":`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := formatSource(cfg, "json", "test.json", tt.lineEnding, []byte(tt.src)); string(got) != tt.want {
+				t.Errorf("formatSource() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestTableSource tests that --table only lays out CSV and TSV
+func TestTableSource(t *testing.T) {
+	cfg := config.Config{Languages: map[string]config.Language{
+		"csv":  {Extensions: []string{"csv"}, Lexer: highlighter.LexerCSV},
+		"tsv":  {Extensions: []string{"tsv"}, Lexer: highlighter.LexerTSV},
+		"json": {Extensions: []string{"json"}},
+	}}
+
+	tests := []struct {
+		name       string
+		lang       string
+		lineEnding string
+		src        string
+		want       string
+		wantLexer  bool
+	}{
+		{"csv", "csv", highlighter.LF, "a,bb\nccc,d\n", "a    bb\nccc  d\n", true},
+		{"tsv with crlf", "tsv", highlighter.CRLF, "a\tb\r\n", "a  b\r\n", true},
+		{"other language", "json", highlighter.LF, "a,b\n", "a,b\n", false},
+		{"invalid", "csv", highlighter.LF, "\"a\n", "\"a\n", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, lexer := tableSource(cfg, tt.lang, "test", tt.lineEnding, []byte(tt.src), 0)
+			if string(got) != tt.want || (lexer != nil) != tt.wantLexer {
+				t.Errorf("tableSource() = %q, %v, want %q with lexer %v", got, lexer, tt.want, tt.wantLexer)
+			}
+		})
+	}
+}
+
+func TestFileGutter(t *testing.T) {
+	// Text shown in place of the file gets no gutter
+	if _, err := fileGutter("main.go", sourceModes{gutter: "head"}, []byte("a\n"), []byte("b\n")); err == nil {
+		t.Error("fileGutter() expected error for reformatted text")
+	}
+
+	// Nor do files outside of a git work tree, with a warning only for --blame
+	t.Setenv("GIT_CEILING_DIRECTORIES", os.TempDir())
+	path := filepath.Join(t.TempDir(), "main.go")
+	if err := os.WriteFile(path, []byte("package main\n"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if gutter, err := fileGutter(path, sourceModes{gutter: "head"}, nil, nil); gutter != nil || err != nil {
+		t.Errorf("fileGutter(--gutter) = %v, %v, want no gutter and no error", gutter, err)
+	}
+	if _, err := fileGutter(path, sourceModes{blame: true}, nil, nil); err == nil {
+		t.Error("fileGutter(--blame) expected error outside of a work tree")
+	}
+}
+
+// TestColorDepth tests the color depth flag and its detection from the environment
+func TestDiffConfig(t *testing.T) {
+	cfg := config.Config{Languages: map[string]config.Language{"go": {Extensions: []string{"go"}}}}
+
+	tests := []struct {
+		name  string
+		files []string
+		lang  string
+		want  string
+	}{
+		{name: "new file's language", files: []string{"a.txt", "b.go"}, want: "go"},
+		{name: "old file's language", files: []string{"a.go", "b.txt"}, want: "go"},
+		{name: "given language", files: []string{"a.txt", "b.txt"}, lang: "go", want: "go"},
+		{name: "no language", files: []string{"a.txt", "b.txt"}, want: plainText},
+		{name: "plain text", files: []string{"a.go", "b.go"}, lang: plainText, want: plainText},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hcfg, lang := diffConfig(cfg, config.Theme{}, tt.files, tt.lang)
+			if lang != tt.want {
+				t.Errorf("diffConfig() language = %q, want %q", lang, tt.want)
+			}
+			if _, ok := hcfg.Languages[lang]; !ok {
+				t.Errorf("diffConfig() has no language %q", lang)
+			}
+		})
+	}
+}
+
+func TestColorDepth(t *testing.T) {
+	tests := []struct {
+		colors    string
+		colorterm string
+		term      string
+		want      ansi.ColorDepth
+	}{
+		{"auto", "truecolor", "xterm", ansi.ColorsTrue},
+		{"auto", "24bit", "", ansi.ColorsTrue},
+		{"auto", "", "xterm-256color", ansi.Colors256},
+		{"auto", "", "xterm", ansi.Colors16},
+		{"auto", "", "", ansi.Colors16},
+		{"16", "truecolor", "xterm-256color", ansi.Colors16},
+		{"256", "", "", ansi.Colors256},
+		{"truecolor", "", "", ansi.ColorsTrue},
+	}
+
+	for _, tt := range tests {
+		got, err := colorDepth(tt.colors, tt.colorterm, tt.term)
+		if err != nil || got != tt.want {
+			t.Errorf("colorDepth(%q, %q, %q) = %v, %v, want %v", tt.colors, tt.colorterm, tt.term, got, err, tt.want)
+		}
+	}
+
+	if _, err := colorDepth("many", "", ""); err == nil {
+		t.Error("colorDepth(many) expected error")
+	}
 }
 
 // TestProcessOutput tests the output processing functionality
@@ -11,23 +11,61 @@ import (
 // Default configuration file location
 const DefaultConfigPath = "/etc/highlight/config.json"
 
-// Config represents the structure of the configuration file
+// Config represents the structure of the configuration file.
+// Theme names a built-in theme or the path of a theme file.
 type Config struct {
+	Theme     string              `json:"theme,omitempty"`
 	Languages map[string]Language `json:"languages"`
 }
 
-// Language represents the syntax highlighting rules for a specific language
+// Language represents the syntax highlighting rules for a specific language.
+// Styles optionally overrides the styles of the theme for this language, and
+// Lexer optionally selects a built-in lexer, such as "go", instead of the rules,
+// Reformat the reformatter used by --format-source, such as "json", and Render
+// the renderer used by --render, such as "markdown".
 type Language struct {
 	Extensions []string          `json:"extensions"`
-	Rules      []HighlightRule   `json:"rules"`
-	Styles     map[string]string `json:"styles"`
+	Rules      []HighlightRule   `json:"rules,omitempty"`
+	Regions    []Region          `json:"regions,omitempty"`
+	States     map[string]State  `json:"states,omitempty"`
+	Styles     map[string]string `json:"styles,omitempty"`
+	Overlap    string            `json:"overlap,omitempty"`
+	Lexer      string            `json:"lexer,omitempty"`
+	Reformat   string            `json:"reformat,omitempty"`
+	Render     string            `json:"render,omitempty"`
 }
 
-// HighlightRule defines a pattern to match and the style to apply
+// HighlightRule defines a pattern to match and the style to apply.
+// Captures optionally maps capture group numbers or names to styles, and
+// Rules are child rules that only run inside the match, and Engine selects
+// the regex engine, "re2" by default or "backtracking".
 type HighlightRule struct {
-	Name    string `json:"name"`
-	Pattern string `json:"pattern"`
-	Style   string `json:"style"`
+	Name     string            `json:"name"`
+	Pattern  string            `json:"pattern"`
+	Style    string            `json:"style"`
+	Captures map[string]string `json:"captures,omitempty"`
+	Priority int               `json:"priority,omitempty"`
+	Rules    []HighlightRule   `json:"rules,omitempty"`
+	Engine   string            `json:"engine,omitempty"`
+}
+
+// State is a named set of rules and regions that applies inside a region
+type State struct {
+	Rules   []HighlightRule `json:"rules"`
+	Regions []Region        `json:"regions,omitempty"`
+}
+
+// Region defines a span delimited by begin and end patterns that may cross lines.
+// Its text can be handed to another language, named by Language or read from the
+// LanguageCapture group of the begin pattern.
+type Region struct {
+	Name            string `json:"name"`
+	Begin           string `json:"begin"`
+	End             string `json:"end"`
+	Style           string `json:"style"`
+	State           string `json:"state,omitempty"`
+	Language        string `json:"language,omitempty"`
+	LanguageCapture string `json:"language_capture,omitempty"`
 }
 
 // Load loads the syntax highlighting configuration from a file
@@ -62,33 +100,17 @@ func EnsureExists(configPath string) error {
 			Languages: map[string]Language{
 				"go": {
 					Extensions: []string{"go"},
-					Rules: []HighlightRule{
-						{Name: "keywords", Pattern: `\b(func|package|import|var|const|type|struct|interface|map|chan|go|defer|if|else|switch|case|for|range|return|break|continue)\b`, Style: "keyword"},
-						{Name: "strings", Pattern: `"[^"]*"`, Style: "string"},
-						{Name: "comments", Pattern: `//.*|/\*[\s\S]*?\*/`, Style: "comment"},
-						{Name: "numbers", Pattern: `\b\d+\b`, Style: "number"},
-					},
-					Styles: map[string]string{
-						"keyword": "cyan",
-						"string":  "green",
-						"comment": "yellow",
-						"number":  "magenta",
-					},
+					Lexer:      "go",
+					Reformat:   "go",
 				},
 				"json": {
 					Extensions: []string{"json"},
-					Rules: []HighlightRule{
-						{Name: "keys", Pattern: `"[^"]*"\s*:`, Style: "key"},
-						{Name: "strings", Pattern: `:\s*"[^"]*"`, Style: "string"},
-						{Name: "numbers", Pattern: `:\s*\d+`, Style: "number"},
-						{Name: "booleans", Pattern: `:\s*(true|false|null)`, Style: "boolean"},
-					},
-					Styles: map[string]string{
-						"key":     "cyan",
-						"string":  "green",
-						"number":  "magenta",
-						"boolean": "yellow",
-					},
+					Lexer:      "json",
+					Reformat:   "json",
+				},
+				"diff": {
+					Extensions: []string{"diff", "patch"},
+					Lexer:      "diff",
 				},
 			},
 		}
@@ -89,3 +89,51 @@ func TestEnsureExists(t *testing.T) {
 		t.Errorf("EnsureExists() with existing file error = %v", err)
 	}
 }
+
+func TestLoadRegions(t *testing.T) {
+	tmpDir, err := os.MkdirTemp("", "config_test")
+	if err != nil {
+		t.Fatalf("Failed to create temporary directory: %v", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	testConfigPath := filepath.Join(tmpDir, "regions.json")
+	data := `{
+  "languages": {
+    "js": {
+      "extensions": ["js"],
+      "rules": [{"name": "keywords", "pattern": "\\bconst\\b", "style": "keyword"}],
+      "regions": [{"name": "templates", "begin": "` + "`" + `", "end": "` + "`" + `", "style": "string", "state": "template"}],
+      "states": {"template": {"rules": [{"name": "escapes", "pattern": "\\\\.", "style": "string"}]}},
+      "styles": {"keyword": "cyan", "string": "green"}
+    },
+    "plain": {
+      "extensions": ["txt"],
+      "rules": [{"name": "numbers", "pattern": "\\d+", "style": "number"}],
+      "styles": {"number": "magenta"}
+    }
+  }
+}`
+	if err := os.WriteFile(testConfigPath, []byte(data), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	cfg, err := Load(testConfigPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	js := cfg.Languages["js"]
+	if len(js.Regions) != 1 || js.Regions[0].State != "template" {
+		t.Errorf("Load() regions = %+v", js.Regions)
+	}
+	if len(js.States["template"].Rules) != 1 {
+		t.Errorf("Load() states = %+v", js.States)
+	}
+
+	// Rule-only languages keep working without regions or states
+	plain := cfg.Languages["plain"]
+	if len(plain.Rules) != 1 || plain.Regions != nil || plain.States != nil {
+		t.Errorf("Load() rule-only language = %+v", plain)
+	}
+}
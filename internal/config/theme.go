@@ -0,0 +1,60 @@
+package config
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// DefaultTheme is the theme used when neither the configuration nor the command line names one
+const DefaultTheme = "dark"
+
+// builtinThemes holds the theme files shipped with hili-cat
+//
+//go:embed themes/*.json
+var builtinThemes embed.FS
+
+// Theme maps semantic token names, such as keyword or comment, to styles
+type Theme struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Styles      map[string]string `json:"styles"`
+}
+
+// BuiltinThemes returns the names of the themes shipped with hili-cat
+func BuiltinThemes() []string {
+	entries, err := builtinThemes.ReadDir("themes")
+	if err != nil {
+		return nil
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, strings.TrimSuffix(entry.Name(), ".json"))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadTheme loads a built-in theme by name, or a theme file by path
+func LoadTheme(name string) (Theme, error) {
+	var theme Theme
+
+	data, err := builtinThemes.ReadFile(path.Join("themes", name+".json"))
+	if err != nil {
+		// Not a built-in theme, so it must be a file
+		if data, err = os.ReadFile(name); err != nil {
+			return theme, fmt.Errorf("unknown theme %s: not one of %s, and failed to open it as a file: %v",
+				name, strings.Join(BuiltinThemes(), ", "), err)
+		}
+	}
+
+	if err := json.Unmarshal(data, &theme); err != nil {
+		return theme, fmt.Errorf("failed to parse theme %s: %v", name, err)
+	}
+	return theme, nil
+}
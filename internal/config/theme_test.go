@@ -0,0 +1,107 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/AmirMahdyJebreily/hili-cat/pkg/ansi"
+)
+
+func TestBuiltinThemes(t *testing.T) {
+	names := BuiltinThemes()
+	for _, want := range []string{"dark", "high-contrast", "light"} {
+		found := false
+		for _, name := range names {
+			found = found || name == want
+		}
+		if !found {
+			t.Errorf("BuiltinThemes() = %v, missing %s", names, want)
+		}
+	}
+
+	// Every style name used by the shipped grammars needs a style in every
+	// theme, either of its own or of one of its dotted prefixes
+	cfg, err := Load("../../config/config.json")
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	used := map[string]bool{}
+	for _, language := range cfg.Languages {
+		collectStyles(language.Rules, language.Regions, used)
+		for _, state := range language.States {
+			collectStyles(state.Rules, state.Regions, used)
+		}
+	}
+
+	for _, name := range names {
+		theme, err := LoadTheme(name)
+		if err != nil {
+			t.Fatalf("LoadTheme(%s) error = %v", name, err)
+		}
+		if theme.Name != name {
+			t.Errorf("LoadTheme(%s) name = %q", name, theme.Name)
+		}
+		for style := range used {
+			if !hasScope(theme.Styles, style) {
+				t.Errorf("theme %s has no style for %q", name, style)
+			}
+		}
+		for style, spec := range theme.Styles {
+			if _, err := ansi.ParseStyle(spec); err != nil {
+				t.Errorf("theme %s has an invalid style for %q: %v", name, style, err)
+			}
+		}
+	}
+}
+
+// collectStyles adds the style names used by rules and regions to used
+func collectStyles(rules []HighlightRule, regions []Region, used map[string]bool) {
+	for _, rule := range rules {
+		if rule.Style != "" {
+			used[rule.Style] = true
+		}
+		for _, style := range rule.Captures {
+			used[style] = true
+		}
+	}
+	for _, region := range regions {
+		if region.Style != "" {
+			used[region.Style] = true
+		}
+	}
+}
+
+// hasScope reports whether styles has a style for a dotted scope name or one of its prefixes
+func hasScope(styles map[string]string, scope string) bool {
+	for {
+		if _, ok := styles[scope]; ok {
+			return true
+		}
+		dot := strings.LastIndexByte(scope, '.')
+		if dot < 0 {
+			return false
+		}
+		scope = scope[:dot]
+	}
+}
+
+func TestLoadThemeFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mine.json")
+	if err := os.WriteFile(path, []byte(`{"name": "mine", "styles": {"keyword": "bold red"}}`), 0644); err != nil {
+		t.Fatalf("Failed to write theme: %v", err)
+	}
+
+	theme, err := LoadTheme(path)
+	if err != nil {
+		t.Fatalf("LoadTheme() error = %v", err)
+	}
+	if theme.Name != "mine" || theme.Styles["keyword"] != "bold red" {
+		t.Errorf("LoadTheme() = %+v", theme)
+	}
+
+	if _, err := LoadTheme("no-such-theme"); err == nil {
+		t.Error("LoadTheme() expected error for unknown theme")
+	}
+}
@@ -0,0 +1,95 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Commit is a commit that git blame attributes lines to. Lines changed in the
+// work tree and not committed yet belong to a commit whose hash is all zeros.
+type Commit struct {
+	Hash    string
+	Author  string
+	Time    time.Time
+	Summary string
+}
+
+// Uncommitted reports whether the commit stands for changes not committed yet
+func (c *Commit) Uncommitted() bool {
+	return strings.Trim(c.Hash, "0") == ""
+}
+
+// Blame returns the commit that last changed each line of a file in the work
+// tree, in order, as found by git blame. Lines of the same commit share the
+// same *Commit.
+func Blame(path string) ([]*Commit, error) {
+	dir, name := filepath.Split(path)
+	if _, err := run(dir, "rev-parse", "--is-inside-work-tree"); err != nil {
+		return nil, ErrNotWorkTree
+	}
+	out, err := run(dir, "blame", "--porcelain", "--", name)
+	if err != nil {
+		return nil, err
+	}
+	return parseBlame(string(out))
+}
+
+// parseBlame parses the porcelain output of git blame. Each line of the file
+// comes after a header naming its commit, and the first header of a commit is
+// followed by the commit's details, one per line.
+func parseBlame(out string) ([]*Commit, error) {
+	commits := make(map[string]*Commit)
+	var lines []*Commit
+	var current *Commit
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "\t") {
+			if current == nil {
+				return nil, errors.New("git blame: line without a commit")
+			}
+			lines = append(lines, current)
+			continue
+		}
+
+		key, value, _ := strings.Cut(line, " ")
+		if isHash(key) {
+			if current = commits[key]; current == nil {
+				current = &Commit{Hash: key}
+				commits[key] = current
+			}
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		switch key {
+		case "author":
+			current.Author = value
+		case "author-time":
+			seconds, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("git blame: invalid author time %q", value)
+			}
+			current.Time = time.Unix(seconds, 0)
+		case "summary":
+			current.Summary = value
+		}
+	}
+	return lines, nil
+}
+
+// isHash reports whether s is a full SHA-1 or SHA-256 commit hash
+func isHash(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,68 @@
+// Package git reads what git knows about the files of a work tree by running
+// the local git binary
+package git
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Versions a file can be compared with
+const (
+	Index = "index"
+	Head  = "head"
+)
+
+// ErrNotWorkTree is returned for files outside of a git work tree, or when
+// git cannot be run
+var ErrNotWorkTree = errors.New("not inside a git work tree")
+
+// Committed returns the content of a file in the git index, or in the commit
+// at HEAD, by version. It returns nil content for files git does not have in
+// that version, such as new files that were not added yet.
+func Committed(path, version string) ([]byte, error) {
+	var revision string
+	switch version {
+	case Index:
+		// The index is named by an empty revision, as in :./name
+	case Head:
+		revision = "HEAD"
+	default:
+		return nil, fmt.Errorf("unknown version to compare with: %s", version)
+	}
+
+	dir, name := filepath.Split(path)
+	if _, err := run(dir, "rev-parse", "--is-inside-work-tree"); err != nil {
+		return nil, ErrNotWorkTree
+	}
+
+	// The ./ makes the name relative to dir rather than to the top of the work tree
+	content, err := run(dir, "cat-file", "blob", revision+":./"+name)
+	if err != nil {
+		return nil, nil
+	}
+	return content, nil
+}
+
+// run runs git in dir with args and returns its output. The error holds what
+// git printed to stderr.
+func run(dir string, args ...string) ([]byte, error) {
+	if dir == "" {
+		dir = "."
+	}
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("git %s: %s", args[0], message)
+		}
+		return nil, fmt.Errorf("git %s: %v", args[0], err)
+	}
+	return out, nil
+}
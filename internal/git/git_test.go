@@ -0,0 +1,116 @@
+package git
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newRepository creates a work tree with a committed file, changed once more
+// in the index and once more in the work tree
+func newRepository(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	write := func(content string) {
+		if err := os.WriteFile(filepath.Join(dir, "file.txt"), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write file: %v", err)
+		}
+	}
+	git := func(args ...string) {
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=Test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	git("init", "-q")
+	write("committed\n")
+	git("add", "file.txt")
+	git("commit", "-q", "-m", "Add file")
+	write("staged\n")
+	git("add", "file.txt")
+	write("working\n")
+	return dir
+}
+
+func TestCommitted(t *testing.T) {
+	dir := newRepository(t)
+
+	tests := []struct {
+		name    string
+		path    string
+		version string
+		want    string
+	}{
+		{"index", filepath.Join(dir, "file.txt"), Index, "staged\n"},
+		{"head", filepath.Join(dir, "file.txt"), Head, "committed\n"},
+		{"untracked", filepath.Join(dir, "new.txt"), Head, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Committed(tt.path, tt.version)
+			if err != nil {
+				t.Fatalf("Committed() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Committed() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommittedOutsideWorkTree(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("GIT_CEILING_DIRECTORIES", os.TempDir())
+	_, err := Committed(filepath.Join(t.TempDir(), "file.txt"), Head)
+	if !errors.Is(err, ErrNotWorkTree) {
+		t.Errorf("Committed() error = %v, want %v", err, ErrNotWorkTree)
+	}
+}
+
+func TestBlame(t *testing.T) {
+	dir := newRepository(t)
+
+	commits, err := Blame(filepath.Join(dir, "file.txt"))
+	if err != nil {
+		t.Fatalf("Blame() error = %v", err)
+	}
+	if len(commits) != 1 || !commits[0].Uncommitted() {
+		t.Errorf("Blame() = %+v, want one uncommitted line", commits)
+	}
+
+	if _, err := Blame(filepath.Join(dir, "new.txt")); err == nil {
+		t.Error("Blame() expected error for a file git does not track")
+	}
+}
+
+func TestParseBlame(t *testing.T) {
+	hash := "c8398ee2a41049cb84bf4bf366da56975782f3fb"
+	out := hash + " 1 1 2\nauthor Ada Lovelace\nauthor-time 1700000000\nsummary Add notes\nfilename notes.txt\n\tfirst\n" +
+		hash + " 2 2\n\tsecond\n"
+
+	commits, err := parseBlame(out)
+	if err != nil {
+		t.Fatalf("parseBlame() error = %v", err)
+	}
+	if len(commits) != 2 || commits[0] != commits[1] {
+		t.Fatalf("parseBlame() = %+v, want two lines of the same commit", commits)
+	}
+	want := Commit{Hash: hash, Author: "Ada Lovelace", Time: time.Unix(1700000000, 0), Summary: "Add notes"}
+	if *commits[0] != want {
+		t.Errorf("parseBlame() commit = %+v, want %+v", *commits[0], want)
+	}
+	if commits[0].Uncommitted() {
+		t.Error("Uncommitted() = true for a commit")
+	}
+}
@@ -0,0 +1,126 @@
+package highlighter
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Layout of the gutters of BlameGutter
+const (
+	blameColors      = 6
+	blameHashWidth   = 7
+	blameAuthorWidth = 16
+	blameBar         = "┃"
+)
+
+// BlameLine is what git blame says about a line: the commit that last changed
+// it, and the author and time of that commit
+type BlameLine struct {
+	Commit string
+	Author string
+	Time   time.Time
+}
+
+// BlameGutter annotates each line with the commit that last changed it. Lines
+// of the same commit in a row form a group, marked by a bar along it, and the
+// first line of the group also gets the short hash, author and age of the
+// commit. The bar and the hash are styled as blame.1 to blame.6, one per
+// commit, so a commit keeps its color wherever its lines are. Commits take the
+// colors in turn as they first appear, skipping the color of the group just
+// above. Authors are styled as blame.author and ages as blame.age.
+type BlameGutter struct {
+	lines  []BlameLine
+	styles []string
+	ages   []string
+	author int
+	age    int
+}
+
+// NewBlameGutter returns a gutter for the lines of a file, with the ages of
+// their commits counted back from now
+func NewBlameGutter(lines []BlameLine, now time.Time) *BlameGutter {
+	g := &BlameGutter{lines: lines, styles: make([]string, len(lines)), ages: make([]string, len(lines))}
+	colors := make(map[string]int)
+	next := 0
+	for i, line := range lines {
+		color, ok := colors[line.Commit]
+		if !ok {
+			color = next % blameColors
+			if i > 0 && color == colors[lines[i-1].Commit] {
+				next++
+				color = next % blameColors
+			}
+			colors[line.Commit] = color
+			next++
+		}
+		g.styles[i] = "blame." + strconv.Itoa(color+1)
+		g.ages[i] = blameAge(line.Time, now)
+		g.author = max(g.author, min(utf8.RuneCountInString(line.Author), blameAuthorWidth))
+		g.age = max(g.age, utf8.RuneCountInString(g.ages[i]))
+	}
+	return g
+}
+
+// Annotate returns the gutter of a line: the bar of its group, then the
+// commit of the first line of a group, or spaces for the lines after it
+func (g *BlameGutter) Annotate(line int) (string, []Token) {
+	width := blameHashWidth + 1 + g.author + 1 + g.age
+	if line < 1 || line > len(g.lines) {
+		return strings.Repeat(" ", utf8.RuneCountInString(blameBar)+1+width+1), nil
+	}
+	i := line - 1
+	style := g.styles[i]
+
+	var b strings.Builder
+	tokens := []Token{{Start: 0, End: len(blameBar), Line: line, Rule: "group", Style: style}}
+	b.WriteString(blameBar)
+	b.WriteByte(' ')
+	if i > 0 && g.lines[i-1].Commit == g.lines[i].Commit {
+		b.WriteString(strings.Repeat(" ", width+1))
+		return b.String(), tokens
+	}
+
+	blame := g.lines[i]
+	add := func(text, rule, style string, width int) {
+		tokens = append(tokens, Token{Start: b.Len(), End: b.Len() + len(text), Line: line, Rule: rule, Style: style})
+		b.WriteString(text)
+		b.WriteString(strings.Repeat(" ", width-utf8.RuneCountInString(text)+1))
+	}
+	add(truncateRunes(blame.Commit, blameHashWidth), "commit", style, blameHashWidth)
+	author := blame.Author
+	if utf8.RuneCountInString(author) > g.author {
+		author = truncateRunes(author, g.author-1) + tableEllipsis
+	}
+	add(author, "author", "blame.author", g.author)
+	add(g.ages[i], "age", "blame.age", g.age)
+	return b.String(), tokens
+}
+
+// blameAge returns how long before now a commit was made, in the largest unit
+// that fits, such as "3 days ago"
+func blameAge(t, now time.Time) string {
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"year", 365 * 24 * time.Hour},
+		{"month", 30 * 24 * time.Hour},
+		{"week", 7 * 24 * time.Hour},
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+	age := now.Sub(t)
+	for _, unit := range units {
+		switch n := int(age / unit.size); {
+		case n == 1:
+			return "1 " + unit.name + " ago"
+		case n > 1:
+			return fmt.Sprintf("%d %ss ago", n, unit.name)
+		}
+	}
+	return "just now"
+}
@@ -0,0 +1,75 @@
+package highlighter
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestBlameGutter(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	lines := []BlameLine{
+		{Commit: "aaaaaaaaaa", Author: "Ada", Time: now.Add(-3 * 24 * time.Hour)},
+		{Commit: "aaaaaaaaaa", Author: "Ada", Time: now.Add(-3 * 24 * time.Hour)},
+		{Commit: "bbbbbbbbbb", Author: "Grace Brewster Murray Hopper", Time: now.Add(-time.Hour)},
+		{Commit: "aaaaaaaaaa", Author: "Ada", Time: now.Add(-3 * 24 * time.Hour)},
+	}
+	gutter := NewBlameGutter(lines, now)
+
+	tests := []struct {
+		line   int
+		want   string
+		tokens []string
+	}{
+		{1, "┃ aaaaaaa Ada              3 days ago ", []string{"┃:blame.1", "aaaaaaa:blame.1", "Ada:blame.author", "3 days ago:blame.age"}},
+		{2, "┃                                     ", []string{"┃:blame.1"}},
+		{3, "┃ bbbbbbb Grace Brewster … 1 hour ago ", []string{"┃:blame.2", "bbbbbbb:blame.2", "Grace Brewster …:blame.author", "1 hour ago:blame.age"}},
+		{4, "┃ aaaaaaa Ada              3 days ago ", []string{"┃:blame.1", "aaaaaaa:blame.1", "Ada:blame.author", "3 days ago:blame.age"}},
+		{5, "                                      ", nil},
+	}
+
+	for _, tt := range tests {
+		text, tokens := gutter.Annotate(tt.line)
+		var got []string
+		for _, token := range tokens {
+			got = append(got, text[token.Start:token.End]+":"+token.Style)
+		}
+		if text != tt.want || !reflect.DeepEqual(got, tt.tokens) {
+			t.Errorf("Annotate(%d) = %q %q, want %q %q", tt.line, text, got, tt.want, tt.tokens)
+		}
+	}
+}
+
+func TestBlameGutterColors(t *testing.T) {
+	// Commit g would take the color of a, the group above it
+	var lines []BlameLine
+	for _, commit := range []string{"a", "b", "c", "d", "e", "f", "a", "g", "b"} {
+		lines = append(lines, BlameLine{Commit: commit})
+	}
+	gutter := NewBlameGutter(lines, time.Time{})
+
+	want := []string{"blame.1", "blame.2", "blame.3", "blame.4", "blame.5", "blame.6", "blame.1", "blame.2", "blame.2"}
+	if !reflect.DeepEqual(gutter.styles, want) {
+		t.Errorf("NewBlameGutter() styles = %q, want %q", gutter.styles, want)
+	}
+}
+
+func TestBlameAge(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		age  time.Duration
+		want string
+	}{
+		{30 * time.Second, "just now"},
+		{5 * time.Minute, "5 minutes ago"},
+		{25 * time.Hour, "1 day ago"},
+		{15 * 24 * time.Hour, "2 weeks ago"},
+		{800 * 24 * time.Hour, "2 years ago"},
+	}
+
+	for _, tt := range tests {
+		if got := blameAge(now.Add(-tt.age), now); got != tt.want {
+			t.Errorf("blameAge(%v) = %q, want %q", tt.age, got, tt.want)
+		}
+	}
+}
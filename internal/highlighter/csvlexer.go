@@ -0,0 +1,131 @@
+package highlighter
+
+import (
+	"fmt"
+	"strings"
+)
+
+// csvColumnStyles is the number of styles columns cycle through
+const csvColumnStyles = 6
+
+// CSVLexer tokenizes comma- or tab-separated values. Each column is styled as
+// column.1 to column.6 in turn, the fields of the first record are also styled
+// as header, and separators as punctuation. Quoted fields keep the style of
+// their column, with doubled quotes styled as string.escape, including quoted
+// fields whose line breaks span lines.
+type CSVLexer struct {
+	language string
+	comma    byte
+	records  int
+	column   int
+	quoted   bool
+}
+
+// NewCSVLexer returns a lexer for values separated by comma, such as ',' or
+// '\t', whose tokens belong to the configured language lang
+func NewCSVLexer(lang string, comma byte) *CSVLexer {
+	return &CSVLexer{language: lang, comma: comma}
+}
+
+// Tokenize returns the tokens of the next line
+func (l *CSVLexer) Tokenize(line string) []Token {
+	if !l.quoted {
+		// Empty lines between records are skipped, as encoding/csv does
+		if line == "" {
+			return nil
+		}
+		l.records++
+		l.column = 0
+	}
+
+	var tokens []Token
+	pos := 0
+	for {
+		start := pos
+		if l.quoted || pos < len(line) && line[pos] == '"' {
+			if !l.quoted {
+				pos++
+			}
+			tokens, pos = l.appendQuoted(tokens, line, start, pos)
+			if l.quoted {
+				return tokens
+			}
+		}
+
+		// Text after a closing quote belongs to the same field
+		end := strings.IndexByte(line[pos:], l.comma)
+		if end < 0 {
+			end = len(line)
+		} else {
+			end += pos
+		}
+		if end > pos {
+			tokens = append(tokens, l.field(pos, end, "field", ""))
+		}
+		if end == len(line) {
+			return tokens
+		}
+
+		tokens = append(tokens, Token{Start: end, End: end + 1, Rule: "separator", Style: "punctuation", Language: l.language})
+		l.column++
+		pos = end + 1
+	}
+}
+
+// appendQuoted appends the tokens of a quoted field that starts at start and
+// continues at pos, up to its closing quote or the end of the line, and returns
+// where it stopped
+func (l *CSVLexer) appendQuoted(tokens []Token, line string, start, pos int) ([]Token, int) {
+	l.quoted = true
+	for pos < len(line) {
+		quote := strings.IndexByte(line[pos:], '"')
+		if quote < 0 {
+			pos = len(line)
+			break
+		}
+		pos += quote
+		if pos+1 < len(line) && line[pos+1] == '"' {
+			if pos > start {
+				tokens = append(tokens, l.field(start, pos, "quoted", ""))
+			}
+			tokens = append(tokens, l.field(pos, pos+2, "quoted", "string.escape"))
+			pos += 2
+			start = pos
+			continue
+		}
+		pos++
+		l.quoted = false
+		break
+	}
+	if pos > start {
+		tokens = append(tokens, l.field(start, pos, "quoted", ""))
+	}
+	return tokens, pos
+}
+
+// field returns a token of the current column, styled as style on top of the
+// styles of the column, or as the column itself when style is empty
+func (l *CSVLexer) field(start, end int, rule, style string) Token {
+	return csvToken(l.language, start, end, rule, style, l.column, l.records == 1)
+}
+
+// Reset forgets the records so far, so the next line starts a new header
+func (l *CSVLexer) Reset() {
+	l.records, l.column, l.quoted = 0, 0, false
+}
+
+// csvToken returns the token of a field in a column counted from 0, layering
+// style and the header style over the style of the column
+func csvToken(lang string, start, end int, rule, style string, column int, header bool) Token {
+	token := Token{Start: start, End: end, Rule: rule, Language: lang}
+	token.Style = fmt.Sprintf("column.%d", column%csvColumnStyles+1)
+	if header {
+		token.Parents = []string{token.Style}
+		token.Style = "header"
+	}
+	if style != "" {
+		token.Parents = append(token.Parents, token.Style)
+		token.Style = style
+	}
+	return token
+}
@@ -0,0 +1,108 @@
+package highlighter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// csvPieces returns the text and style of each token of lines, with the
+// styles of the token's parents before its own
+func csvPieces(lexer Lexer, input string) []string {
+	var got []string
+	for _, line := range strings.Split(input, "\n") {
+		for _, token := range lexer.Tokenize(line) {
+			style := strings.Join(append(append([]string{}, token.Parents...), token.Style), "+")
+			got = append(got, line[token.Start:token.End]+":"+style)
+		}
+	}
+	return got
+}
+
+func TestCSVLexer(t *testing.T) {
+	tests := []struct {
+		name  string
+		comma byte
+		input string
+		want  []string
+	}{
+		{
+			name:  "header and columns",
+			comma: ',',
+			input: "a,b\n1,,3",
+			want: []string{
+				"a:column.1+header", ",:punctuation", "b:column.2+header",
+				"1:column.1", ",:punctuation", ",:punctuation", "3:column.3",
+			},
+		},
+		{
+			name:  "columns cycle",
+			comma: ',',
+			input: "h\n1,2,3,4,5,6,7",
+			want: []string{
+				"h:column.1+header",
+				"1:column.1", ",:punctuation", "2:column.2", ",:punctuation", "3:column.3", ",:punctuation",
+				"4:column.4", ",:punctuation", "5:column.5", ",:punctuation", "6:column.6", ",:punctuation", "7:column.1",
+			},
+		},
+		{
+			name:  "quoted fields",
+			comma: ',',
+			input: "h\n\"a,b\",\"say \"\"hi\"\"\"",
+			want: []string{
+				"h:column.1+header",
+				`"a,b":column.1`, ",:punctuation",
+				`"say :column.2`, `"":column.2+string.escape`, "hi:column.2", `"":column.2+string.escape`, `":column.2`,
+			},
+		},
+		{
+			name:  "quoted line breaks",
+			comma: ',',
+			input: "h,i\n1,\"two\n\nlines, \"\"still\"\"\",3\n4",
+			want: []string{
+				"h:column.1+header", ",:punctuation", "i:column.2+header",
+				"1:column.1", ",:punctuation", `"two:column.2`,
+				"lines, :column.2", `"":column.2+string.escape`, "still:column.2", `"":column.2+string.escape`, `":column.2`,
+				",:punctuation", "3:column.3",
+				"4:column.1",
+			},
+		},
+		{
+			name:  "empty lines are skipped",
+			comma: ',',
+			input: "h\n\n1",
+			want:  []string{"h:column.1+header", "1:column.1"},
+		},
+		{
+			name:  "tabs",
+			comma: '\t',
+			input: "a,b\tc\n1\t\"2\t3\"",
+			want: []string{
+				"a,b:column.1+header", "\t:punctuation", "c:column.2+header",
+				"1:column.1", "\t:punctuation", "\"2\t3\":column.2",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := csvPieces(NewCSVLexer("csv", tt.comma), tt.input)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCSVLexerReset(t *testing.T) {
+	lexer := NewCSVLexer("csv", ',')
+	lexer.Tokenize("h")
+	lexer.Tokenize(`1,"open`)
+	lexer.Reset()
+
+	got := csvPieces(lexer, "a,b")
+	want := []string{"a:column.1+header", ",:punctuation", "b:column.2+header"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize() after Reset() = %q, want %q", got, want)
+	}
+}
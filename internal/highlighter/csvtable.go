@@ -0,0 +1,148 @@
+package highlighter
+
+import (
+	"bytes"
+	"encoding/csv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Layout of the tables of CSVTable
+const (
+	tableGap      = "  "
+	tableMinWidth = 3
+	tableEllipsis = "…"
+)
+
+// CSVTable parses src as values separated by comma, and lays its records out as
+// a table whose columns are aligned and padded with spaces. When width is
+// positive, the widest columns are cut, ending in an ellipsis, until the table
+// fits in width characters. Line breaks inside fields are shown as ↵, so each
+// record takes one line. It returns the table and a lexer that styles its
+// columns as CSVLexer does, or an error if src is not valid.
+func CSVTable(lang string, comma rune, src []byte, width int) ([]byte, Lexer, error) {
+	reader := csv.NewReader(bytes.NewReader(src))
+	reader.Comma = comma
+	reader.FieldsPerRecord = -1
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cleaner := strings.NewReplacer("\r\n", "↵", "\n", "↵", "\r", "↵", "\t", " ")
+	var widths []int
+	for _, record := range records {
+		for i, field := range record {
+			record[i] = cleaner.Replace(field)
+			if i == len(widths) {
+				widths = append(widths, 0)
+			}
+			widths[i] = max(widths[i], utf8.RuneCountInString(record[i]))
+		}
+	}
+	if width > 0 {
+		fitWidths(widths, width)
+	}
+
+	var out bytes.Buffer
+	for _, record := range records {
+		for i, field := range record {
+			if i > 0 {
+				out.WriteString(tableGap)
+			}
+			n := utf8.RuneCountInString(field)
+			if n > widths[i] {
+				field = truncateRunes(field, widths[i]-1) + tableEllipsis
+				n = widths[i]
+			}
+			out.WriteString(field)
+			if i < len(record)-1 {
+				out.WriteString(strings.Repeat(" ", widths[i]-n))
+			}
+		}
+		out.WriteByte('\n')
+	}
+	return out.Bytes(), &tableLexer{language: lang, widths: widths}, nil
+}
+
+// fitWidths narrows the widest columns, down to tableMinWidth, until the
+// columns and the gaps between them fit in width
+func fitWidths(widths []int, width int) {
+	total := len(tableGap) * (len(widths) - 1)
+	for _, w := range widths {
+		total += w
+	}
+	for total > width {
+		widest := 0
+		for i, w := range widths {
+			if w > widths[widest] {
+				widest = i
+			}
+		}
+		if widths[widest] <= tableMinWidth {
+			return
+		}
+		widths[widest]--
+		total--
+	}
+}
+
+// truncateRunes returns the first n runes of s
+func truncateRunes(s string, n int) string {
+	for i := range s {
+		if n == 0 {
+			return s[:i]
+		}
+		n--
+	}
+	return s
+}
+
+// tableLexer styles the columns of a table laid out by CSVTable, whose first
+// line is the header
+type tableLexer struct {
+	language string
+	widths   []int
+	line     int
+}
+
+// Tokenize returns the tokens of the next line
+func (l *tableLexer) Tokenize(line string) []Token {
+	l.line++
+
+	// The widths count runes, so find where each rune starts
+	offsets := make([]int, 0, len(line)+1)
+	for i := range line {
+		offsets = append(offsets, i)
+	}
+	runes := len(offsets)
+	offsets = append(offsets, len(line))
+
+	var tokens []Token
+	start := 0
+	for column, width := range l.widths {
+		if start >= runes {
+			break
+		}
+		end := min(start+width, runes)
+		if column == len(l.widths)-1 {
+			end = runes
+		}
+
+		// The padding after a field is not part of it
+		from, to := offsets[start], offsets[end]
+		for to > from && line[to-1] == ' ' {
+			to--
+		}
+		if to > from {
+			tokens = append(tokens, csvToken(l.language, from, to, "field", "", column, l.line == 1))
+		}
+		start = end + len(tableGap)
+	}
+	return tokens
+}
+
+// Reset starts a new table
+func (l *tableLexer) Reset() {
+	l.line = 0
+}
@@ -0,0 +1,72 @@
+package highlighter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCSVTable(t *testing.T) {
+	tests := []struct {
+		name   string
+		comma  rune
+		input  string
+		width  int
+		want   string
+		tokens []string
+	}{
+		{
+			name:  "aligned",
+			comma: ',',
+			input: "name,city\nAda,\"London, UK\"\nLinus,Helsinki\n",
+			want:  "name   city\nAda    London, UK\nLinus  Helsinki\n",
+			tokens: []string{
+				"name:column.1+header", "city:column.2+header",
+				"Ada:column.1", "London, UK:column.2",
+				"Linus:column.1", "Helsinki:column.2",
+			},
+		},
+		{
+			name:  "fit to width",
+			comma: ',',
+			input: "id,text\n1,abcdefghij\n",
+			width: 10,
+			want:  "id  text\n1   abcde…\n",
+			tokens: []string{
+				"id:column.1+header", "text:column.2+header",
+				"1:column.1", "abcde…:column.2",
+			},
+		},
+		{
+			name:  "line breaks and ragged records",
+			comma: '\t',
+			input: "ä\tb\tc\n\"x\ny\"\n",
+			want:  "ä    b  c\nx↵y\n",
+			tokens: []string{
+				"ä:column.1+header", "b:column.2+header", "c:column.3+header",
+				"x↵y:column.1",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, lexer, err := CSVTable("csv", tt.comma, []byte(tt.input), tt.width)
+			if err != nil {
+				t.Fatalf("CSVTable() error = %v", err)
+			}
+			if string(out) != tt.want {
+				t.Errorf("CSVTable() = %q, want %q", out, tt.want)
+			}
+			if got := csvPieces(lexer, strings.TrimSuffix(string(out), "\n")); !reflect.DeepEqual(got, tt.tokens) {
+				t.Errorf("Tokenize() = %q, want %q", got, tt.tokens)
+			}
+		})
+	}
+}
+
+func TestCSVTableError(t *testing.T) {
+	if _, _, err := CSVTable("csv", ',', []byte("a,\"b\nc"), 0); err == nil {
+		t.Error("CSVTable() expected error for an unterminated quote")
+	}
+}
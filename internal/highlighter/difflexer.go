@@ -0,0 +1,429 @@
+package highlighter
+
+import (
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/AmirMahdyJebreily/hili-cat/pkg/diff"
+)
+
+// diffLine is the kind of a line of a unified diff
+type diffLine int
+
+// Kinds of diff lines
+const (
+	diffText diffLine = iota // outside of any diff, such as a commit message
+	diffHeader
+	diffMeta
+	diffHunk
+	diffContext
+	diffRemoved
+	diffAdded
+)
+
+// diffMetaPrefixes start the extended header lines of git diffs, and the note
+// that a file does not end with a line break
+var diffMetaPrefixes = []string{
+	"index ", "new file mode ", "deleted file mode ", "old mode ", "new mode ",
+	"similarity index ", "dissimilarity index ", "rename from ", "rename to ",
+	"copy from ", "copy to ", "Binary files ", `\ `,
+}
+
+// diffParser follows the structure of a unified diff line by line: the headers
+// that name each file, and the hunks, whose line counts tell where they end so
+// that removed lines starting with "--" are not taken for headers
+type diffParser struct {
+	oldLeft int
+	newLeft int
+	oldPath string
+	path    string
+}
+
+// next returns the kind of the next line
+func (p *diffParser) next(line string) diffLine {
+	if p.oldLeft > 0 || p.newLeft > 0 {
+		switch {
+		case line == "" || line[0] == ' ':
+			p.oldLeft--
+			p.newLeft--
+			return diffContext
+		case line[0] == '-':
+			p.oldLeft--
+			return diffRemoved
+		case line[0] == '+':
+			p.newLeft--
+			return diffAdded
+		case line[0] == '\\':
+			return diffMeta
+		}
+		// The hunk was cut short, such as by editing a patch by hand
+		p.oldLeft, p.newLeft = 0, 0
+	}
+
+	switch {
+	case strings.HasPrefix(line, "diff "):
+		p.oldPath, p.path = "", ""
+		if i := strings.LastIndex(line, " b/"); i >= 0 {
+			p.path = line[i+3:]
+		}
+		return diffHeader
+	case strings.HasPrefix(line, "--- "):
+		p.oldPath = diffPath(line[4:])
+		return diffHeader
+	case strings.HasPrefix(line, "+++ "):
+		if p.path = diffPath(line[4:]); p.path == "" {
+			p.path = p.oldPath
+		}
+		return diffHeader
+	case strings.HasPrefix(line, "@@ "):
+		p.oldLeft, p.newLeft = hunkLengths(line)
+		return diffHunk
+	}
+	for _, prefix := range diffMetaPrefixes {
+		if strings.HasPrefix(line, prefix) {
+			return diffMeta
+		}
+	}
+	return diffText
+}
+
+// diffPath returns the file name of a --- or +++ header line, without the
+// timestamp some tools add after a tab, or "" for /dev/null
+func diffPath(name string) string {
+	name, _, _ = strings.Cut(name, "\t")
+	name = strings.Trim(strings.TrimSpace(name), `"`)
+	if name == "/dev/null" {
+		return ""
+	}
+	return name
+}
+
+// hunkLengths returns the number of old and new lines of a hunk header such as
+// "@@ -1,4 +1,5 @@", where a length left out is 1
+func hunkLengths(line string) (int, int) {
+	length := func(field string) int {
+		_, count, ok := strings.Cut(field, ",")
+		if !ok {
+			return 1
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil {
+			return 0
+		}
+		return n
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") || !strings.HasPrefix(fields[2], "+") {
+		return 0, 0
+	}
+	return length(fields[1]), length(fields[2])
+}
+
+// DiffLexer tokenizes unified diffs, such as the output of git diff. File
+// headers are styled as diff.header, extended headers as diff.meta and hunk
+// headers as diff.hunk. The code in each hunk is highlighted in the language
+// of its file, found from the file's extension, with removed and added lines
+// tinted by diff.removed and diff.added. Words that changed between a removed
+// line and the added line paired with it are also styled diff.removed.word and
+// diff.added.word.
+type DiffLexer struct {
+	config   Config
+	language string
+	parser   diffParser
+	path     string
+	old      Lexer
+	new      Lexer
+	changes  map[int][]wordRange
+	changed  int
+}
+
+// wordRange is the byte range of changed words in a line
+type wordRange struct {
+	start int
+	end   int
+}
+
+// NewDiffLexer returns a lexer for diffs whose tokens belong to the configured
+// language lang, and which highlights hunks in the languages of cfg. Changed
+// words are marked in the lines given to Lookahead before they are tokenized.
+func NewDiffLexer(cfg Config, lang string) *DiffLexer {
+	return &DiffLexer{config: cfg, language: lang, changes: make(map[int][]wordRange)}
+}
+
+// Tokenize returns the tokens of the next line
+func (l *DiffLexer) Tokenize(line string) []Token {
+	switch l.parser.next(line) {
+	case diffHeader:
+		if l.parser.path != l.path {
+			l.openFile(l.parser.path)
+		}
+		return l.whole(line, "header")
+	case diffMeta:
+		return l.whole(line, "meta")
+	case diffHunk:
+		// Hunks skip the lines in between, so nothing carries over to them
+		for _, lexer := range []Lexer{l.old, l.new} {
+			if lexer != nil {
+				lexer.Reset()
+			}
+		}
+		return l.whole(line, "hunk")
+	case diffContext:
+		if line == "" {
+			return nil
+		}
+		if l.old != nil {
+			l.old.Tokenize(line[1:])
+		}
+		return l.code(line, l.new)
+	case diffRemoved:
+		return l.tinted(line, l.old, "removed")
+	case diffAdded:
+		return l.tinted(line, l.new, "added")
+	}
+	return nil
+}
+
+// Reset starts a new diff
+func (l *DiffLexer) Reset() {
+	l.parser = diffParser{}
+	l.path = ""
+	l.old, l.new = nil, nil
+	l.changes = make(map[int][]wordRange)
+	l.changed = 0
+}
+
+// openFile sets up the lexers of a file's old and new content, in the language
+// of its extension or else of its base name, such as Makefile. Files in other
+// languages are not highlighted.
+func (l *DiffLexer) openFile(name string) {
+	l.path = name
+	l.old, l.new = nil, nil
+
+	lang := lookupLanguage(l.config, strings.TrimPrefix(path.Ext(name), "."))
+	if lang == "" {
+		lang = lookupLanguage(l.config, path.Base(name))
+	}
+	if lang == "" {
+		return
+	}
+	old, err := newLexer(l.config, lang)
+	if err != nil {
+		return
+	}
+	l.old = old
+	l.new, _ = newLexer(l.config, lang)
+}
+
+// whole returns a token that styles a whole line as diff.<kind>
+func (l *DiffLexer) whole(line, kind string) []Token {
+	if line == "" {
+		return nil
+	}
+	return []Token{{Start: 0, End: len(line), Rule: kind, Style: "diff." + kind, Language: l.language}}
+}
+
+// code returns the tokens of the code after the marker of a hunk line
+func (l *DiffLexer) code(line string, lexer Lexer) []Token {
+	if lexer == nil {
+		return nil
+	}
+	tokens := lexer.Tokenize(line[1:])
+	for i := range tokens {
+		tokens[i].Start++
+		tokens[i].End++
+	}
+	return tokens
+}
+
+// tinted returns the tokens of a removed or added line, whose changed words
+// are the next ones found by Lookahead
+func (l *DiffLexer) tinted(line string, lexer Lexer, kind string) []Token {
+	words := l.changes[l.changed]
+	delete(l.changes, l.changed)
+	l.changed++
+	return tintTokens(l.language, len(line), l.code(line, lexer), words, kind)
+}
+
+// tintTokens returns the tokens of a removed or added line of length bytes, of
+// the language lang. The line is cut at the bounds of its code tokens and
+// changed words, so that every piece is tinted by diff.<kind>, and changed
+// words also by diff.<kind>.word, beneath the style of the code.
+func tintTokens(lang string, length int, code []Token, words []wordRange, kind string) []Token {
+	bounds := []int{0, length}
+	for _, token := range code {
+		bounds = append(bounds, token.Start, token.End)
+	}
+	for _, word := range words {
+		bounds = append(bounds, word.start, word.end)
+	}
+	slices.Sort(bounds)
+	bounds = slices.Compact(bounds)
+
+	tokens := make([]Token, 0, len(bounds))
+	for i := 0; i+1 < len(bounds); i++ {
+		start, end := bounds[i], bounds[i+1]
+		parents := []string{"diff." + kind}
+		for len(words) > 0 && words[0].end <= start {
+			words = words[1:]
+		}
+		if len(words) > 0 && words[0].start <= start {
+			parents = append(parents, "diff."+kind+".word")
+		}
+
+		for len(code) > 0 && code[0].End <= start {
+			code = code[1:]
+		}
+		if len(code) > 0 && code[0].Start <= start {
+			token := code[0]
+			token.Start, token.End = start, end
+			token.Parents = append(parents, token.Parents...)
+			tokens = append(tokens, token)
+			continue
+		}
+		tokens = append(tokens, Token{
+			Start:    start,
+			End:      end,
+			Rule:     kind,
+			Style:    parents[len(parents)-1],
+			Language: lang,
+			Parents:  parents[:len(parents)-1],
+		})
+	}
+	return tokens
+}
+
+// Lookahead pairs each run of removed lines in the hunks of the lines that
+// come next with the run of added lines that follows it, first with first, and
+// keeps the words that changed in each, by the position of the line among the
+// removed and added lines, for when it is tokenized. Lines too different to
+// pair up are left out. A run at the end whose removed lines could still be
+// paired with added lines after it waits for them, which holds back at most
+// one hunk.
+func (l *DiffLexer) Lookahead(lines []string) int {
+	parser := l.parser
+	changed := l.changed
+	var removed []string
+	added, start := 0, 0
+	for i, line := range lines {
+		switch parser.next(line) {
+		case diffRemoved:
+			if added > 0 {
+				removed, added = nil, 0
+			}
+			if len(removed) == 0 {
+				start = i
+			}
+			removed = append(removed, line)
+		case diffAdded:
+			if added < len(removed) {
+				before, after := wordChanges(removed[added][1:], line[1:])
+				if before != nil || after != nil {
+					l.changes[changed-len(removed)] = shiftWords(before, 1)
+					l.changes[changed] = shiftWords(after, 1)
+				}
+			}
+			added++
+		default:
+			removed, added = nil, 0
+			continue
+		}
+		changed++
+	}
+
+	if added < len(removed) && parser.newLeft > 0 {
+		return len(lines) - start
+	}
+	return 0
+}
+
+// wordChanges returns the byte ranges of the words that differ between an old
+// and a new line. Lines that share less than half of their text are rewrites
+// rather than edits, and get no ranges.
+func wordChanges(before, after string) ([]wordRange, []wordRange) {
+	oldWords, newWords := splitWords(before), splitWords(after)
+	edits := diff.Diff(oldWords, newWords)
+
+	shared := 0
+	for _, edit := range edits {
+		if edit.Op == diff.Equal {
+			for _, word := range oldWords[edit.A : edit.A+edit.Len] {
+				shared += len(word)
+			}
+		}
+	}
+	if 4*shared < len(before)+len(after) {
+		return nil, nil
+	}
+
+	var oldRanges, newRanges []wordRange
+	for _, edit := range edits {
+		switch edit.Op {
+		case diff.Delete:
+			oldRanges = append(oldRanges, wordSpan(oldWords, edit.A, edit.Len))
+		case diff.Insert:
+			newRanges = append(newRanges, wordSpan(newWords, edit.B, edit.Len))
+		}
+	}
+	return oldRanges, newRanges
+}
+
+// wordSpan returns the range of n words starting at word i
+func wordSpan(words []string, i, n int) wordRange {
+	start := 0
+	for _, word := range words[:i] {
+		start += len(word)
+	}
+	end := start
+	for _, word := range words[i : i+n] {
+		end += len(word)
+	}
+	return wordRange{start: start, end: end}
+}
+
+// shiftWords moves word ranges by offset, such as past the marker of a diff line
+func shiftWords(words []wordRange, offset int) []wordRange {
+	for i := range words {
+		words[i].start += offset
+		words[i].end += offset
+	}
+	return words
+}
+
+// splitWords splits a line into runs of letters, digits and underscores, runs
+// of spaces, and single other characters
+func splitWords(line string) []string {
+	var words []string
+	for len(line) > 0 {
+		r, size := utf8.DecodeRuneInString(line)
+		kind := wordKind(r)
+		if kind != 0 {
+			for size < len(line) {
+				next, n := utf8.DecodeRuneInString(line[size:])
+				if wordKind(next) != kind {
+					break
+				}
+				size += n
+			}
+		}
+		words = append(words, line[:size])
+		line = line[size:]
+	}
+	return words
+}
+
+// wordKind returns 1 for word characters, 2 for spaces and 0 for others
+func wordKind(r rune) int {
+	switch {
+	case r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r):
+		return 1
+	case unicode.IsSpace(r):
+		return 2
+	}
+	return 0
+}
@@ -0,0 +1,141 @@
+package highlighter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffLexer(t *testing.T) {
+	cfg := Config{Languages: map[string]Language{
+		"diff": {Extensions: []string{"diff", "patch"}, Lexer: LexerDiff},
+		"go":   {Extensions: []string{"go"}, Lexer: LexerGo},
+	}}
+
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name: "headers and hunks in the file's language",
+			input: "diff --git a/main.go b/main.go\nindex 1f2e..3d4c 100644\n--- a/main.go\n+++ b/main.go\n" +
+				"@@ -1,2 +1,2 @@ func main() {\n return\n-x := 1\n+y := 1",
+			want: []string{
+				"diff --git a/main.go b/main.go:diff.header", "index 1f2e..3d4c 100644:diff.meta",
+				"--- a/main.go:diff.header", "+++ b/main.go:diff.header", "@@ -1,2 +1,2 @@ func main() {:diff.hunk",
+				"return:keyword",
+				"-:diff.removed", "x:diff.removed+diff.removed.word", " := :diff.removed", "1:diff.removed+number",
+				"+:diff.added", "y:diff.added+diff.added.word", " := :diff.added", "1:diff.added+number",
+			},
+		},
+		{
+			name:  "removed lines that look like headers",
+			input: "--- a/notes\n+++ b/notes\n@@ -1 +0,0 @@\n--- a/x\n\\ No newline at end of file\nplain text",
+			want: []string{
+				"--- a/notes:diff.header", "+++ b/notes:diff.header", "@@ -1 +0,0 @@:diff.hunk",
+				"--- a/x:diff.removed", `\ No newline at end of file:diff.meta`,
+			},
+		},
+		{
+			name:  "new files take the name of the old one",
+			input: "--- a/gone.go\n+++ /dev/null\n@@ -1 +0,0 @@\n-nil",
+			want: []string{
+				"--- a/gone.go:diff.header", "+++ /dev/null:diff.header", "@@ -1 +0,0 @@:diff.hunk",
+				"-:diff.removed", "nil:diff.removed+constant",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lexer := NewDiffLexer(cfg, "diff")
+			lexer.Lookahead(strings.Split(tt.input, "\n"))
+			got := csvPieces(lexer, tt.input)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLookahead(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "paired lines",
+			input: "@@ -1,3 +1,3 @@\n-a = f(x)\n-keep\n+a = g(x, y)\n+keep\n same",
+			want:  []string{"f", "g", ", y"},
+		},
+		{
+			name:  "rewritten lines are not marked",
+			input: "@@ -1 +1 @@\n-first version\n+entirely different",
+			want:  nil,
+		},
+		{
+			name:  "unpaired lines",
+			input: "@@ -1,2 +1 @@\n-one\n two\n+three",
+			want:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var lines []string
+			for _, line := range strings.Split(tt.input, "\n") {
+				if line[0] == '-' || line[0] == '+' {
+					lines = append(lines, line)
+				}
+			}
+
+			var got []string
+			lexer := NewDiffLexer(Config{}, "diff")
+			if held := lexer.Lookahead(strings.Split(tt.input, "\n")); held != 0 {
+				t.Errorf("Lookahead() = %d, want no lines held back", held)
+			}
+			for i, line := range lines {
+				for _, word := range lexer.changes[i] {
+					got = append(got, line[word.start:word.end])
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Lookahead() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiffLexerStreaming(t *testing.T) {
+	cfg := Config{
+		Languages: map[string]Language{"diff": {Lexer: LexerDiff}},
+		Theme:     map[string]string{"diff.removed.word": "red", "diff.added.word": "green"},
+	}
+	h, err := NewHighlighter(cfg, "diff", LF, Options{})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+
+	// The removed line waits for the added line in the next chunk
+	var got strings.Builder
+	for _, chunk := range []string{"@@ -1,2 +1,2 @@\n a\n-b = 1\n", "+c = 1\n"} {
+		got.WriteString(h.ProcessContent([]byte(chunk)))
+		if chunk[0] == '@' && strings.Contains(got.String(), "b = 1") {
+			t.Errorf("ProcessContent() = %q, want the removed line held back", got.String())
+		}
+	}
+	got.WriteString(h.Finish())
+
+	want := "@@ -1,2 +1,2 @@\n a\n-" + Red + "b" + Reset + " = 1\n+" + Green + "c" + Reset + " = 1\n"
+	if got.String() != want {
+		t.Errorf("output = %q, want %q", got.String(), want)
+	}
+
+	// A run at the end of the input is written by Finish
+	h, _ = NewHighlighter(cfg, "diff", LF, Options{})
+	if out := h.ProcessContent([]byte("@@ -1 +1 @@\n-b\n")) + h.Finish(); out != "@@ -1 +1 @@\n-b\n" {
+		t.Errorf("output = %q, want the removed line", out)
+	}
+}
@@ -0,0 +1,120 @@
+package highlighter
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+// loadShippedConfig loads the configuration shipped in config/config.json with the dark theme
+func loadShippedConfig(tb testing.TB) Config {
+	tb.Helper()
+
+	data, err := os.ReadFile("../../config/config.json")
+	if err != nil {
+		tb.Fatalf("Failed to read shipped config: %v", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		tb.Fatalf("Failed to parse shipped config: %v", err)
+	}
+
+	data, err = os.ReadFile("../config/themes/dark.json")
+	if err != nil {
+		tb.Fatalf("Failed to read shipped theme: %v", err)
+	}
+	var theme struct{ Styles map[string]string }
+	if err := json.Unmarshal(data, &theme); err != nil {
+		tb.Fatalf("Failed to parse shipped theme: %v", err)
+	}
+	cfg.Theme = theme.Styles
+	return cfg
+}
+
+// largeRuleSet returns a language with 40 rules, like the bigger language definitions
+func largeRuleSet() Language {
+	words := strings.Fields("alpha bravo charlie delta echo foxtrot golf hotel india juliet kilo lima mike november oscar papa quebec romeo sierra tango uniform victor whiskey xray yankee zulu")
+	language := Language{Styles: map[string]string{"keyword": "cyan", "string": "green", "comment": "yellow", "number": "magenta"}}
+	for i := 0; i < 34; i++ {
+		pattern := fmt.Sprintf(`\b(%s%d|%s_%d)\b`, words[i%len(words)], i, words[(i+7)%len(words)], i)
+		language.Rules = append(language.Rules, HighlightRule{Name: fmt.Sprintf("keywords%d", i), Pattern: pattern, Style: "keyword"})
+	}
+	language.Rules = append(language.Rules,
+		HighlightRule{Name: "strings", Pattern: `"[^"]*"`, Style: "string"},
+		HighlightRule{Name: "chars", Pattern: `'[^']*'`, Style: "string"},
+		HighlightRule{Name: "comments", Pattern: `//.*`, Style: "comment"},
+		HighlightRule{Name: "numbers", Pattern: `\b\d+(\.\d+)?\b`, Style: "number"},
+		HighlightRule{Name: "hex", Pattern: `\b0x[0-9a-fA-F]+\b`, Style: "number"},
+		HighlightRule{Name: "calls", Pattern: `\b([A-Za-z_]\w*)\(`, Style: "", Captures: map[string]string{"1": "keyword"}},
+	)
+	return language
+}
+
+// sampleSource returns Go-like source text of about the given number of lines
+func sampleSource(lines int) []byte {
+	var b strings.Builder
+	for i := 0; i < lines; i++ {
+		switch i % 8 {
+		case 0:
+			b.WriteString("// Process handles the request and returns the response\n")
+		case 1:
+			b.WriteString("func (s *Server) Process(ctx context.Context, req *Request) (*Response, error) {\n")
+		case 2:
+			b.WriteString("\tif req == nil || len(req.Items) > 1024 {\n")
+		case 3:
+			b.WriteString("\t\treturn nil, fmt.Errorf(\"invalid request %d: %s\", req.ID, \"too many items\")\n")
+		case 4:
+			b.WriteString("\t}\n")
+		case 5:
+			b.WriteString("\tfor i, item := range req.Items { total += item.Price * 3.5 /* tax */ }\n")
+		case 6:
+			b.WriteString("\n")
+		default:
+			b.WriteString("\treturn &Response{Total: total, Count: uint64(len(req.Items))}, nil\n")
+		}
+	}
+	return []byte(b.String())
+}
+
+// benchmarkProcessContent times highlighting sample source in a language, with
+// the prefilters turned off when prefilter is false, to compare against
+func benchmarkProcessContent(b *testing.B, cfg Config, lang string, prefilter bool) {
+	data := sampleSource(1000)
+	h, err := NewHighlighter(cfg, lang, LF, Options{})
+	if err != nil {
+		b.Fatal(err)
+	}
+	if !prefilter {
+		disablePrefilters(h)
+	}
+
+	// The sample closes every region it opens, so each call does the same work
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		h.ProcessContent(data)
+	}
+}
+
+// The shipped Go language uses the Go lexer, which has no prefilter
+func BenchmarkProcessContentShippedGo(b *testing.B) {
+	benchmarkProcessContent(b, loadShippedConfig(b), "go", true)
+}
+
+func BenchmarkProcessContentShippedJavaScript(b *testing.B) {
+	benchmarkProcessContent(b, loadShippedConfig(b), "javascript", true)
+}
+
+func BenchmarkProcessContentShippedJavaScriptNoPrefilter(b *testing.B) {
+	benchmarkProcessContent(b, loadShippedConfig(b), "javascript", false)
+}
+
+func BenchmarkProcessContentLargeRuleSet(b *testing.B) {
+	benchmarkProcessContent(b, Config{Languages: map[string]Language{"large": largeRuleSet()}}, "large", true)
+}
+
+func BenchmarkProcessContentLargeRuleSetNoPrefilter(b *testing.B) {
+	benchmarkProcessContent(b, Config{Languages: map[string]Language{"large": largeRuleSet()}}, "large", false)
+}
@@ -0,0 +1,70 @@
+package highlighter
+
+import (
+	"strings"
+
+	"github.com/AmirMahdyJebreily/hili-cat/pkg/ansi"
+)
+
+// Formatter renders highlighted lines in an output format.
+// Begin and End wrap the whole output; styles maps the semantic style names
+// of the highlighted language to their styles, for formats that write a
+// stylesheet. FormatLine renders a single line without its line ending, and
+// style returns the style configured for a token.
+type Formatter interface {
+	Begin(styles map[string]ansi.Style) string
+	FormatLine(line string, tokens []Token, style func(Token) ansi.Style) string
+	End() string
+}
+
+// ANSIFormatter renders lines with ANSI escape codes for terminals.
+// Colors are downsampled to Colors, and written as given for ColorsTrue.
+type ANSIFormatter struct {
+	Colors ansi.ColorDepth
+}
+
+// Begin returns nothing, since terminal output needs no header
+func (ANSIFormatter) Begin(styles map[string]ansi.Style) string {
+	return ""
+}
+
+// FormatLine renders the tokens of a line as ANSI escape codes
+func (f ANSIFormatter) FormatLine(line string, tokens []Token, style func(Token) ansi.Style) string {
+	// If no matches, return the original line
+	if len(tokens) == 0 {
+		return line
+	}
+
+	var result strings.Builder
+	lastPos := 0
+
+	for _, token := range tokens {
+		// Add text before the token
+		if token.Start > lastPos {
+			result.WriteString(line[lastPos:token.Start])
+		}
+
+		// Add styled token
+		if code := style(token).Code(f.Colors); code != "" {
+			result.WriteString(code)
+			result.WriteString(line[token.Start:token.End])
+			result.WriteString(Reset)
+		} else {
+			result.WriteString(line[token.Start:token.End])
+		}
+
+		lastPos = token.End
+	}
+
+	// Add any remaining text
+	if lastPos < len(line) {
+		result.WriteString(line[lastPos:])
+	}
+
+	return result.String()
+}
+
+// End returns nothing, since terminal output needs no footer
+func (ANSIFormatter) End() string {
+	return ""
+}
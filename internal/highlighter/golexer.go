@@ -0,0 +1,291 @@
+package highlighter
+
+import (
+	"go/scanner"
+	"go/token"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Identifiers the Go lexer styles by what they are predeclared as
+var (
+	goTypes = map[string]bool{
+		"any": true, "bool": true, "byte": true, "comparable": true, "complex64": true, "complex128": true,
+		"error": true, "float32": true, "float64": true, "int": true, "int8": true, "int16": true,
+		"int32": true, "int64": true, "rune": true, "string": true, "uint": true, "uint8": true,
+		"uint16": true, "uint32": true, "uint64": true, "uintptr": true,
+	}
+	goConstants = map[string]bool{"true": true, "false": true, "nil": true, "iota": true}
+	goBuiltins  = map[string]bool{
+		"append": true, "cap": true, "clear": true, "close": true, "complex": true, "copy": true,
+		"delete": true, "imag": true, "len": true, "make": true, "max": true, "min": true, "new": true,
+		"panic": true, "print": true, "println": true, "real": true, "recover": true,
+	}
+)
+
+// Patterns the Go lexer styles inside strings and comments
+var (
+	goEscape     = regexp.MustCompile(`\\([abfnrtv\\'"]|[0-7]{3}|x[0-9a-fA-F]{2}|u[0-9a-fA-F]{4}|U[0-9a-fA-F]{8})`)
+	goFormatVerb = regexp.MustCompile(`%[-+# 0]*(\*|\d+)?(\.(\*|\d+))?[vTtbcdoOqxXUeEfFgGsp%]`)
+	goTodo       = regexp.MustCompile(`\b(TODO|FIXME|XXX|HACK|BUG|NOTE)\b:?`)
+)
+
+// goToken is a token found by go/scanner, with its offsets in the line.
+// Raw is set for raw strings, including the part of one on a later line.
+type goToken struct {
+	start int
+	end   int
+	tok   token.Token
+	lit   string
+	raw   bool
+}
+
+// GoLexer tokenizes Go source with go/scanner, so raw strings, rune literals
+// and number literals are split exactly as the compiler splits them. Raw
+// strings and block comments carry over from one line to the next. Semantic
+// holds the styles of identifiers by position, when the whole file is known.
+type GoLexer struct {
+	language string
+	open     token.Token
+	line     int
+	semantic map[goPosition]string
+}
+
+// NewGoLexer returns a Go lexer whose tokens belong to the configured language lang
+func NewGoLexer(lang string) *GoLexer {
+	return &GoLexer{language: lang, open: token.ILLEGAL}
+}
+
+// Tokenize returns the tokens of the next line
+func (l *GoLexer) Tokenize(line string) []Token {
+	var tokens []Token
+	start := 0
+	l.line++
+
+	// Finish the raw string or block comment left open by the previous line
+	if open := l.open; open != token.ILLEGAL {
+		closing := "`"
+		if open == token.COMMENT {
+			closing = "*/"
+		}
+		end := len(line)
+		if i := strings.Index(line, closing); i >= 0 {
+			end = i + len(closing)
+			l.open = token.ILLEGAL
+		}
+		tokens = l.appendToken(tokens, line, goToken{start: 0, end: end, tok: open, raw: open == token.STRING}, "")
+		if l.open != token.ILLEGAL {
+			return tokens
+		}
+		start = end
+	}
+
+	scanned := l.scan(line, start)
+	for i, t := range scanned {
+		style, ok := l.semantic[goPosition{line: l.line, column: t.start + 1}]
+		if !ok || t.tok != token.IDENT {
+			style = identStyle(scanned, i)
+		}
+		tokens = l.appendToken(tokens, line, t, style)
+	}
+	return tokens
+}
+
+// Reset closes an open raw string or block comment and starts counting lines afresh
+func (l *GoLexer) Reset() {
+	l.open = token.ILLEGAL
+	l.line = 0
+}
+
+// scan splits the line from start into go/scanner tokens, leaving out the
+// semicolons the scanner inserts at the end of the line, and notes a raw string
+// or block comment that the line leaves open
+func (l *GoLexer) scan(line string, start int) []goToken {
+	src := line[start:]
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+
+	var s scanner.Scanner
+	s.Init(file, []byte(src), nil, scanner.ScanComments)
+
+	var tokens []goToken
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			return tokens
+		}
+		if tok == token.SEMICOLON && lit == "\n" {
+			continue
+		}
+
+		offset := file.Offset(pos)
+		raw := false
+		end := offset + len(lit)
+		if lit == "" {
+			end = offset + len(tok.String())
+		}
+
+		// The literals of raw strings and comments leave out carriage returns,
+		// so their ends are found in the source
+		switch {
+		case tok == token.STRING && src[offset] == '`':
+			raw = true
+			end = len(src)
+			if i := strings.IndexByte(src[offset+1:], '`'); i >= 0 {
+				end = offset + i + 2
+			} else {
+				l.open = token.STRING
+			}
+		case tok == token.COMMENT && strings.HasPrefix(src[offset:], "/*"):
+			end = len(src)
+			if i := strings.Index(src[offset+2:], "*/"); i >= 0 {
+				end = offset + i + 4
+			} else {
+				l.open = token.COMMENT
+			}
+		case tok == token.COMMENT:
+			end = len(src)
+		case tok == token.ILLEGAL:
+			// An invalid byte comes back as the 3 bytes of U+FFFD
+			_, size := utf8.DecodeRuneInString(src[offset:])
+			end = offset + size
+		}
+		end = min(end, len(src))
+
+		tokens = append(tokens, goToken{start: start + offset, end: start + end, tok: tok, lit: lit, raw: raw})
+	}
+}
+
+// identStyle returns the style of the identifier at index i of the tokens of
+// a line, or an empty string for other tokens and plain identifiers
+func identStyle(tokens []goToken, i int) string {
+	t := tokens[i]
+	if t.tok != token.IDENT {
+		return ""
+	}
+	if i > 0 && tokens[i-1].tok == token.PERIOD {
+		// A selector, such as a field or a function of a package
+		return ""
+	}
+	next := token.ILLEGAL
+	if i+1 < len(tokens) {
+		next = tokens[i+1].tok
+	}
+
+	switch {
+	case isGoFuncName(tokens, i, next):
+		return "function"
+	case goTypes[t.lit]:
+		return "type"
+	case goConstants[t.lit]:
+		return "constant"
+	case goBuiltins[t.lit] && next == token.LPAREN:
+		return "builtin"
+	}
+	return ""
+}
+
+// isGoFuncName reports whether the identifier at index i of the tokens of a
+// line names the function or method declared by a preceding func keyword
+func isGoFuncName(tokens []goToken, i int, next token.Token) bool {
+	if i == 0 {
+		return false
+	}
+	if tokens[i-1].tok == token.FUNC {
+		return true
+	}
+
+	// A method name follows the receiver in parentheses, and is followed by its
+	// parameters or type parameters. Only method declarations start a line
+	// with func and a parenthesis.
+	if tokens[i-1].tok != token.RPAREN || (next != token.LPAREN && next != token.LBRACK) {
+		return false
+	}
+	depth := 0
+	for j := i - 1; j > 0; j-- {
+		switch tokens[j].tok {
+		case token.RPAREN:
+			depth++
+		case token.LPAREN:
+			depth--
+			if depth == 0 {
+				return j == 1 && tokens[0].tok == token.FUNC
+			}
+		}
+	}
+	return false
+}
+
+// appendToken appends the tokens of a go/scanner token to tokens, splitting
+// strings and comments at the escapes, format verbs and to-do markers inside
+// them. Identifiers get identStyle, and operators and plain identifiers get no token.
+func (l *GoLexer) appendToken(tokens []Token, line string, t goToken, identStyle string) []Token {
+	style := ""
+	var children []*regexp.Regexp
+	var childStyles []string
+	text := line[t.start:t.end]
+
+	switch {
+	case t.tok.IsKeyword():
+		style = "keyword"
+	case t.tok == token.IDENT:
+		style = identStyle
+	case t.tok == token.INT || t.tok == token.FLOAT || t.tok == token.IMAG:
+		style = "number"
+	case t.tok == token.CHAR:
+		style = "string"
+		children, childStyles = []*regexp.Regexp{goEscape}, []string{"string.escape"}
+	case t.tok == token.STRING:
+		style = "string"
+		if !t.raw {
+			children = []*regexp.Regexp{goEscape, goFormatVerb}
+			childStyles = []string{"string.escape", "string.format"}
+		}
+	case t.tok == token.COMMENT:
+		style = "comment"
+		children, childStyles = []*regexp.Regexp{goTodo}, []string{"comment.todo"}
+	}
+	if style == "" || t.start == t.end {
+		return tokens
+	}
+
+	rule := t.tok.String()
+	parent := Token{Start: t.start, End: t.end, Rule: rule, Style: style, Language: l.language}
+
+	// Find the child matches, dropping those that overlap an earlier one
+	var parts []Token
+	for i, pattern := range children {
+		for _, match := range pattern.FindAllStringIndex(text, -1) {
+			parts = append(parts, Token{
+				Start:    t.start + match[0],
+				End:      t.start + match[1],
+				Rule:     rule,
+				Style:    childStyles[i],
+				Language: l.language,
+				Parents:  []string{style},
+			})
+		}
+	}
+	sort.SliceStable(parts, func(i, j int) bool { return parts[i].Start < parts[j].Start })
+
+	last := t.start
+	for _, part := range parts {
+		if part.Start < last {
+			continue
+		}
+		if part.Start > last {
+			piece := parent
+			piece.Start, piece.End = last, part.Start
+			tokens = append(tokens, piece)
+		}
+		tokens = append(tokens, part)
+		last = part.End
+	}
+	if t.end > last {
+		parent.Start = last
+		tokens = append(tokens, parent)
+	}
+	return tokens
+}
@@ -0,0 +1,97 @@
+package highlighter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestGoLexer(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "declarations",
+			input: "func (s *Server) Run[T any](n int) error {\n",
+			want:  []string{"func:keyword", "Run:function", "any:type", "int:type", "error:type"},
+		},
+		{
+			name:  "function literal",
+			input: "f := func(x int) error { return nil }\n",
+			want:  []string{"func:keyword", "int:type", "error:type", "return:keyword", "nil:constant"},
+		},
+		{
+			name:  "builtins and selectors",
+			input: "n := len(x) + s.len(y) + len\n",
+			want:  []string{"len:builtin"},
+		},
+		{
+			name:  "numbers",
+			input: "x := 1_000 + 0x1p-2 + 1.5i + 0o17 + .5\n",
+			want:  []string{"1_000:number", "0x1p-2:number", "1.5i:number", "0o17:number", ".5:number"},
+		},
+		{
+			name:  "runes and strings",
+			input: "r, s := '\\'', \"a\\tb%-5d\"\n",
+			want: []string{
+				"':string", "\\':string.escape", "':string",
+				"\"a:string", "\\t:string.escape", "b:string", "%-5d:string.format", "\":string",
+			},
+		},
+		{
+			name:  "raw string across lines",
+			input: "s := `a\\n\nb` + `c`\n",
+			want:  []string{"`a\\n:string", "b`:string", "`c`:string"},
+		},
+		{
+			name:  "block comment across lines",
+			input: "x /* TODO\nstill */ y // FIXME: z\n",
+			want: []string{
+				"/* :comment", "TODO:comment.todo", "still */:comment",
+				"// :comment", "FIXME::comment.todo", " z:comment",
+			},
+		},
+		{
+			name:  "comment markers inside strings",
+			input: "s := \"/*\" + \"`\"\n",
+			want:  []string{"\"/*\":string", "\"`\":string"},
+		},
+		{
+			name:  "invalid UTF-8",
+			input: "a\xff + 1\n\xff\n",
+			want:  []string{"1:number"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lexer := NewGoLexer("go")
+			var got []string
+			for _, line := range strings.Split(strings.TrimSuffix(tt.input, "\n"), "\n") {
+				for _, token := range lexer.Tokenize(line) {
+					if token.Language != "go" {
+						t.Errorf("token %+v has language %q", token, token.Language)
+					}
+					got = append(got, line[token.Start:token.End]+":"+token.Style)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGoLexerReset(t *testing.T) {
+	lexer := NewGoLexer("go")
+	lexer.Tokenize("/* open")
+	lexer.Reset()
+
+	got := lexer.Tokenize("return")
+	want := []Token{{Start: 0, End: 6, Rule: "return", Style: "keyword", Language: "go"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize() after Reset() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,168 @@
+package highlighter
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path"
+	"strings"
+)
+
+// goPosition is the line and byte column of an identifier, both counted from 1
+type goPosition struct {
+	line   int
+	column int
+}
+
+// NewSemanticGoLexer returns a Go lexer that styles identifiers by what they
+// denote, as found by parsing and type-checking src, the whole file. Other
+// tokens, and identifiers the type checker knows nothing about, are styled as
+// by NewGoLexer.
+func NewSemanticGoLexer(lang, filename string, src []byte) *GoLexer {
+	lexer := NewGoLexer(lang)
+	lexer.semantic = analyzeGo(filename, src)
+	return lexer
+}
+
+// analyzeGo parses and type-checks a Go file and returns the styles of its
+// identifiers by position. Type-checking is best-effort: errors are ignored,
+// and imports that cannot be found are replaced by empty packages.
+func analyzeGo(filename string, src []byte) map[goPosition]string {
+	fset := token.NewFileSet()
+	file, _ := parser.ParseFile(fset, filename, src, parser.SkipObjectResolution)
+	if file == nil {
+		return nil
+	}
+
+	info := &types.Info{
+		Defs: make(map[*ast.Ident]types.Object),
+		Uses: make(map[*ast.Ident]types.Object),
+	}
+	conf := types.Config{Importer: newGoImporter(), Error: func(error) {}}
+	conf.Check(file.Name.Name, fset, []*ast.File{file}, info)
+
+	params := goParams(file, info)
+	used := make(map[types.Object]bool, len(info.Uses))
+	for _, obj := range info.Uses {
+		used[obj] = true
+	}
+
+	styles := make(map[goPosition]string, len(info.Defs)+len(info.Uses))
+	add := func(id *ast.Ident, style string) {
+		if style == "" || id.Name == "_" {
+			return
+		}
+		// Positions ignore //line directives, which the highlighter does not follow
+		pos := fset.PositionFor(id.Pos(), false)
+		styles[goPosition{line: pos.Line, column: pos.Column}] = style
+	}
+
+	add(file.Name, "namespace")
+	for id, obj := range info.Defs {
+		if obj != nil {
+			add(id, objectStyle(obj, params, used))
+		}
+	}
+	// An embedded field is both defined and used, and is styled as the type it uses
+	for id, obj := range info.Uses {
+		add(id, objectStyle(obj, params, used))
+	}
+	return styles
+}
+
+// goParams returns the receivers, parameters and results of the functions of a file
+func goParams(file *ast.File, info *types.Info) map[types.Object]bool {
+	params := make(map[types.Object]bool)
+	ast.Inspect(file, func(n ast.Node) bool {
+		var lists []*ast.FieldList
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			lists = []*ast.FieldList{n.Recv, n.Type.Params, n.Type.Results}
+		case *ast.FuncLit:
+			lists = []*ast.FieldList{n.Type.Params, n.Type.Results}
+		}
+		for _, list := range lists {
+			if list == nil {
+				continue
+			}
+			for _, field := range list.List {
+				for _, name := range field.Names {
+					if obj := info.Defs[name]; obj != nil {
+						params[obj] = true
+					}
+				}
+			}
+		}
+		return true
+	})
+	return params
+}
+
+// objectStyle returns the style of an identifier that denotes obj
+func objectStyle(obj types.Object, params, used map[types.Object]bool) string {
+	switch obj := obj.(type) {
+	case *types.PkgName:
+		return "namespace"
+	case *types.TypeName:
+		return "type"
+	case *types.Const, *types.Nil:
+		return "constant"
+	case *types.Builtin:
+		return "builtin"
+	case *types.Func:
+		if sig, ok := obj.Type().(*types.Signature); ok && sig.Recv() != nil {
+			return "function.method"
+		}
+		return "function"
+	case *types.Var:
+		switch {
+		case obj.IsField():
+			return "property"
+		case params[obj]:
+			return "variable.parameter"
+		case obj.Pkg() != nil && obj.Parent() == obj.Pkg().Scope():
+			return "variable"
+		case !used[obj]:
+			return "variable.unused"
+		}
+		return "variable.local"
+	}
+	return ""
+}
+
+// goImporter imports packages from the export data of the Go installation when
+// it can, and otherwise fakes an empty package so type-checking carries on
+type goImporter struct {
+	export types.Importer
+	fakes  map[string]*types.Package
+}
+
+// newGoImporter returns an importer for best-effort type-checking
+func newGoImporter() *goImporter {
+	return &goImporter{export: importer.Default(), fakes: make(map[string]*types.Package)}
+}
+
+// Import returns the package with an import path
+func (i *goImporter) Import(importPath string) (*types.Package, error) {
+	if pkg, err := i.export.Import(importPath); err == nil {
+		return pkg, nil
+	}
+	if pkg, ok := i.fakes[importPath]; ok {
+		return pkg, nil
+	}
+
+	// Guess the package name from the path, skipping a major version suffix
+	name := path.Base(importPath)
+	if len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = path.Base(path.Dir(importPath))
+	}
+	name = strings.TrimPrefix(strings.TrimSuffix(name, "-go"), "go-")
+	name = strings.ReplaceAll(name, "-", "_")
+
+	pkg := types.NewPackage(importPath, name)
+	pkg.MarkComplete()
+	i.fakes[importPath] = pkg
+	return pkg, nil
+}
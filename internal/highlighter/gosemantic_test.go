@@ -0,0 +1,86 @@
+package highlighter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSemanticGoLexer(t *testing.T) {
+	src := `package demo
+
+import (
+	"os"
+	"strings"
+	nope "example.com/missing/v2"
+)
+
+const limit = 3
+
+var count int
+
+type point struct{ x, y int }
+
+func (p point) norm(scale int) (n int) {
+	unused := 1
+	total := p.x * scale
+	return total + len(strings.Fields(nope.Name)) + limit + count + len(os.Args)
+}
+`
+	lexer := NewSemanticGoLexer("go", "demo.go", []byte(src))
+	got := map[string][]string{}
+	for _, line := range strings.Split(src, "\n") {
+		for _, token := range lexer.Tokenize(line) {
+			if token.Rule == "IDENT" {
+				text := line[token.Start:token.End]
+				got[text] = append(got[text], token.Style)
+			}
+		}
+	}
+
+	want := map[string][]string{
+		"demo":    {"namespace"},
+		"nope":    {"namespace", "namespace"},
+		"limit":   {"constant", "constant"},
+		"count":   {"variable", "variable"},
+		"point":   {"type", "type"},
+		"x":       {"property", "property"},
+		"y":       {"property"},
+		"int":     {"type", "type", "type", "type"},
+		"p":       {"variable.parameter", "variable.parameter"},
+		"norm":    {"function.method"},
+		"scale":   {"variable.parameter", "variable.parameter"},
+		"n":       {"variable.parameter"},
+		"unused":  {"variable.unused"},
+		"total":   {"variable.local", "variable.local"},
+		"len":     {"builtin", "builtin"},
+		"strings": {"namespace"},
+		"os":      {"namespace"},
+		"Args":    {"variable"},
+		"Fields":  {"function"},
+		"Name":    nil,
+	}
+	for name, styles := range want {
+		if !reflect.DeepEqual(got[name], styles) {
+			t.Errorf("styles of %s = %q, want %q", name, got[name], styles)
+		}
+	}
+}
+
+func TestSemanticGoLexerSqueezeBlank(t *testing.T) {
+	src := "package demo\n\n\n\nfunc half(a int) int {\n\treturn a / 2\n}\n"
+	cfg := Config{
+		Languages: map[string]Language{"go": {Lexer: LexerGo}},
+		Theme:     map[string]string{"variable.parameter": "red"},
+	}
+	opts := Options{SqueezeBlank: true, Lexer: NewSemanticGoLexer("go", "demo.go", []byte(src))}
+	h, err := NewHighlighter(cfg, "go", LF, opts)
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+
+	// The squeezed lines still count, so the parameter keeps its style
+	if got := strings.Count(h.ProcessContent([]byte(src)), Red+"a"+Reset); got != 2 {
+		t.Errorf("parameter styled %d times, want 2", got)
+	}
+}
@@ -0,0 +1,94 @@
+package highlighter
+
+import (
+	"strings"
+
+	"github.com/AmirMahdyJebreily/hili-cat/pkg/diff"
+)
+
+// Gutter annotates the lines of the output in a column between their line
+// numbers and their text, such as with the changes made to a file since it
+// was committed
+type Gutter interface {
+	// Annotate returns the gutter of a line of the content, counted from 1,
+	// and the tokens that style it. The gutters of all lines should be equally
+	// wide, so the text after them stays aligned.
+	Annotate(line int) (string, []Token)
+}
+
+// Marks of a ChangeGutter
+const (
+	changeBar          = "┃"
+	changeDeletedAbove = "▔"
+	changeDeletedBelow = "▁"
+)
+
+// change is the mark of a line in a ChangeGutter, and its style
+type change struct {
+	mark  string
+	style string
+}
+
+// ChangeGutter marks the lines of a file that changed since an older version
+// of it, as editors do: lines added with ┃ styled as gutter.added, lines
+// modified with ┃ styled as gutter.modified, and the line after deleted lines
+// with ▔ styled as gutter.deleted, or the last line with ▁ when the deleted
+// lines ended the file.
+type ChangeGutter struct {
+	changes []change
+}
+
+// NewChangeGutter compares the lines of the old and new versions of a file and
+// returns a gutter for the new one
+func NewChangeGutter(old, new []byte) *ChangeGutter {
+	newLines := sourceLines(new)
+	g := &ChangeGutter{changes: make([]change, len(newLines))}
+	edits := diff.Diff(sourceLines(old), newLines)
+	for i, edit := range edits {
+		switch edit.Op {
+		case diff.Insert:
+			style := "gutter.added"
+			if i > 0 && edits[i-1].Op == diff.Delete {
+				style = "gutter.modified"
+			}
+			for n := edit.B; n < edit.B+edit.Len; n++ {
+				g.changes[n] = change{mark: changeBar, style: style}
+			}
+		case diff.Delete:
+			if i+1 < len(edits) && edits[i+1].Op == diff.Insert {
+				continue
+			}
+			// A line keeps the mark it has, so lines that were added or
+			// modified are not hidden by lines deleted next to them
+			switch {
+			case edit.B < len(newLines):
+				g.changes[edit.B] = change{mark: changeDeletedAbove, style: "gutter.deleted"}
+			case edit.B > 0 && g.changes[edit.B-1].mark == "":
+				g.changes[edit.B-1] = change{mark: changeDeletedBelow, style: "gutter.deleted"}
+			}
+		}
+	}
+	return g
+}
+
+// Annotate returns the mark of a line followed by a space, or two spaces for
+// lines that did not change
+func (g *ChangeGutter) Annotate(line int) (string, []Token) {
+	if line < 1 || line > len(g.changes) || g.changes[line-1].mark == "" {
+		return "  ", nil
+	}
+	c := g.changes[line-1]
+	return c.mark + " ", []Token{{Start: 0, End: len(c.mark), Line: line, Rule: "change", Style: c.style}}
+}
+
+// sourceLines splits a source into lines, without their line endings
+func sourceLines(src []byte) []string {
+	if len(src) == 0 {
+		return nil
+	}
+	lines := strings.Split(strings.TrimSuffix(string(src), "\n"), "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return lines
+}
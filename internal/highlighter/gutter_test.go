@@ -0,0 +1,79 @@
+package highlighter
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestChangeGutter(t *testing.T) {
+	tests := []struct {
+		name string
+		old  string
+		new  string
+		want []string
+	}{
+		{
+			name: "added and modified",
+			old:  "a\nb\nc\n",
+			new:  "a\nB\nc\nd\n",
+			want: []string{"  ", "┃ :gutter.modified", "  ", "┃ :gutter.added"},
+		},
+		{
+			name: "deleted above and at the end",
+			old:  "a\nb\nc\nd\n",
+			new:  "a\nc\n",
+			want: []string{"  ", "▔ :gutter.deleted"},
+		},
+		{
+			name: "deleted at the end",
+			old:  "a\r\nb\r\n",
+			new:  "a\r\n",
+			want: []string{"▁ :gutter.deleted"},
+		},
+		{
+			name: "new file",
+			old:  "",
+			new:  "\n",
+			want: []string{"┃ :gutter.added"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gutter := NewChangeGutter([]byte(tt.old), []byte(tt.new))
+			var got []string
+			for line := 1; line <= len(sourceLines([]byte(tt.new))); line++ {
+				text, tokens := gutter.Annotate(line)
+				for _, token := range tokens {
+					text += ":" + token.Style
+				}
+				got = append(got, text)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Annotate() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGutterWithLineNumbers(t *testing.T) {
+	cfg := Config{
+		Languages: map[string]Language{"text": {}},
+		Theme:     map[string]string{"gutter.added": "green"},
+	}
+	opts := Options{
+		NumberLines:  true,
+		SqueezeBlank: true,
+		Gutter:       NewChangeGutter([]byte("a\n\n\nb\n"), []byte("a\n\n\nb\nc\n")),
+	}
+	h, err := NewHighlighter(cfg, "text", LF, opts)
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+
+	// The squeezed blank line still counts, so c keeps its mark
+	want := "    1    a\n    2    \n    3    b\n    4  " + Green + "┃" + Reset + " c\n"
+	if got := h.ProcessContent([]byte("a\n\n\nb\nc\n")); got != want {
+		t.Errorf("ProcessContent() = %q, want %q", got, want)
+	}
+}
@@ -4,31 +4,48 @@ import (
 	"fmt"
 	"regexp"
 	"sort"
+	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	"github.com/AmirMahdyJebreily/hili-cat/pkg/ansi"
+	"github.com/AmirMahdyJebreily/hili-cat/pkg/backtrack"
 )
 
 // ANSI color codes
 const (
-	Reset     = "\033[0m"
-	Bold      = "\033[1m"
-	Italic    = "\033[3m"
-	Underline = "\033[4m"
-	Black     = "\033[30m"
-	Red       = "\033[31m"
-	Green     = "\033[32m"
-	Yellow    = "\033[33m"
-	Blue      = "\033[34m"
-	Magenta   = "\033[35m"
-	Cyan      = "\033[36m"
-	White     = "\033[37m"
-	BgBlack   = "\033[40m"
-	BgRed     = "\033[41m"
-	BgGreen   = "\033[42m"
-	BgYellow  = "\033[43m"
-	BgBlue    = "\033[44m"
-	BgMagenta = "\033[45m"
-	BgCyan    = "\033[46m"
-	BgWhite   = "\033[47m"
+	Reset     = ansi.Reset
+	Bold      = ansi.Bold
+	Italic    = ansi.Italic
+	Underline = ansi.Underline
+	Black     = ansi.Black
+	Red       = ansi.Red
+	Green     = ansi.Green
+	Yellow    = ansi.Yellow
+	Blue      = ansi.Blue
+	Magenta   = ansi.Magenta
+	Cyan      = ansi.Cyan
+	White     = ansi.White
+	BgBlack   = ansi.BgBlack
+	BgRed     = ansi.BgRed
+	BgGreen   = ansi.BgGreen
+	BgYellow  = ansi.BgYellow
+	BgBlue    = ansi.BgBlue
+	BgMagenta = ansi.BgMagenta
+	BgCyan    = ansi.BgCyan
+	BgWhite   = ansi.BgWhite
+)
+
+// Overlap policies decide which of several tokens starting at the same position wins
+const (
+	OverlapFirst   = "first"
+	OverlapLongest = "longest"
+)
+
+// Regex engines a rule can be matched with
+const (
+	EngineRE2          = "re2"
+	EngineBacktracking = "backtracking"
 )
 
 // Line ending constants
@@ -37,56 +54,129 @@ const (
 	CRLF = "\r\n"
 )
 
-// Config represents configuration data needed by the highlighter
+// Config represents configuration data needed by the highlighter.
+// Theme maps semantic style names to styles for all languages.
 type Config struct {
 	Languages map[string]Language
+	Theme     map[string]string
 }
 
-// Language represents the syntax highlighting rules for a specific language
+// Language represents the syntax highlighting rules for a specific language.
+// Styles override the styles of the theme for this language. Lexer selects
+// a built-in lexer instead of the regex rules, regions and states, Reformat
+// the reformatter that pretty-prints the source with Reformat, and Render the
+// renderer that lays it out for reading with Render.
 type Language struct {
 	Extensions []string
 	Rules      []HighlightRule
+	Regions    []Region
+	States     map[string]State
 	Styles     map[string]string
+	Overlap    string
+	Lexer      string
+	Reformat   string
+	Render     string
 }
 
-// HighlightRule defines a pattern to match and the style to apply
+// HighlightRule defines a pattern to match and the style to apply.
+// Captures maps capture groups, by number or name, to their own styles;
+// Style then applies to the parts of the match outside those groups.
+// Matches of a rule with a higher Priority win over overlapping matches
+// of rules with a lower one. Rules are child rules that only run inside
+// the match, such as escapes inside a string, and their styles are laid
+// over the style of the part of the match they are found in. Engine selects
+// the regex engine: RE2 by default, or the backtracking engine for patterns
+// that need lookaround or backreferences.
 type HighlightRule struct {
-	Name    string
-	Pattern string
-	Style   string
+	Name     string
+	Pattern  string
+	Style    string
+	Captures map[string]string
+	Priority int
+	Rules    []HighlightRule
+	Engine   string
+}
+
+// Matcher is a compiled pattern of one of the regex engines
+type Matcher interface {
+	FindAllStringIndex(s string, n int) [][]int
+	FindAllStringSubmatchIndex(s string, n int) [][]int
+	NumSubexp() int
+	SubexpIndex(name string) int
+	String() string
 }
 
 // CompiledRule is a compiled version of HighlightRule for better performance
 type CompiledRule struct {
-	Name    string
-	Pattern *regexp.Regexp
-	Style   string
+	Name     string
+	Pattern  Matcher
+	Style    string
+	Captures map[int]string
+	Priority int
+	Rules    []CompiledRule
+	filter   *prefilter
 }
 
-// Highlighter manages the syntax highlighting process
+// Highlighter manages the syntax highlighting process: it splits the content
+// into lines, has the lexer of the language tokenize them and formats the tokens
 type Highlighter struct {
 	config     Config
 	language   string
-	rules      []CompiledRule
+	lexer      Lexer
 	styles     map[string]string
+	parsed     map[string]map[string]ansi.Style
 	lineEnding string
+	line       int
+	source     int
 	lineNum    int
+	lastBlank  bool
+	begun      bool
+	held       string
 	options    Options
 }
 
-// Options contains settings for the highlighter
+// Options contains settings for the highlighter.
+// Formatter selects the output format, ANSI escape codes when nil.
+// Lexer, when set, tokenizes the content instead of the lexer the language selects.
+// Gutter, when set, annotates each line between its line number and its text.
 type Options struct {
 	NumberLines    bool
 	NumberNonBlank bool
 	SqueezeBlank   bool
 	ShowEnds       bool
+	Formatter      Formatter
+	Lexer          Lexer
+	Gutter         Gutter
 }
 
-// Token represents a matched section of text with styling information
+// Token is a styled section of the content, as returned by Tokenize.
+// Style is the semantic style name, such as "keyword" or the dotted scope
+// "string.escape", which the theme and the styles of Language map to a
+// color. It is empty for the parts of a match that its rule leaves unstyled.
+// Parents holds the styles of the tokens it was found inside, outermost
+// first, such as "string" for an escape found by a child rule of a string;
+// the token style is laid over theirs.
 type Token struct {
-	start int
-	end   int
-	style string
+	Start    int
+	End      int
+	Line     int
+	Rule     string
+	Style    string
+	Language string
+	Parents  []string
+}
+
+// span is a matched section of a line, found by a rule or region
+type span struct {
+	start    int
+	end      int
+	style    string
+	name     string
+	language string
+	parents  []string
+	parts    []span
+	rule     int
+	priority int
 }
 
 // NewHighlighter creates and initializes a new Highlighter
@@ -105,29 +195,166 @@ func NewHighlighter(cfg Config, lang, lineEnding string, opts Options) (*Highlig
 		options:    opts,
 	}
 
-	// Compile all regex patterns for better performance
-	for _, rule := range language.Rules {
-		pattern, err := regexp.Compile(rule.Pattern)
+	// Check the styles up front, so a typo is reported instead of dropped
+	for name, style := range cfg.Theme {
+		if _, err := ansi.ParseStyle(style); err != nil {
+			return nil, fmt.Errorf("invalid style %s in theme: %v", name, err)
+		}
+	}
+	for name, style := range language.Styles {
+		if _, err := ansi.ParseStyle(style); err != nil {
+			return nil, fmt.Errorf("invalid style %s for %s: %v", name, lang, err)
+		}
+	}
+
+	highlighter.lexer = opts.Lexer
+	if highlighter.lexer == nil {
+		lexer, err := newLexer(cfg, lang)
 		if err != nil {
-			return nil, fmt.Errorf("invalid regex pattern for %s: %v", rule.Name, err)
+			return nil, err
 		}
-		highlighter.rules = append(highlighter.rules, CompiledRule{
-			Name:    rule.Name,
-			Pattern: pattern,
-			Style:   rule.Style,
-		})
+		highlighter.lexer = lexer
 	}
 
 	return highlighter, nil
 }
 
-// ProcessContent processes the input data and returns highlighted output
+// compileRules compiles the regex patterns of a rule set
+func compileRules(rules []HighlightRule) ([]CompiledRule, error) {
+	var compiled []CompiledRule
+	for _, rule := range rules {
+		pattern, err := compilePattern(rule)
+		if err != nil {
+			return nil, err
+		}
+		captures, err := compileCaptures(rule, pattern)
+		if err != nil {
+			return nil, err
+		}
+		children, err := compileRules(rule.Rules)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, CompiledRule{
+			Name:     rule.Name,
+			Pattern:  pattern,
+			Style:    rule.Style,
+			Captures: captures,
+			Priority: rule.Priority,
+			Rules:    children,
+			filter:   ruleFilter(children),
+		})
+	}
+	return compiled, nil
+}
+
+// compilePattern compiles the pattern of a rule with the engine it selects
+func compilePattern(rule HighlightRule) (Matcher, error) {
+	var pattern Matcher
+	var err error
+	switch rule.Engine {
+	case "", EngineRE2:
+		pattern, err = regexp.Compile(rule.Pattern)
+	case EngineBacktracking:
+		pattern, err = backtrack.Compile(rule.Pattern)
+	default:
+		return nil, fmt.Errorf("unknown regex engine %s for %s: must be %s or %s",
+			rule.Engine, rule.Name, EngineRE2, EngineBacktracking)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex pattern for %s: %v", rule.Name, err)
+	}
+	return pattern, nil
+}
+
+// ruleFilter builds the prefilter for the patterns of child rules, or returns
+// nil for a rule without children
+func ruleFilter(rules []CompiledRule) *prefilter {
+	if len(rules) == 0 {
+		return nil
+	}
+	patterns := make([]Matcher, len(rules))
+	for i, rule := range rules {
+		patterns[i] = rule.Pattern
+	}
+	return newPrefilter(patterns)
+}
+
+// compileCaptures resolves the capture group names and numbers of a rule to group indexes
+func compileCaptures(rule HighlightRule, pattern Matcher) (map[int]string, error) {
+	if len(rule.Captures) == 0 {
+		return nil, nil
+	}
+
+	captures := make(map[int]string, len(rule.Captures))
+	for group, style := range rule.Captures {
+		index, err := groupIndex(pattern, group)
+		if err != nil {
+			return nil, fmt.Errorf("unknown capture group %q for %s", group, rule.Name)
+		}
+		captures[index] = style
+	}
+	return captures, nil
+}
+
+// groupIndex resolves a capture group number or name to its index in pattern
+func groupIndex(pattern Matcher, group string) (int, error) {
+	index, err := strconv.Atoi(group)
+	if err != nil {
+		index = pattern.SubexpIndex(group)
+	}
+	if index < 1 || index > pattern.NumSubexp() {
+		return 0, fmt.Errorf("unknown capture group %q", group)
+	}
+	return index, nil
+}
+
+// ProcessContent processes the input data and returns highlighted output.
+// The data should end on a line boundary; a last line without a line ending is
+// written without one. Line numbers, blank line squeezing and open regions
+// carry over from one call to the next, and Finish ends the output. Lines a
+// Lookahead lexer holds back are written by the next call, or by Finish.
 func (h *Highlighter) ProcessContent(data []byte) string {
 	var buffer strings.Builder
-	var lineBuffer strings.Builder
-	var lastLineWasBlank bool
 
-	content := string(data)
+	// The first call opens the output document
+	if !h.begun {
+		buffer.WriteString(h.formatter().Begin(h.documentStyles()))
+		h.begun = true
+	}
+
+	content := h.held + string(data)
+	h.held = ""
+	if lookahead, ok := h.lexer.(Lookahead); ok {
+		content, h.held = h.holdBack(lookahead, content)
+	}
+	h.writeLines(&buffer, content)
+
+	return buffer.String()
+}
+
+// holdBack splits content into the part to highlight now, and the lines at its
+// end that the lexer needs the lines after to tokenize
+func (h *Highlighter) holdBack(lexer Lookahead, content string) (string, string) {
+	lines := strings.Split(content, h.lineEnding)
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	n := lexer.Lookahead(lines)
+	if n == 0 {
+		return content, ""
+	}
+
+	offset := 0
+	for _, line := range lines[:len(lines)-n] {
+		offset += len(line) + len(h.lineEnding)
+	}
+	return content[:offset], content[offset:]
+}
+
+// writeLines writes the highlighted lines of content to buffer
+func (h *Highlighter) writeLines(buffer *strings.Builder, content string) {
+	var lineBuffer strings.Builder
 	lines := strings.Split(content, h.lineEnding)
 
 	for i, line := range lines {
@@ -136,14 +363,18 @@ func (h *Highlighter) ProcessContent(data []byte) string {
 			break
 		}
 
+		h.source++
 		isBlankLine := len(strings.TrimSpace(line)) == 0
 
-		// Handle squeeze blank option
-		if h.options.SqueezeBlank && isBlankLine && lastLineWasBlank {
+		// Handle squeeze blank option. The lexer still sees the squeezed line,
+		// so its line count and state keep up with the content.
+		if h.options.SqueezeBlank && isBlankLine && h.lastBlank {
+			h.tokenizeLine(line)
 			continue
 		}
 
-		lastLineWasBlank = isBlankLine
+		h.lastBlank = isBlankLine
+		terminated := i < len(lines)-1
 
 		// Add line number if required
 		if h.options.NumberLines || (h.options.NumberNonBlank && !isBlankLine) {
@@ -155,137 +386,460 @@ func (h *Highlighter) ProcessContent(data []byte) string {
 			}
 		}
 
+		// Add the gutter of the line, counted in the content before squeezing
+		if h.options.Gutter != nil {
+			text, tokens := h.options.Gutter.Annotate(h.source)
+			lineBuffer.WriteString(h.formatter().FormatLine(text, tokens, h.styleValue))
+		}
+
 		// Add highlighted content
 		lineBuffer.WriteString(h.highlightLine(line))
 
 		// Add end marker if requested
-		if h.options.ShowEnds {
+		if h.options.ShowEnds && terminated {
 			lineBuffer.WriteString("$")
 		}
 
 		buffer.WriteString(lineBuffer.String())
-		buffer.WriteString(h.lineEnding)
+		if terminated {
+			buffer.WriteString(h.lineEnding)
+		}
 		lineBuffer.Reset()
 	}
+}
 
+// Finish returns the output that closes the document opened by ProcessContent,
+// such as the closing tags of HTML. It is called once after the last chunk.
+func (h *Highlighter) Finish() string {
+	var buffer strings.Builder
+	if !h.begun {
+		buffer.WriteString(h.formatter().Begin(h.documentStyles()))
+		h.begun = true
+	}
+	if h.held != "" {
+		h.writeLines(&buffer, h.held)
+		h.held = ""
+	}
+	buffer.WriteString(h.formatter().End())
 	return buffer.String()
 }
 
+// Tokenize splits the input data into lines and returns the tokens of all of
+// them, with byte offsets relative to data. Like ProcessContent, it expects data
+// to end on a line boundary, and open regions and line numbers carry over from
+// one call to the next. Text between tokens is not styled.
+func (h *Highlighter) Tokenize(data []byte) []Token {
+	var tokens []Token
+
+	content := string(data)
+	lines := strings.Split(content, h.lineEnding)
+	if lookahead, ok := h.lexer.(Lookahead); ok {
+		lookahead.Lookahead(lines)
+	}
+
+	offset := 0
+	for i, line := range lines {
+		if i == len(lines)-1 && line == "" {
+			break
+		}
+
+		for _, token := range h.tokenizeLine(line) {
+			token.Start += offset
+			token.End += offset
+			tokens = append(tokens, token)
+		}
+		offset += len(line) + len(h.lineEnding)
+	}
+
+	return tokens
+}
+
+// Err returns the first syntax error found by the lexer in the content so far,
+// or nil when there is none or the lexer does not check the syntax
+func (h *Highlighter) Err() error {
+	if checker, ok := h.lexer.(SyntaxChecker); ok {
+		return checker.Err()
+	}
+	return nil
+}
+
 // highlightLine applies syntax highlighting to a single line
 func (h *Highlighter) highlightLine(line string) string {
-	var tokens []Token
+	return h.formatter().FormatLine(line, h.tokenizeLine(line), h.styleValue)
+}
+
+// tokenizeLine returns the tokens of a single line, with offsets relative to the line
+func (h *Highlighter) tokenizeLine(line string) []Token {
+	h.line++
+	tokens := h.lexer.Tokenize(line)
+	for i := range tokens {
+		tokens[i].Line = h.line
+	}
+	return tokens
+}
+
+// ruleTokens finds all matches of a rule set in text, offsetting them by offset.
+// The prefilter scan of text decides which rules run and where.
+func (l *RegexLexer) ruleTokens(rules []CompiledRule, scan *scanResult, text string, offset int) []span {
+	var candidates []span
 
 	// Find all matches for all rules
-	for _, rule := range h.rules {
-		matches := rule.Pattern.FindAllStringIndex(line, -1)
-		for _, match := range matches {
-			styleCode := ""
-			if styleName, ok := h.styles[rule.Style]; ok {
-				styleCode = h.ansiStyle(styleName)
+	for i, rule := range rules {
+		for _, match := range scan.findAll(i, rule.Pattern, text, rule.Captures != nil) {
+			candidates = append(candidates, l.ruleToken(rule, i, match, offset))
+		}
+	}
+
+	// Sort tokens by start position and handle overlapping tokens
+	tokens, lost := l.sortAndFilterTokens(candidates)
+
+	// The matches of a rule do not overlap each other, so a match that loses
+	// to another token can hide a later one, such as a comment starting inside
+	// a string that swallows the real comment after it. Such rules search
+	// again from the end of the token that won, until nothing new turns up.
+	if len(lost) > 0 {
+		seen := make(map[[3]int]bool, len(candidates))
+		for _, token := range candidates {
+			seen[[3]int{token.rule, token.start, token.end}] = true
+		}
+		searched := make(map[resumePoint]bool)
+		for len(lost) > 0 {
+			for _, point := range lost {
+				if searched[point] {
+					continue
+				}
+				searched[point] = true
+				rule := rules[point.rule]
+				for _, match := range findFrom(rule.Pattern, text, point.from-offset, rule.Captures != nil) {
+					token := l.ruleToken(rule, point.rule, match, offset)
+					if key := [3]int{token.rule, token.start, token.end}; !seen[key] {
+						seen[key] = true
+						candidates = append(candidates, token)
+					}
+				}
 			}
 
-			tokens = append(tokens, Token{
-				start: match[0],
-				end:   match[1],
-				style: styleCode,
-			})
+			tokens, lost = l.sortAndFilterTokens(candidates)
+			unsearched := lost[:0]
+			for _, point := range lost {
+				if !searched[point] {
+					unsearched = append(unsearched, point)
+				}
+			}
+			lost = unsearched
 		}
 	}
 
-	// If no matches, return the original line
-	if len(tokens) == 0 {
-		return line
+	// Child rules only run inside the tokens that are kept
+	for i, token := range tokens {
+		if rule := rules[token.rule]; len(rule.Rules) > 0 {
+			tokens[i].parts = l.childParts(rule, token, text, offset)
+		}
 	}
-	// Sort tokens by start position and handle overlapping tokens
-	h.sortAndFilterTokens(&tokens)
+	return tokens
+}
 
-	var result strings.Builder
-	lastPos := 0
+// childParts runs the child rules of a rule inside each part of its token,
+// and returns the parts of the token split at the child tokens, which get the
+// style of the part they were found in as parent
+func (l *RegexLexer) childParts(rule CompiledRule, token span, text string, offset int) []span {
+	var parts []span
+	for _, part := range expandCaptures([]span{token}) {
+		inner := text[part.start-offset : part.end-offset]
+		children := expandCaptures(l.ruleTokens(rule.Rules, rule.filter.scan(inner), inner, part.start))
+
+		last := part.start
+		for _, child := range children {
+			if child.start > last {
+				parts = append(parts, part.piece(last, child.start))
+			}
+			child.parents = withParent(part.style, child.parents)
+			parts = append(parts, child)
+			last = child.end
+		}
+		if part.end > last {
+			parts = append(parts, part.piece(last, part.end))
+		}
+	}
+	return parts
+}
 
-	for _, token := range tokens {
-		// Add text before the token
-		if token.start > lastPos {
-			result.WriteString(line[lastPos:token.start])
+// withParent returns parents with style added as the outermost parent,
+// unless it is empty
+func withParent(style string, parents []string) []string {
+	if style == "" {
+		return parents
+	}
+	return append([]string{style}, parents...)
+}
+
+// ruleToken builds the token of a match of rule i
+func (l *RegexLexer) ruleToken(rule CompiledRule, i int, match []int, offset int) span {
+	var token span
+	if rule.Captures != nil {
+		token = l.captureToken(rule, match, offset)
+	} else {
+		token = span{
+			start:    offset + match[0],
+			end:      offset + match[1],
+			style:    rule.Style,
+			name:     rule.Name,
+			language: l.language,
 		}
+	}
+	token.rule, token.priority = i, rule.Priority
+	return token
+}
+
+// findFrom returns the matches of pattern in text that start at from or later.
+// The rune before from is kept as context for patterns that look at it, such
+// as \b, so ^ does not match at from, unless a match starting in that rune
+// runs past from and hides the ones after it.
+func findFrom(pattern Matcher, text string, from int, submatch bool) [][]int {
+	find := pattern.FindAllStringIndex
+	if submatch {
+		find = pattern.FindAllStringSubmatchIndex
+	}
 
-		// Add styled token
-		if token.style != "" {
-			result.WriteString(token.style)
-			result.WriteString(line[token.start:token.end])
-			result.WriteString(Reset)
+	_, size := utf8.DecodeLastRuneInString(text[:from])
+	base := from - size
+	matches := find(text[base:], -1)
+	if len(matches) > 0 && matches[0][0] < size {
+		if matches[0][1] > size {
+			base = from
+			matches = find(text[from:], -1)
 		} else {
-			result.WriteString(line[token.start:token.end])
+			matches = matches[1:]
 		}
+	}
+	for _, match := range matches {
+		for j := range match {
+			if match[j] >= 0 {
+				match[j] += base
+			}
+		}
+	}
+	return matches
+}
 
-		lastPos = token.end
+// captureToken builds the token of a match whose capture groups have their own styles
+func (l *RegexLexer) captureToken(rule CompiledRule, match []int, offset int) span {
+	token := span{
+		start:    offset + match[0],
+		end:      offset + match[1],
+		style:    rule.Style,
+		name:     rule.Name,
+		language: l.language,
 	}
 
-	// Add any remaining text
-	if lastPos < len(line) {
-		result.WriteString(line[lastPos:])
+	// Groups are visited in order of their opening parenthesis, so an
+	// outer group wins over the groups nested inside it
+	lastEnd := token.start
+	for group := 1; group < len(match)/2; group++ {
+		style, ok := rule.Captures[group]
+		start, end := match[2*group], match[2*group+1]
+		if !ok || start < 0 || start == end || offset+start < lastEnd {
+			continue
+		}
+		token.parts = append(token.parts, span{
+			start:    offset + start,
+			end:      offset + end,
+			style:    style,
+			name:     rule.Name,
+			language: l.language,
+		})
+		lastEnd = offset + end
 	}
 
-	return result.String()
+	return token
 }
 
-// sortAndFilterTokens sorts tokens by start position and resolves overlapping tokens
-func (h *Highlighter) sortAndFilterTokens(tokens *[]Token) {
-	if len(*tokens) <= 1 {
-		return
+// expandCaptures replaces tokens that have parts, such as styled capture
+// groups or the tokens of child rules, with one token per part and per text
+// between the parts
+func expandCaptures(tokens []span) []span {
+	var expanded []span
+	for _, token := range tokens {
+		if len(token.parts) == 0 {
+			expanded = append(expanded, token)
+			continue
+		}
+
+		lastPos := token.start
+		for _, part := range token.parts {
+			if part.start > lastPos {
+				expanded = append(expanded, token.piece(lastPos, part.start))
+			}
+			expanded = append(expanded, part)
+			lastPos = part.end
+		}
+		if token.end > lastPos {
+			expanded = append(expanded, token.piece(lastPos, token.end))
+		}
 	}
+	return expanded
+}
 
-	// First sort tokens by start position
-	sort.Slice(*tokens, func(i, j int) bool {
-		return (*tokens)[i].start < (*tokens)[j].start
-	})
+// piece returns the part of a span from start to end, without its capture groups
+func (s span) piece(start, end int) span {
+	return span{start: start, end: end, style: s.style, name: s.name, language: s.language, parents: s.parents}
+}
 
-	// Then handle overlapping tokens (keep the first one in case of overlap)
-	var filtered []Token
-	lastEnd := -1
+// styleValue returns the style that the language of a token maps its semantic
+// style to, laid over the styles of its parents
+func (h *Highlighter) styleValue(token Token) ansi.Style {
+	styles := h.languageStyles(token.Language)
+	if len(token.Parents) == 0 {
+		return lookupStyle(styles, token.Style)
+	}
 
-	for _, token := range *tokens {
-		// Skip completely overlapped tokens
-		if lastEnd >= token.end {
-			continue
+	var style ansi.Style
+	for _, parent := range token.Parents {
+		style = style.Layer(lookupStyle(styles, parent))
+	}
+	return style.Layer(lookupStyle(styles, token.Style))
+}
+
+// lookupStyle returns the style of a dotted scope name, falling back to its
+// longest prefix that has a style, so string.escape uses string unless it
+// has a style of its own. A name without any styled prefix gets no style.
+func lookupStyle(styles map[string]ansi.Style, name string) ansi.Style {
+	for name != "" {
+		if style, ok := styles[name]; ok {
+			return style
+		}
+		dot := strings.LastIndexByte(name, '.')
+		if dot < 0 {
+			break
+		}
+		name = name[:dot]
+	}
+	return ansi.Style{}
+}
+
+// languageStyles returns the parsed styles of a language, the theme styles
+// overridden by those of the language, parsing them on first use. Styles that
+// do not parse are left out.
+func (h *Highlighter) languageStyles(language string) map[string]ansi.Style {
+	if styles, ok := h.parsed[language]; ok {
+		return styles
+	}
+
+	raw := h.styles
+	if language != h.language {
+		raw = h.config.Languages[language].Styles
+	}
+	styles := make(map[string]ansi.Style, len(h.config.Theme)+len(raw))
+	for _, specs := range []map[string]string{h.config.Theme, raw} {
+		for name, spec := range specs {
+			if style, err := ansi.ParseStyle(spec); err == nil {
+				styles[name] = style
+			}
 		}
+	}
+
+	if h.parsed == nil {
+		h.parsed = make(map[string]map[string]ansi.Style)
+	}
+	h.parsed[language] = styles
+	return styles
+}
+
+// formatter returns the output formatter, which defaults to ANSI escape codes
+func (h *Highlighter) formatter() Formatter {
+	if h.options.Formatter != nil {
+		return h.options.Formatter
+	}
+	return ANSIFormatter{}
+}
 
-		// Include token that starts after the last end or has partial overlap
-		if token.start >= lastEnd {
-			filtered = append(filtered, token)
-			lastEnd = token.end
+// documentStyles returns the styles of the language, followed by the styles of
+// the other languages it may embed for names it does not define itself
+func (h *Highlighter) documentStyles() map[string]ansi.Style {
+	styles := make(map[string]ansi.Style, len(h.styles))
+	for name, style := range h.languageStyles(h.language) {
+		styles[name] = style
+	}
+
+	languages := make([]string, 0, len(h.config.Languages))
+	for lang := range h.config.Languages {
+		languages = append(languages, lang)
+	}
+	sort.Strings(languages)
+	for _, lang := range languages {
+		for name, style := range h.languageStyles(lang) {
+			if _, ok := styles[name]; !ok {
+				styles[name] = style
+			}
 		}
 	}
+	return styles
+}
 
-	*tokens = filtered
+// resumePoint is where the rule of a token that lost to an overlapping one
+// searches again: the end of the token that won
+type resumePoint struct {
+	rule int
+	from int
 }
 
-// ansiStyle converts a style name to its ANSI escape code
-func (h *Highlighter) ansiStyle(styleName string) string {
-	styles := map[string]string{
-		"reset":      Reset,
-		"bold":       Bold,
-		"italic":     Italic,
-		"underline":  Underline,
-		"black":      Black,
-		"red":        Red,
-		"green":      Green,
-		"yellow":     Yellow,
-		"blue":       Blue,
-		"magenta":    Magenta,
-		"cyan":       Cyan,
-		"white":      White,
-		"bg_black":   BgBlack,
-		"bg_red":     BgRed,
-		"bg_green":   BgGreen,
-		"bg_yellow":  BgYellow,
-		"bg_blue":    BgBlue,
-		"bg_magenta": BgMagenta,
-		"bg_cyan":    BgCyan,
-		"bg_white":   BgWhite,
+// sortAndFilterTokens resolves overlapping tokens and returns the remaining ones
+// sorted by start position. Tokens are taken by descending rule priority; within
+// the same priority the leftmost token wins, and tokens starting at the same
+// position are ordered by the overlap policy. A token that overlaps one
+// already taken is dropped as a whole, so a keyword inside a string or
+// comment never breaks out of it. Dropped tokens that run past the end of the
+// token they lost to are returned as points to search their rules again from.
+func (l *RegexLexer) sortAndFilterTokens(tokens []span) ([]span, []resumePoint) {
+	if len(tokens) <= 1 {
+		return tokens, nil
 	}
 
-	if code, ok := styles[styleName]; ok {
-		return code
+	sort.SliceStable(tokens, func(i, j int) bool {
+		a, b := tokens[i], tokens[j]
+		if a.priority != b.priority {
+			return a.priority > b.priority
+		}
+		if a.start != b.start {
+			return a.start < b.start
+		}
+		if l.longest && a.end != b.end {
+			return a.end > b.end
+		}
+		if a.rule != b.rule {
+			return a.rule < b.rule
+		}
+		return a.end > b.end
+	})
+
+	// Keep the taken tokens sorted by start to find overlaps by binary search
+	var filtered []span
+	var lost []resumePoint
+	for _, token := range tokens {
+		if token.start == token.end {
+			continue
+		}
+
+		i := sort.Search(len(filtered), func(i int) bool {
+			return filtered[i].start >= token.start
+		})
+		winner := -1
+		if i > 0 && filtered[i-1].end > token.start {
+			winner = i - 1
+		} else if i < len(filtered) && filtered[i].start < token.end {
+			winner = i
+		}
+		if winner >= 0 {
+			if from := filtered[winner].end; token.end > from {
+				lost = append(lost, resumePoint{rule: token.rule, from: from})
+			}
+			continue
+		}
+
+		filtered = append(filtered, span{})
+		copy(filtered[i+1:], filtered[i:])
+		filtered[i] = token
 	}
-	return ""
+
+	return filtered, lost
 }
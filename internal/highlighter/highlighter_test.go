@@ -1,9 +1,12 @@
 package highlighter
 
 import (
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
+
+	"github.com/AmirMahdyJebreily/hili-cat/pkg/ansi"
 )
 
 func TestHighlightLine(t *testing.T) {
@@ -13,16 +16,18 @@ func TestHighlightLine(t *testing.T) {
 			"keyword": "cyan",
 			"string":  "green",
 		},
-		rules: []CompiledRule{
-			{
-				Name:    "keyword",
-				Pattern: regexp.MustCompile(`\b(func|package|import)\b`),
-				Style:   "keyword",
-			},
-			{
-				Name:    "string",
-				Pattern: regexp.MustCompile(`"[^"]*"`),
-				Style:   "string",
+		lexer: &RegexLexer{
+			rules: []CompiledRule{
+				{
+					Name:    "keyword",
+					Pattern: regexp.MustCompile(`\b(func|package|import)\b`),
+					Style:   "keyword",
+				},
+				{
+					Name:    "string",
+					Pattern: regexp.MustCompile(`"[^"]*"`),
+					Style:   "string",
+				},
 			},
 		},
 	}
@@ -42,6 +47,226 @@ func TestHighlightLine(t *testing.T) {
 	}
 }
 
+func TestHighlightLineCaptures(t *testing.T) {
+	cfg := Config{
+		Languages: map[string]Language{
+			"go": {
+				Rules: []HighlightRule{
+					{
+						Name:     "functions",
+						Pattern:  `\b(func)\s+(?P<name>[A-Za-z0-9_]+)\s*(\([^)]*\))`,
+						Style:    "punctuation",
+						Captures: map[string]string{"1": "keyword", "name": "function", "3": "params"},
+					},
+				},
+				Styles: map[string]string{
+					"keyword":     "cyan",
+					"function":    "blue",
+					"params":      "yellow",
+					"punctuation": "white",
+				},
+			},
+		},
+	}
+
+	h, err := NewHighlighter(cfg, "go", LF, Options{})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+
+	got := h.highlightLine("func main(a int) {")
+	want := Cyan + "func" + Reset + White + " " + Reset + Blue + "main" + Reset + Yellow + "(a int)" + Reset + " {"
+	if got != want {
+		t.Errorf("highlightLine() = %q, want %q", got, want)
+	}
+}
+
+func TestUnknownCaptureGroup(t *testing.T) {
+	cfg := Config{
+		Languages: map[string]Language{
+			"go": {
+				Rules: []HighlightRule{
+					{Name: "functions", Pattern: `func (\w+)`, Captures: map[string]string{"name": "function"}},
+				},
+			},
+		},
+	}
+
+	if _, err := NewHighlighter(cfg, "go", LF, Options{}); err == nil {
+		t.Error("NewHighlighter() expected error for unknown capture group")
+	}
+}
+
+func TestBacktrackingEngine(t *testing.T) {
+	variables := HighlightRule{Name: "variables", Pattern: `(?<=\$)\w+`, Style: "variable"}
+	cfg := Config{
+		Languages: map[string]Language{
+			"sh": {
+				Rules: []HighlightRule{
+					{Name: "keywords", Pattern: `\becho\b`, Style: "keyword"},
+					{Name: "quoted", Pattern: `(?P<quote>['"]).*?\k<quote>`, Style: "string", Captures: map[string]string{"quote": "punctuation"}, Engine: EngineBacktracking},
+				},
+				Styles: map[string]string{"keyword": "cyan", "variable": "blue", "string": "green", "punctuation": "white"},
+			},
+		},
+	}
+
+	// RE2 rejects lookbehind
+	language := cfg.Languages["sh"]
+	language.Rules = append(language.Rules, variables)
+	cfg.Languages["sh"] = language
+	if _, err := NewHighlighter(cfg, "sh", LF, Options{}); err == nil {
+		t.Error("NewHighlighter() expected error for lookbehind with the RE2 engine")
+	}
+
+	variables.Engine = EngineBacktracking
+	language.Rules[len(language.Rules)-1] = variables
+	h, err := NewHighlighter(cfg, "sh", LF, Options{})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+	got := h.highlightLine(`echo $x "a'b"`)
+	want := Cyan + "echo" + Reset + " $" + Blue + "x" + Reset + " " + White + `"` + Reset + Green + `a'b"` + Reset
+	if got != want {
+		t.Errorf("highlightLine() = %q, want %q", got, want)
+	}
+
+	variables.Engine = "pcre"
+	language.Rules[len(language.Rules)-1] = variables
+	if _, err := NewHighlighter(cfg, "sh", LF, Options{}); err == nil || !strings.Contains(err.Error(), "unknown regex engine pcre") {
+		t.Errorf("NewHighlighter() error = %v, want unknown regex engine", err)
+	}
+}
+
+func TestRulePrecedence(t *testing.T) {
+	keywordRule := HighlightRule{Name: "keywords", Pattern: `\b(func|return)\b`, Style: "keyword"}
+	stringRule := HighlightRule{Name: "strings", Pattern: `"[^"]*"`, Style: "string"}
+	commentRule := HighlightRule{Name: "comments", Pattern: `//.*`, Style: "comment"}
+	functionRule := HighlightRule{Name: "functions", Pattern: `\bfunc \w+`, Style: "function"}
+	styles := map[string]string{
+		"keyword":  "cyan",
+		"string":   "green",
+		"comment":  "yellow",
+		"function": "blue",
+	}
+
+	tests := []struct {
+		name    string
+		rules   []HighlightRule
+		overlap string
+		line    string
+		want    string
+	}{
+		{
+			name:  "keyword inside string with keywordRule first",
+			rules: []HighlightRule{keywordRule, stringRule},
+			line:  `x "return"`,
+			want:  `x ` + Green + `"return"` + Reset,
+		},
+		{
+			name:  "keyword inside comment with commentRule last",
+			rules: []HighlightRule{keywordRule, stringRule, commentRule},
+			line:  `// return "x"`,
+			want:  Yellow + `// return "x"` + Reset,
+		},
+		{
+			name:  "first rule wins at the same start",
+			rules: []HighlightRule{keywordRule, functionRule},
+			line:  `func main`,
+			want:  Cyan + `func` + Reset + ` main`,
+		},
+		{
+			name:    "longest match wins at the same start",
+			rules:   []HighlightRule{keywordRule, functionRule},
+			overlap: OverlapLongest,
+			line:    `func main`,
+			want:    Blue + `func main` + Reset,
+		},
+		{
+			name: "higher priority wins over an earlier match",
+			rules: []HighlightRule{
+				keywordRule,
+				{Name: "strings", Pattern: `"[^"]*"`, Style: "string", Priority: 1},
+				{Name: "regex", Pattern: `/[^/]+/`, Style: "keyword"},
+			},
+			line: `a / "b/c"`,
+			want: `a / ` + Green + `"b/c"` + Reset,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{
+				Languages: map[string]Language{
+					"test": {Rules: tt.rules, Styles: styles, Overlap: tt.overlap},
+				},
+			}
+			h, err := NewHighlighter(cfg, "test", LF, Options{})
+			if err != nil {
+				t.Fatalf("NewHighlighter() error = %v", err)
+			}
+
+			// The result must not depend on the order of earlier calls
+			for i := 0; i < 3; i++ {
+				if got := h.highlightLine(tt.line); got != tt.want {
+					t.Fatalf("highlightLine() = %q, want %q", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestCommentAfterString(t *testing.T) {
+	cfg := loadShippedConfig(t)
+
+	// A comment match starting inside the string loses to it, which must not
+	// hide the real comment after the string
+	tests := []struct {
+		lang    string
+		line    string
+		comment string
+	}{
+		{lang: "javascript", line: `x = "http://x"; // if c`, comment: "// if c"},
+		{lang: "python", line: `s = "a # b"  # if c`, comment: "# if c"},
+		{lang: "shell", line: `echo "a #b" # if done`, comment: "# if done"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.lang, func(t *testing.T) {
+			h, err := NewHighlighter(cfg, tt.lang, LF, Options{})
+			if err != nil {
+				t.Fatalf("NewHighlighter() error = %v", err)
+			}
+
+			var comment string
+			for _, token := range h.Tokenize([]byte(tt.line + LF)) {
+				text := tt.line[token.Start:token.End]
+				if token.Style == "keyword" && text == "if" {
+					t.Errorf("keyword %q found inside the comment", text)
+				}
+				if token.Style == "comment" {
+					comment = strings.TrimSpace(text)
+				}
+			}
+			if comment != tt.comment {
+				t.Errorf("comment = %q, want %q", comment, tt.comment)
+			}
+		})
+	}
+}
+
+func TestUnknownOverlapPolicy(t *testing.T) {
+	cfg := Config{
+		Languages: map[string]Language{
+			"test": {Overlap: "shortest"},
+		},
+	}
+
+	if _, err := NewHighlighter(cfg, "test", LF, Options{}); err == nil {
+		t.Error("NewHighlighter() expected error for unknown overlap policy")
+	}
+}
+
 func TestProcessContent(t *testing.T) {
 	// Create a simple test highlighter
 	h := &Highlighter{
@@ -52,11 +277,13 @@ func TestProcessContent(t *testing.T) {
 		styles: map[string]string{
 			"keyword": "cyan",
 		},
-		rules: []CompiledRule{
-			{
-				Name:    "keyword",
-				Pattern: regexp.MustCompile(`\b(func|package|import)\b`),
-				Style:   "keyword",
+		lexer: &RegexLexer{
+			rules: []CompiledRule{
+				{
+					Name:    "keyword",
+					Pattern: regexp.MustCompile(`\b(func|package|import)\b`),
+					Style:   "keyword",
+				},
 			},
 		},
 	}
@@ -77,25 +304,308 @@ func TestProcessContent(t *testing.T) {
 	}
 }
 
-func TestAnsiStyle(t *testing.T) {
-	h := &Highlighter{}
+func TestProcessContentAcrossChunks(t *testing.T) {
+	h := &Highlighter{
+		lineEnding: "\n",
+		lexer:      &RegexLexer{},
+		options: Options{
+			NumberLines:  true,
+			SqueezeBlank: true,
+		},
+	}
+
+	output := h.ProcessContent([]byte("one\n\n"))
+	output += h.ProcessContent([]byte("\n\ntwo\n"))
+	output += h.ProcessContent([]byte("three"))
+
+	want := "    1  one\n    2  \n    3  two\n    4  three"
+	if output != want {
+		t.Errorf("ProcessContent() = %q, want %q", output, want)
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	cfg := Config{
+		Languages: map[string]Language{
+			"go": {
+				Rules: []HighlightRule{
+					{Name: "keywords", Pattern: `\b(package|return)\b`, Style: "keyword"},
+					{Name: "functions", Pattern: `\b(func) (\w+)`, Captures: map[string]string{"1": "keyword", "2": "function"}},
+				},
+				Regions: []Region{
+					{Name: "block_comments", Begin: `/\*`, End: `\*/`, Style: "comment"},
+				},
+				Styles: map[string]string{"keyword": "cyan", "function": "blue", "comment": "yellow"},
+			},
+		},
+	}
+
+	h, err := NewHighlighter(cfg, "go", LF, Options{})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+
+	got := h.Tokenize([]byte("package main\nfunc f() /* a\nb */\n"))
+	want := []Token{
+		{Start: 0, End: 7, Line: 1, Rule: "keywords", Style: "keyword", Language: "go"},
+		{Start: 13, End: 17, Line: 2, Rule: "functions", Style: "keyword", Language: "go"},
+		{Start: 17, End: 18, Line: 2, Rule: "functions", Style: "", Language: "go"},
+		{Start: 18, End: 19, Line: 2, Rule: "functions", Style: "function", Language: "go"},
+		{Start: 22, End: 24, Line: 2, Rule: "block_comments", Style: "comment", Language: "go"},
+		{Start: 24, End: 26, Line: 2, Rule: "block_comments", Style: "comment", Language: "go"},
+		{Start: 27, End: 29, Line: 3, Rule: "block_comments", Style: "comment", Language: "go"},
+		{Start: 29, End: 31, Line: 3, Rule: "block_comments", Style: "comment", Language: "go"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize() = %+v, want %+v", got, want)
+	}
+
+	// Line numbers carry over to the next call, offsets start afresh
+	got = h.Tokenize([]byte("return\n"))
+	want = []Token{{Start: 0, End: 6, Line: 4, Rule: "keywords", Style: "keyword", Language: "go"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize() on the next call = %+v, want %+v", got, want)
+	}
+}
+
+func TestChildRules(t *testing.T) {
+	cfg := Config{
+		Languages: map[string]Language{
+			"test": {
+				Rules: []HighlightRule{
+					{Name: "strings", Pattern: `"(\\.|[^"\\])*"`, Style: "string", Rules: []HighlightRule{
+						{Name: "escapes", Pattern: `\\.`, Style: "string.escape"},
+						{Name: "verbs", Pattern: `%[sd]`, Style: "string.format"},
+					}},
+					{Name: "comments", Pattern: `//.*`, Style: "comment", Rules: []HighlightRule{
+						{Name: "todos", Pattern: `\bTODO\b`, Style: "comment.todo"},
+					}},
+					{Name: "calls", Pattern: `(\w+)\(([^)]*)\)`, Captures: map[string]string{"1": "function"}, Rules: []HighlightRule{
+						{Name: "numbers", Pattern: `\d+`, Style: "number"},
+					}},
+				},
+			},
+		},
+		Theme: map[string]string{
+			"string":        "green",
+			"string.escape": "bold",
+			"string.format": "italic yellow",
+			"comment":       "blue",
+			"comment.todo":  "underline",
+			"function":      "cyan",
+			"number":        "magenta",
+		},
+	}
+
+	h, err := NewHighlighter(cfg, "test", LF, Options{})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+
+	// Child tokens split their parent, and their styles are laid over the parent's
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`"a\n%s"`, Green + `"a` + Reset + "\033[1;32m" + `\n` + Reset + "\033[3;33m%s" + Reset + Green + `"` + Reset},
+		{`"\"TODO"`, Green + `"` + Reset + "\033[1;32m" + `\"` + Reset + Green + `TODO"` + Reset},
+		{"// TODO: x", Blue + "// " + Reset + "\033[4;34mTODO" + Reset + Blue + ": x" + Reset},
+		{"f(12)", Cyan + "f" + Reset + "(" + Magenta + "12" + Reset + ")"},
+		{"f(a)", Cyan + "f" + Reset + "(a)"},
+	}
+
+	for _, tt := range tests {
+		if got := h.highlightLine(tt.line); got != tt.want {
+			t.Errorf("highlightLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+
+	got := h.Tokenize([]byte(`"\n"` + "\n"))
+	want := []Token{
+		{Start: 0, End: 1, Line: 6, Rule: "strings", Style: "string", Language: "test"},
+		{Start: 1, End: 3, Line: 6, Rule: "escapes", Style: "string.escape", Language: "test", Parents: []string{"string"}},
+		{Start: 3, End: 4, Line: 6, Rule: "strings", Style: "string", Language: "test"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize() = %+v, want %+v", got, want)
+	}
+}
+
+func TestStateRulesLayered(t *testing.T) {
+	cfg := Config{
+		Languages: map[string]Language{
+			"test": {
+				Regions: []Region{
+					{Name: "comments", Begin: `/\*`, End: `\*/`, Style: "comment", State: "comment"},
+				},
+				States: map[string]State{
+					"comment": {Rules: []HighlightRule{{Name: "todos", Pattern: `TODO`, Style: "todo"}}},
+				},
+			},
+		},
+		Theme: map[string]string{"comment": "italic yellow", "todo": "bold"},
+	}
+
+	h, err := NewHighlighter(cfg, "test", LF, Options{})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+
+	// A state rule is styled over the style of its region
+	want := "\033[3;33m/*" + Reset + "\033[3;33m " + Reset + "\033[1;3;33mTODO" + Reset + "\033[3;33m " + Reset + "\033[3;33m*/" + Reset
+	if got := h.highlightLine("/* TODO */"); got != want {
+		t.Errorf("highlightLine() = %q, want %q", got, want)
+	}
+}
+
+func TestThemeStyles(t *testing.T) {
+	cfg := Config{
+		Languages: map[string]Language{
+			"plain": {
+				Rules: []HighlightRule{
+					{Name: "keywords", Pattern: `\bif\b`, Style: "keyword"},
+					{Name: "strings", Pattern: `"[^"]*"`, Style: "string"},
+				},
+			},
+			"custom": {
+				Rules: []HighlightRule{
+					{Name: "keywords", Pattern: `\bif\b`, Style: "keyword"},
+					{Name: "strings", Pattern: `"[^"]*"`, Style: "string"},
+				},
+				Styles: map[string]string{"keyword": "magenta"},
+			},
+		},
+		Theme: map[string]string{"keyword": "blue", "string": "green"},
+	}
+
+	// The theme styles every language, and a language can override it
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{"plain", Blue + "if" + Reset + " " + Green + `"a"` + Reset},
+		{"custom", Magenta + "if" + Reset + " " + Green + `"a"` + Reset},
+	}
+
+	for _, tt := range tests {
+		h, err := NewHighlighter(cfg, tt.lang, LF, Options{})
+		if err != nil {
+			t.Fatalf("NewHighlighter() error = %v", err)
+		}
+		if got := h.highlightLine(`if "a"`); got != tt.want {
+			t.Errorf("highlightLine() for %s = %q, want %q", tt.lang, got, tt.want)
+		}
+	}
+
+	cfg.Theme["comment"] = "sparkly"
+	if _, err := NewHighlighter(cfg, "plain", LF, Options{}); err == nil {
+		t.Error("NewHighlighter() expected error for an invalid theme style")
+	}
+}
+
+func TestScopeFallback(t *testing.T) {
+	cfg := Config{
+		Languages: map[string]Language{
+			"test": {
+				Rules: []HighlightRule{
+					{Name: "escapes", Pattern: `\\.`, Style: "string.escape", Priority: 1},
+					{Name: "strings", Pattern: `"[^"]*"`, Style: "string.quoted.double"},
+					{Name: "docs", Pattern: `///.*`, Style: "comment.doc", Priority: 1},
+					{Name: "comments", Pattern: `//.*`, Style: "comment"},
+					{Name: "names", Pattern: `\bmain\b`, Style: "entity.name.function"},
+				},
+				Styles: map[string]string{"comment.doc": "italic"},
+			},
+		},
+		Theme: map[string]string{"string": "green", "comment": "yellow", "entity.name": "blue"},
+	}
+
+	h, err := NewHighlighter(cfg, "test", LF, Options{})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+
+	// A scope uses its own style, or that of its longest styled prefix
+	tests := []struct {
+		line string
+		want string
+	}{
+		{`"a"`, Green + `"a"` + Reset},
+		{`\n`, Green + `\n` + Reset},
+		{"/// doc", Italic + "/// doc" + Reset},
+		{"// note", Yellow + "// note" + Reset},
+		{"main", Blue + "main" + Reset},
+	}
+
+	for _, tt := range tests {
+		if got := h.highlightLine(tt.line); got != tt.want {
+			t.Errorf("highlightLine(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}
+
+func TestLookupStyle(t *testing.T) {
+	red, _ := ansi.ParseStyle("red")
+	bold, _ := ansi.ParseStyle("bold")
+	styles := map[string]ansi.Style{"string": red, "string.escape": bold}
+
+	tests := []struct {
+		name string
+		want ansi.Style
+	}{
+		{"string", red},
+		{"string.escape", bold},
+		{"string.escape.unicode", bold},
+		{"string.quoted", red},
+		{"strings", ansi.Style{}},
+		{"comment.doc", ansi.Style{}},
+		{"", ansi.Style{}},
+	}
+
+	for _, tt := range tests {
+		if got := lookupStyle(styles, tt.name); got.Code(ansi.ColorsTrue) != tt.want.Code(ansi.ColorsTrue) {
+			t.Errorf("lookupStyle(%q) = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestStyles(t *testing.T) {
+	newStyled := func(style string) (*Highlighter, error) {
+		cfg := Config{
+			Languages: map[string]Language{
+				"test": {
+					Rules:  []HighlightRule{{Name: "keywords", Pattern: `\bif\b`, Style: "keyword"}},
+					Styles: map[string]string{"keyword": style},
+				},
+			},
+		}
+		return NewHighlighter(cfg, "test", LF, Options{})
+	}
 
 	testCases := []struct {
 		name     string
 		style    string
 		expected string
 	}{
-		{"valid color", "red", Red},
-		{"valid formatting", "bold", Bold},
-		{"invalid style", "nonexistent", ""},
+		{"color", "red", Red + "if" + Reset},
+		{"formatting", "bold", Bold + "if" + Reset},
+		{"compound", "bold underline brightred on_blue", "\033[1;4;91;44m" + "if" + Reset},
+		{"reset", "reset", "if"},
 	}
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			result := h.ansiStyle(tc.style)
-			if result != tc.expected {
-				t.Errorf("ansiStyle(%s) = %s, want %s", tc.style, result, tc.expected)
+			h, err := newStyled(tc.style)
+			if err != nil {
+				t.Fatalf("NewHighlighter() error = %v", err)
+			}
+			if result := h.highlightLine("if"); result != tc.expected {
+				t.Errorf("highlightLine() with style %q = %q, want %q", tc.style, result, tc.expected)
 			}
 		})
 	}
+
+	if _, err := newStyled("bold brightblu"); err == nil || !strings.Contains(err.Error(), "brightblu") {
+		t.Errorf("NewHighlighter() error = %v, want an error naming the unknown style", err)
+	}
 }
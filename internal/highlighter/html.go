@@ -0,0 +1,186 @@
+package highlighter
+
+import (
+	"fmt"
+	"html"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/AmirMahdyJebreily/hili-cat/pkg/ansi"
+)
+
+// HTMLFormatter renders lines as HTML inside a pre element.
+// Tokens are styled inline unless Classes is set, in which case they get a
+// class named after their semantic style, such as hl-keyword, and a stylesheet
+// is written before the pre element. A dotted scope such as string.escape gets
+// a class for each of its prefixes, hl-string hl-string-escape, so a theme
+// without a rule for the scope still styles it like its prefix. Standalone
+// wraps the output in a complete document with Title as its title.
+type HTMLFormatter struct {
+	Classes    bool
+	Standalone bool
+	Title      string
+}
+
+// Begin opens the document, if standalone, and the pre element
+func (f HTMLFormatter) Begin(styles map[string]ansi.Style) string {
+	var b strings.Builder
+
+	if f.Standalone {
+		title := f.Title
+		if title == "" {
+			title = "hili-cat"
+		}
+		b.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n")
+		fmt.Fprintf(&b, "<title>%s</title>\n", html.EscapeString(title))
+		if f.Classes {
+			b.WriteString(stylesheet(styles))
+		}
+		b.WriteString("</head>\n<body>\n")
+	} else if f.Classes {
+		b.WriteString(stylesheet(styles))
+	}
+
+	b.WriteString(`<pre class="hl"><code>`)
+	return b.String()
+}
+
+// FormatLine renders the tokens of a line as HTML spans
+func (f HTMLFormatter) FormatLine(line string, tokens []Token, style func(Token) ansi.Style) string {
+	var result strings.Builder
+	lastPos := 0
+
+	for _, token := range tokens {
+		// Add text before the token
+		if token.Start > lastPos {
+			result.WriteString(html.EscapeString(line[lastPos:token.Start]))
+		}
+
+		text := html.EscapeString(line[token.Start:token.End])
+		css := cssStyle(style(token))
+		switch {
+		case css == "":
+			result.WriteString(text)
+		case f.Classes:
+			// Parents get spans of their own, so their rules apply beneath the
+			// token's. A scope without a name has no class and gets no span.
+			spans := 0
+			for _, scope := range append(slices.Clip(token.Parents), token.Style) {
+				if scope != "" {
+					fmt.Fprintf(&result, `<span class="%s">`, scopeClasses(scope))
+					spans++
+				}
+			}
+			result.WriteString(text)
+			result.WriteString(strings.Repeat("</span>", spans))
+		default:
+			fmt.Fprintf(&result, `<span style="%s">%s</span>`, css, text)
+		}
+
+		lastPos = token.End
+	}
+
+	// Add any remaining text
+	if lastPos < len(line) {
+		result.WriteString(html.EscapeString(line[lastPos:]))
+	}
+
+	return result.String()
+}
+
+// End closes the pre element and, if standalone, the document
+func (f HTMLFormatter) End() string {
+	if f.Standalone {
+		return "</code></pre>\n</body>\n</html>\n"
+	}
+	return "</code></pre>\n"
+}
+
+// stylesheet returns a style element with a class for every style of styles
+func stylesheet(styles map[string]ansi.Style) string {
+	names := make([]string, 0, len(styles))
+	for name := range styles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.WriteString("<style>\n")
+	for _, name := range names {
+		if css := cssStyle(styles[name]); css != "" {
+			fmt.Fprintf(&b, ".%s { %s }\n", cssClass(name), css)
+		}
+	}
+	b.WriteString("</style>\n")
+	return b.String()
+}
+
+// cssStyle converts a style to CSS declarations, or returns an empty string
+// for a style that changes nothing. Blinking is left out.
+func cssStyle(style ansi.Style) string {
+	var declarations []string
+	if style.Has(ansi.AttrBold) {
+		declarations = append(declarations, "font-weight: bold;")
+	}
+	if style.Has(ansi.AttrDim) {
+		declarations = append(declarations, "opacity: 0.6;")
+	}
+	if style.Has(ansi.AttrItalic) {
+		declarations = append(declarations, "font-style: italic;")
+	}
+
+	var lines []string
+	if style.Has(ansi.AttrUnderline) {
+		lines = append(lines, "underline")
+	}
+	if style.Has(ansi.AttrStrikethrough) {
+		lines = append(lines, "line-through")
+	}
+	if lines != nil {
+		declarations = append(declarations, "text-decoration: "+strings.Join(lines, " ")+";")
+	}
+
+	foreground, background := style.Foreground, style.Background
+	if style.Has(ansi.AttrReverse) {
+		foreground, background = background, foreground
+	}
+	if foreground != nil {
+		declarations = append(declarations, "color: "+cssColor(*foreground)+";")
+	}
+	if background != nil {
+		declarations = append(declarations, "background-color: "+cssColor(*background)+";")
+	}
+
+	return strings.Join(declarations, " ")
+}
+
+// cssColor returns the CSS value of a color
+func cssColor(c ansi.Color) string {
+	r, g, b := c.RGB()
+	return fmt.Sprintf("#%02x%02x%02x", r, g, b)
+}
+
+// scopeClasses returns the class names of a dotted scope and all its prefixes,
+// from the shortest to the longest. Since the stylesheet lists a prefix before
+// the longer names that start with it, the most specific styled class wins.
+func scopeClasses(style string) string {
+	var classes []string
+	for i := 0; i <= len(style); i++ {
+		if i == len(style) || style[i] == '.' {
+			classes = append(classes, cssClass(style[:i]))
+		}
+	}
+	return strings.Join(classes, " ")
+}
+
+// cssClass returns the class name for a semantic style name, replacing the
+// characters that are not valid in a class name
+func cssClass(style string) string {
+	return "hl-" + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return '-'
+	}, style)
+}
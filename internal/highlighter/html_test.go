@@ -0,0 +1,171 @@
+package highlighter
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/AmirMahdyJebreily/hili-cat/pkg/ansi"
+)
+
+// newHTMLHighlighter creates a highlighter for a small language that writes HTML
+func newHTMLHighlighter(t *testing.T, formatter HTMLFormatter) *Highlighter {
+	t.Helper()
+
+	cfg := Config{
+		Languages: map[string]Language{
+			"test": {
+				Rules: []HighlightRule{
+					{Name: "keywords", Pattern: `\bif\b`, Style: "keyword"},
+					{Name: "strings", Pattern: `"[^"]*"`, Style: "string"},
+				},
+				Styles: map[string]string{
+					"keyword": "bold",
+					"string":  "green",
+				},
+			},
+		},
+	}
+
+	h, err := NewHighlighter(cfg, "test", LF, Options{Formatter: formatter})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+	return h
+}
+
+func TestHTMLFormatter(t *testing.T) {
+	tests := []struct {
+		name      string
+		formatter HTMLFormatter
+		want      string
+	}{
+		{
+			name:      "inline styles",
+			formatter: HTMLFormatter{},
+			want: `<pre class="hl"><code><span style="font-weight: bold;">if</span> a &lt; b &amp;&amp; ` +
+				`<span style="color: #00cd00;">&#34;&lt;b&gt;&#34;</span>` + "\n</code></pre>\n",
+		},
+		{
+			name:      "classes",
+			formatter: HTMLFormatter{Classes: true},
+			want: "<style>\n.hl-keyword { font-weight: bold; }\n.hl-string { color: #00cd00; }\n</style>\n" +
+				`<pre class="hl"><code><span class="hl-keyword">if</span> a &lt; b &amp;&amp; ` +
+				`<span class="hl-string">&#34;&lt;b&gt;&#34;</span>` + "\n</code></pre>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newHTMLHighlighter(t, tt.formatter)
+			got := h.ProcessContent([]byte("if a < b && \"<b>\"\n")) + h.Finish()
+			if got != tt.want {
+				t.Errorf("output = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHTMLFormatterStandalone(t *testing.T) {
+	h := newHTMLHighlighter(t, HTMLFormatter{Standalone: true, Title: "a<b>.go"})
+
+	got := h.ProcessContent([]byte("if\n"))
+	got += h.ProcessContent([]byte("x\n"))
+	got += h.Finish()
+
+	if !strings.HasPrefix(got, "<!DOCTYPE html>\n") {
+		t.Errorf("output does not start with a doctype: %q", got)
+	}
+	if !strings.Contains(got, "<title>a&lt;b&gt;.go</title>") {
+		t.Errorf("output does not contain the escaped title: %q", got)
+	}
+	if strings.Count(got, "<pre") != 1 {
+		t.Errorf("output should open a single pre element: %q", got)
+	}
+	if !strings.HasSuffix(got, "x\n</code></pre>\n</body>\n</html>\n") {
+		t.Errorf("output does not end the document: %q", got)
+	}
+}
+
+func TestFinishWithoutContent(t *testing.T) {
+	h := newHTMLHighlighter(t, HTMLFormatter{})
+
+	if got, want := h.Finish(), "<pre class=\"hl\"><code></code></pre>\n"; got != want {
+		t.Errorf("Finish() = %q, want %q", got, want)
+	}
+}
+
+func TestHTMLFormatterParents(t *testing.T) {
+	tokens := []Token{
+		{Start: 0, End: 1, Style: "string"},
+		{Start: 1, End: 3, Style: "string.escape", Parents: []string{"string"}},
+		{Start: 3, End: 4, Style: "string"},
+	}
+	style := func(Token) ansi.Style {
+		s, _ := ansi.ParseStyle("green")
+		return s
+	}
+
+	// A token nests inside spans for its parents
+	got := HTMLFormatter{Classes: true}.FormatLine(`"\n"`, tokens, style)
+	want := `<span class="hl-string">&#34;</span>` +
+		`<span class="hl-string"><span class="hl-string hl-string-escape">\n</span></span>` +
+		`<span class="hl-string">&#34;</span>`
+	if got != want {
+		t.Errorf("FormatLine() = %q, want %q", got, want)
+	}
+
+	// A token without a style of its own gets no class, only its parents'
+	tokens = []Token{
+		{Start: 0, End: 1},
+		{Start: 1, End: 2, Parents: []string{"string"}},
+	}
+	got = HTMLFormatter{Classes: true}.FormatLine("ab", tokens, style)
+	want = `a<span class="hl-string">b</span>`
+	if got != want {
+		t.Errorf("FormatLine() = %q, want %q", got, want)
+	}
+}
+
+func TestScopeClasses(t *testing.T) {
+	tests := []struct {
+		style string
+		want  string
+	}{
+		{"keyword", "hl-keyword"},
+		{"string.escape", "hl-string hl-string-escape"},
+		{"entity.name.function", "hl-entity hl-entity-name hl-entity-name-function"},
+	}
+
+	for _, tt := range tests {
+		if got := scopeClasses(tt.style); got != tt.want {
+			t.Errorf("scopeClasses(%q) = %q, want %q", tt.style, got, tt.want)
+		}
+	}
+}
+
+func TestCSSStyle(t *testing.T) {
+	tests := []struct {
+		style string
+		want  string
+	}{
+		{"green", "color: #00cd00;"},
+		{"bg_#102030", "background-color: #102030;"},
+		{"rgb(255,0,0)", "color: #ff0000;"},
+		{"232", "color: #080808;"},
+		{"brightwhite", "color: #ffffff;"},
+		{"bold italic underline strikethrough", "font-weight: bold; font-style: italic; text-decoration: underline line-through;"},
+		{"dim blink red on_black", "opacity: 0.6; color: #cd0000; background-color: #000000;"},
+		{"reverse red on_black", "color: #000000; background-color: #cd0000;"},
+		{"reset", ""},
+	}
+
+	for _, tt := range tests {
+		style, err := ansi.ParseStyle(tt.style)
+		if err != nil {
+			t.Fatalf("ParseStyle(%q) error = %v", tt.style, err)
+		}
+		if got := cssStyle(style); got != tt.want {
+			t.Errorf("cssStyle(%q) = %q, want %q", tt.style, got, tt.want)
+		}
+	}
+}
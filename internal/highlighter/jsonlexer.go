@@ -0,0 +1,304 @@
+package highlighter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// jsonDepthStyles is the number of styles brackets cycle through by nesting depth
+const jsonDepthStyles = 3
+
+// Patterns of the JSON lexer
+var (
+	jsonNumber = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+	jsonEscape = regexp.MustCompile(`\\(["\\/bfnrt]|u[0-9a-fA-F]{4})`)
+)
+
+// What the JSON lexer expects next
+const (
+	jsonExpectValue = iota
+	jsonExpectValueOrClose
+	jsonExpectKeyOrClose
+	jsonExpectKey
+	jsonExpectColon
+	jsonExpectCommaOrClose
+)
+
+// SyntaxError is a syntax error found by a lexer, at a line and byte column
+// counted from 1
+type SyntaxError struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+// Error returns the position and description of the error
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Msg)
+}
+
+// JSONLexer tokenizes JSON, and streams of JSON values such as JSON Lines,
+// while checking the syntax. Only the open objects and arrays carry over from
+// one line to the next, so memory does not grow with the size of the text.
+//
+// Keys are styled as property, and strings, numbers and the literals true,
+// false and null as string, number and constant. Brackets cycle through the
+// styles punctuation.depth1 to punctuation.depth3 by nesting depth. The first
+// syntax error is styled as error and returned by Err; after it the lexer no
+// longer checks the syntax and tells keys by the colon that follows them.
+type JSONLexer struct {
+	language string
+	stack    []byte
+	expect   int
+	line     int
+	end      SyntaxError
+	err      *SyntaxError
+}
+
+// NewJSONLexer returns a JSON lexer whose tokens belong to the configured language lang
+func NewJSONLexer(lang string) *JSONLexer {
+	return &JSONLexer{language: lang}
+}
+
+// Tokenize returns the tokens of the next line
+func (l *JSONLexer) Tokenize(line string) []Token {
+	l.line++
+	var tokens []Token
+
+	pos := 0
+	for {
+		for pos < len(line) && strings.IndexByte(" \t\r\n", line[pos]) >= 0 {
+			pos++
+		}
+		if pos == len(line) {
+			return tokens
+		}
+
+		end, msg := jsonTokenEnd(line, pos)
+		kind := line[pos]
+		key := false
+		if msg == "" {
+			key, msg = l.check(kind, line[end:])
+		}
+
+		if msg != "" && l.err == nil {
+			l.err = &SyntaxError{Line: l.line, Column: pos + 1, Msg: msg}
+			tokens = append(tokens, l.token(pos, end, "error", "error"))
+		} else {
+			tokens = l.appendToken(tokens, line, pos, end, key)
+		}
+		l.end = SyntaxError{Line: l.line, Column: end + 1}
+		pos = end
+	}
+}
+
+// Reset forgets the open objects and arrays and the syntax error, so the next
+// line starts a new text
+func (l *JSONLexer) Reset() {
+	*l = JSONLexer{language: l.language}
+}
+
+// Err returns the first syntax error in the lines so far, or an error for a
+// text that ends inside an object or array
+func (l *JSONLexer) Err() error {
+	if l.err != nil {
+		return l.err
+	}
+	if len(l.stack) > 0 || l.expect == jsonExpectColon {
+		err := l.end
+		err.Msg = "unexpected end of JSON input"
+		return &err
+	}
+	return nil
+}
+
+// jsonTokenEnd returns the end of the token that starts at pos, and a message
+// if the token is not valid JSON
+func jsonTokenEnd(line string, pos int) (int, string) {
+	c := line[pos]
+	switch {
+	case c == '"':
+		end := pos + 1
+		for end < len(line) && line[end] != '"' {
+			if line[end] == '\\' {
+				end++
+			}
+			end++
+		}
+		if end >= len(line) {
+			return len(line), "unterminated string"
+		}
+		end++
+		text := line[pos+1 : end-1]
+		for i := 0; i < len(text); i++ {
+			if text[i] < 0x20 {
+				return end, fmt.Sprintf("invalid character %q in string", text[i])
+			}
+			if text[i] == '\\' {
+				if loc := jsonEscape.FindStringIndex(text[i:]); loc == nil || loc[0] != 0 {
+					return end, "invalid escape sequence in string"
+				}
+				i++
+			}
+		}
+		return end, ""
+
+	case c == '-' || c >= '0' && c <= '9':
+		end := pos + 1
+		for end < len(line) && strings.IndexByte("+-.eE0123456789", line[end]) >= 0 {
+			end++
+		}
+		if !jsonNumber.MatchString(line[pos:end]) {
+			return end, fmt.Sprintf("invalid number %s", line[pos:end])
+		}
+		return end, ""
+
+	case c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		end := pos + 1
+		for end < len(line) && (line[end] >= 'a' && line[end] <= 'z' || line[end] >= 'A' && line[end] <= 'Z') {
+			end++
+		}
+		switch word := line[pos:end]; word {
+		case "true", "false", "null":
+			return end, ""
+		default:
+			return end, fmt.Sprintf("invalid literal %s", word)
+		}
+
+	case strings.IndexByte("{}[]:,", c) >= 0:
+		return pos + 1, ""
+	}
+	return pos + 1, fmt.Sprintf("invalid character %q", c)
+}
+
+// check moves the lexer past a token starting with c, followed by rest on its
+// line. It reports whether the token is a key, and returns a message if the
+// token cannot appear here. After the first error, it only keeps track of the
+// nesting depth.
+func (l *JSONLexer) check(c byte, rest string) (bool, string) {
+	if l.err != nil {
+		switch c {
+		case '"':
+			return strings.HasPrefix(strings.TrimLeft(rest, " \t\r"), ":"), ""
+		case '{', '[':
+			l.stack = append(l.stack, c)
+		case '}', ']':
+			if len(l.stack) > 0 {
+				l.stack = l.stack[:len(l.stack)-1]
+			}
+		}
+		return false, ""
+	}
+
+	value := l.expect == jsonExpectValue || l.expect == jsonExpectValueOrClose
+	switch c {
+	case '"':
+		if l.expect == jsonExpectKey || l.expect == jsonExpectKeyOrClose {
+			l.expect = jsonExpectColon
+			return true, ""
+		}
+		if !value {
+			return false, "unexpected string"
+		}
+		l.afterValue()
+
+	case '{', '[':
+		if !value {
+			return false, fmt.Sprintf("unexpected %c", c)
+		}
+		l.stack = append(l.stack, c)
+		l.expect = jsonExpectValueOrClose
+		if c == '{' {
+			l.expect = jsonExpectKeyOrClose
+		}
+
+	case '}', ']':
+		open := byte('{')
+		if c == ']' {
+			open = '['
+		}
+		depth := len(l.stack)
+		if depth == 0 || l.stack[depth-1] != open ||
+			(l.expect != jsonExpectCommaOrClose && l.expect != jsonExpectKeyOrClose && l.expect != jsonExpectValueOrClose) {
+			return false, fmt.Sprintf("unexpected %c", c)
+		}
+		l.stack = l.stack[:depth-1]
+		l.afterValue()
+
+	case ':':
+		if l.expect != jsonExpectColon {
+			return false, "unexpected :"
+		}
+		l.expect = jsonExpectValue
+
+	case ',':
+		if l.expect != jsonExpectCommaOrClose {
+			return false, "unexpected ,"
+		}
+		l.expect = jsonExpectValue
+		if l.stack[len(l.stack)-1] == '{' {
+			l.expect = jsonExpectKey
+		}
+
+	default:
+		// Numbers and literals
+		if !value {
+			return false, "unexpected value"
+		}
+		l.afterValue()
+	}
+	return false, ""
+}
+
+// afterValue moves past the end of a value
+func (l *JSONLexer) afterValue() {
+	l.expect = jsonExpectCommaOrClose
+	if len(l.stack) == 0 {
+		// Another top-level value may follow, as in JSON Lines
+		l.expect = jsonExpectValue
+	}
+}
+
+// appendToken appends the tokens of a valid token from start to end
+func (l *JSONLexer) appendToken(tokens []Token, line string, start, end int, key bool) []Token {
+	switch c := line[start]; {
+	case key:
+		return append(tokens, l.token(start, end, "key", "property"))
+	case c == '"':
+		// Strings are split at their escape sequences
+		last := start
+		for _, match := range jsonEscape.FindAllStringIndex(line[start:end], -1) {
+			if start+match[0] > last {
+				tokens = append(tokens, l.token(last, start+match[0], "string", "string"))
+			}
+			escape := l.token(start+match[0], start+match[1], "string", "string.escape")
+			escape.Parents = []string{"string"}
+			tokens = append(tokens, escape)
+			last = start + match[1]
+		}
+		return append(tokens, l.token(last, end, "string", "string"))
+	case c == '-' || c >= '0' && c <= '9':
+		return append(tokens, l.token(start, end, "number", "number"))
+	case c >= 'a' && c <= 'z':
+		return append(tokens, l.token(start, end, "literal", "constant"))
+	case c == '{' || c == '[':
+		return append(tokens, l.bracket(start, end, len(l.stack)))
+	case c == '}' || c == ']':
+		return append(tokens, l.bracket(start, end, len(l.stack)+1))
+	}
+	return tokens
+}
+
+// token returns a token of the lexer's language
+func (l *JSONLexer) token(start, end int, rule, style string) Token {
+	return Token{Start: start, End: end, Rule: rule, Style: style, Language: l.language}
+}
+
+// bracket returns the token of a bracket at a nesting depth counted from 1
+func (l *JSONLexer) bracket(start, end, depth int) Token {
+	if depth < 1 {
+		depth = 1
+	}
+	return l.token(start, end, "bracket", fmt.Sprintf("punctuation.depth%d", (depth-1)%jsonDepthStyles+1))
+}
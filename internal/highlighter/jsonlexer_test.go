@@ -0,0 +1,160 @@
+package highlighter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONLexer(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:  "object",
+			input: `{"name": "hili", "size": -1.5e3, "ok": true, "none": null}`,
+			want: []string{
+				"{:punctuation.depth1", `"name":property`, `"hili":string`, `"size":property`, "-1.5e3:number",
+				`"ok":property`, "true:constant", `"none":property`, "null:constant", "}:punctuation.depth1",
+			},
+		},
+		{
+			name:  "strings in arrays are not keys",
+			input: `["a", "b:c"]`,
+			want:  []string{"[:punctuation.depth1", `"a":string`, `"b:c":string`, "]:punctuation.depth1"},
+		},
+		{
+			name:  "escapes",
+			input: `"a\n\u00e9\"b"`,
+			want:  []string{`"a:string`, `\n:string.escape`, `\u00e9:string.escape`, `\":string.escape`, `b":string`},
+		},
+		{
+			name:  "depth across lines",
+			input: "{\n  \"a\": [[{},\n    [[]]]]\n}",
+			want: []string{
+				"{:punctuation.depth1", `"a":property`, "[:punctuation.depth2", "[:punctuation.depth3",
+				"{:punctuation.depth1", "}:punctuation.depth1",
+				"[:punctuation.depth1", "[:punctuation.depth2", "]:punctuation.depth2", "]:punctuation.depth1",
+				"]:punctuation.depth3", "]:punctuation.depth2", "}:punctuation.depth1",
+			},
+		},
+		{
+			name:  "JSON Lines",
+			input: "{\"a\": 1}\n{\"b\": 2} 3",
+			want: []string{
+				"{:punctuation.depth1", `"a":property`, "1:number", "}:punctuation.depth1",
+				"{:punctuation.depth1", `"b":property`, "2:number", "}:punctuation.depth1", "3:number",
+			},
+		},
+		{
+			name:    "missing comma",
+			input:   "{\"a\": 1\n \"b\": 2}",
+			want:    []string{"{:punctuation.depth1", `"a":property`, "1:number", `"b":error`, "2:number", "}:punctuation.depth1"},
+			wantErr: "line 2, column 2: unexpected string",
+		},
+		{
+			name:    "only the first error is marked",
+			input:   `[01, tru, "a": 2]`,
+			want:    []string{"[:punctuation.depth1", "01:error", "tru:constant", `"a":property`, "2:number", "]:punctuation.depth1"},
+			wantErr: "line 1, column 2: invalid number 01",
+		},
+		{
+			name:    "mismatched bracket",
+			input:   `{"a": [1}`,
+			want:    []string{"{:punctuation.depth1", `"a":property`, "[:punctuation.depth2", "1:number", "}:error"},
+			wantErr: "line 1, column 9: unexpected }",
+		},
+		{
+			name:    "trailing comma",
+			input:   `[1,]`,
+			want:    []string{"[:punctuation.depth1", "1:number", "]:error"},
+			wantErr: "line 1, column 4: unexpected ]",
+		},
+		{
+			name:    "unterminated string",
+			input:   `{"a": "b`,
+			want:    []string{"{:punctuation.depth1", `"a":property`, `"b:error`},
+			wantErr: "line 1, column 7: unterminated string",
+		},
+		{
+			name:    "invalid escape",
+			input:   `"\x"`,
+			want:    []string{`"\x":error`},
+			wantErr: "invalid escape sequence in string",
+		},
+		{
+			name:    "invalid character",
+			input:   `{'a': 1}`,
+			want:    []string{"{:punctuation.depth1", "':error", "a:constant", "1:number", "}:punctuation.depth1"},
+			wantErr: `line 1, column 2: invalid character '\''`,
+		},
+		{
+			name:    "end inside an object",
+			input:   "{\"a\": [1, 2]",
+			want:    []string{"{:punctuation.depth1", `"a":property`, "[:punctuation.depth2", "1:number", "2:number", "]:punctuation.depth2"},
+			wantErr: "line 1, column 13: unexpected end of JSON input",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lexer := NewJSONLexer("json")
+			var got []string
+			for _, line := range strings.Split(tt.input, "\n") {
+				for _, token := range lexer.Tokenize(line) {
+					if token.Language != "json" {
+						t.Errorf("token %+v has language %q", token, token.Language)
+					}
+					got = append(got, line[token.Start:token.End]+":"+token.Style)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize() = %q, want %q", got, tt.want)
+			}
+
+			err := lexer.Err()
+			if tt.wantErr == "" && err != nil {
+				t.Errorf("Err() = %v, want nil", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Err() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestJSONLexerHighlighter(t *testing.T) {
+	cfg := Config{
+		Languages: map[string]Language{"json": {Lexer: LexerJSON, Styles: map[string]string{"error": "red"}}},
+	}
+	h, err := NewHighlighter(cfg, "json", LF, Options{})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+
+	output := h.ProcessContent([]byte("[1\n2]\n"))
+	want := "[1" + LF + Red + "2" + Reset + "]" + LF
+	if output != want {
+		t.Errorf("ProcessContent() = %q, want %q", output, want)
+	}
+	if err := h.Err(); err == nil || err.Error() != "line 2, column 1: unexpected value" {
+		t.Errorf("Err() = %v, want the missing comma on line 2", err)
+	}
+}
+
+func TestJSONLexerSqueezeBlank(t *testing.T) {
+	cfg := Config{Languages: map[string]Language{"json": {Lexer: LexerJSON}}}
+	h, err := NewHighlighter(cfg, "json", LF, Options{SqueezeBlank: true})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+
+	// Errors are on the line of the content, counting the squeezed lines
+	h.ProcessContent([]byte("{\"a\": 1}\n\n\n\n{\"b\" 2}\n"))
+	if err := h.Err(); err == nil || !strings.HasPrefix(err.Error(), "line 5,") {
+		t.Errorf("Err() = %v, want an error on line 5", err)
+	}
+}
@@ -0,0 +1,179 @@
+package highlighter
+
+import "fmt"
+
+// Built-in lexers a language can select
+const (
+	LexerRegex = "regex"
+	LexerGo    = "go"
+	LexerJSON  = "json"
+	LexerCSV   = "csv"
+	LexerTSV   = "tsv"
+	LexerDiff  = "diff"
+)
+
+// Lexer splits the lines of a text into tokens. Lines are passed in order, so a
+// lexer can carry state over from one line to the next, such as an open comment.
+type Lexer interface {
+	// Tokenize returns the tokens of the next line, sorted and not overlapping,
+	// with offsets relative to the line. Line is left for the caller to set.
+	Tokenize(line string) []Token
+	// Reset drops the state carried over from previous lines, so the next line
+	// is the first line of a new text
+	Reset()
+}
+
+// SyntaxChecker is implemented by lexers that check the syntax of the text
+// they tokenize
+type SyntaxChecker interface {
+	// Err returns the first syntax error in the lines so far, or nil
+	Err() error
+}
+
+// Lookahead is implemented by lexers that need the lines after a line to
+// tokenize it, such as the diff lexer, which pairs removed lines with the added
+// lines that follow them
+type Lookahead interface {
+	// Lookahead is given the lines that come next, before they are tokenized,
+	// and returns how many of the last ones need more lines after them. Those
+	// are given again with the lines that follow, or tokenized as they are at
+	// the end of the text.
+	Lookahead(lines []string) int
+}
+
+// LexerFunc builds the lexer of a configured language
+type LexerFunc func(cfg Config, lang string) (Lexer, error)
+
+// lexers holds the lexers languages can select by name
+var lexers = map[string]LexerFunc{
+	LexerRegex: func(cfg Config, lang string) (Lexer, error) {
+		lexer, err := NewRegexLexer(cfg, lang)
+		if err != nil {
+			return nil, err
+		}
+		return lexer, nil
+	},
+	LexerGo: func(cfg Config, lang string) (Lexer, error) {
+		return NewGoLexer(lang), nil
+	},
+	LexerJSON: func(cfg Config, lang string) (Lexer, error) {
+		return NewJSONLexer(lang), nil
+	},
+	LexerCSV: func(cfg Config, lang string) (Lexer, error) {
+		return NewCSVLexer(lang, ','), nil
+	},
+	LexerTSV: func(cfg Config, lang string) (Lexer, error) {
+		return NewCSVLexer(lang, '\t'), nil
+	},
+	LexerDiff: func(cfg Config, lang string) (Lexer, error) {
+		return NewDiffLexer(cfg, lang), nil
+	},
+}
+
+// RegisterLexer makes a lexer available to the languages that select it by
+// name. It is meant to be called during initialization, before any highlighter
+// is created.
+func RegisterLexer(name string, newLexer LexerFunc) {
+	lexers[name] = newLexer
+}
+
+// newLexer builds the lexer a language selects, which defaults to its regex rules
+func newLexer(cfg Config, lang string) (Lexer, error) {
+	language, ok := cfg.Languages[lang]
+	if !ok {
+		return nil, fmt.Errorf("language not found in configuration: %s", lang)
+	}
+
+	name := language.Lexer
+	if name == "" {
+		name = LexerRegex
+	}
+	newLexer, ok := lexers[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown lexer for %s: %s", lang, name)
+	}
+	if name != LexerRegex && (len(language.Rules) > 0 || len(language.Regions) > 0 || len(language.States) > 0) {
+		return nil, fmt.Errorf("language %s uses the %s lexer and cannot also have rules, regions or states", lang, name)
+	}
+	return newLexer(cfg, lang)
+}
+
+// RegexLexer tokenizes the lines of a language with its regex rules, regions
+// and states. Open regions carry over from one line to the next.
+type RegexLexer struct {
+	config   Config
+	language string
+	rules    []CompiledRule
+	regions  []compiledRegion
+	filter   *prefilter
+	stack    []frame
+	subs     map[string]Lexer
+	longest  bool
+}
+
+// NewRegexLexer compiles the rules, regions and states of a configured language
+func NewRegexLexer(cfg Config, lang string) (*RegexLexer, error) {
+	language, ok := cfg.Languages[lang]
+	if !ok {
+		return nil, fmt.Errorf("language not found in configuration: %s", lang)
+	}
+
+	lexer := &RegexLexer{config: cfg, language: lang}
+	switch language.Overlap {
+	case "", OverlapFirst:
+	case OverlapLongest:
+		lexer.longest = true
+	default:
+		return nil, fmt.Errorf("unknown overlap policy for %s: %s", lang, language.Overlap)
+	}
+
+	// Compile all regex patterns, regions and states for better performance
+	root, err := compileStates(language)
+	if err != nil {
+		return nil, err
+	}
+	lexer.rules = root.rules
+	lexer.regions = root.regions
+	lexer.filter = root.filter
+
+	return lexer, nil
+}
+
+// Tokenize returns the tokens of the next line
+func (l *RegexLexer) Tokenize(line string) []Token {
+	spans := expandCaptures(l.lineTokens(line))
+
+	tokens := make([]Token, 0, len(spans))
+	for _, s := range spans {
+		tokens = append(tokens, Token{
+			Start:    s.start,
+			End:      s.end,
+			Rule:     s.name,
+			Style:    s.style,
+			Language: s.language,
+			Parents:  s.parents,
+		})
+	}
+	return tokens
+}
+
+// Reset closes the open regions
+func (l *RegexLexer) Reset() {
+	l.stack = nil
+}
+
+// tokenSpans converts the tokens of a lexer to spans
+func tokenSpans(tokens []Token) []span {
+	spans := make([]span, len(tokens))
+	for i, token := range tokens {
+		spans[i] = span{
+			start:    token.Start,
+			end:      token.End,
+			style:    token.Style,
+			name:     token.Rule,
+			language: token.Language,
+			parents:  token.Parents,
+		}
+	}
+	return spans
+}
@@ -0,0 +1,86 @@
+package highlighter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// lineLexer styles every line as a single token, counting the lines since the last reset
+type lineLexer struct {
+	lines int
+}
+
+func (l *lineLexer) Tokenize(line string) []Token {
+	l.lines++
+	return []Token{{Start: 0, End: len(line), Rule: strings.Repeat("+", l.lines), Style: "line", Language: "lines"}}
+}
+
+func (l *lineLexer) Reset() {
+	l.lines = 0
+}
+
+func TestRegisterLexer(t *testing.T) {
+	RegisterLexer("lines", func(cfg Config, lang string) (Lexer, error) { return &lineLexer{}, nil })
+	defer delete(lexers, "lines")
+
+	cfg := Config{
+		Languages: map[string]Language{
+			"markdown": {
+				Regions: []Region{{Name: "fences", Begin: "^```(\\w*)$", End: "^```$", LanguageCapture: "1"}},
+			},
+			"lines": {Lexer: "lines"},
+		},
+	}
+	h, err := NewHighlighter(cfg, "markdown", LF, Options{})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+
+	// Each region starts the embedded lexer afresh
+	got := h.Tokenize([]byte("```lines\na\nb\n```\n```lines\nc\n```\n"))
+	want := []Token{
+		{Start: 9, End: 10, Line: 2, Rule: "+", Style: "line", Language: "lines"},
+		{Start: 11, End: 12, Line: 3, Rule: "++", Style: "line", Language: "lines"},
+		{Start: 26, End: 27, Line: 6, Rule: "+", Style: "line", Language: "lines"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize() = %+v, want %+v", got, want)
+	}
+}
+
+func TestGoLexerSelected(t *testing.T) {
+	cfg := Config{
+		Languages: map[string]Language{"go": {Lexer: LexerGo, Styles: map[string]string{"string": "green"}}},
+	}
+	h, err := NewHighlighter(cfg, "go", LF, Options{NumberLines: true})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+
+	output := h.ProcessContent([]byte("x := `a\nb`\n"))
+	want := "    1  x := " + Green + "`a" + Reset + LF + "    2  " + Green + "b`" + Reset + LF
+	if output != want {
+		t.Errorf("ProcessContent() = %q, want %q", output, want)
+	}
+}
+
+func TestLexerErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		language Language
+		want     string
+	}{
+		{"unknown lexer", Language{Lexer: "cobol"}, "unknown lexer for test: cobol"},
+		{"lexer with rules", Language{Lexer: LexerGo, Rules: []HighlightRule{{Name: "x", Pattern: `x`}}}, "cannot also have rules"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Languages: map[string]Language{"test": tt.language}}
+			if _, err := NewHighlighter(cfg, "test", LF, Options{}); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("NewHighlighter() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
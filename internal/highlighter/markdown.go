@@ -0,0 +1,758 @@
+package highlighter
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Layout of rendered Markdown
+const (
+	mdIndent       = "  "
+	mdQuoteBar     = "│ "
+	mdMaxRuleWidth = 80
+	mdMinWidth     = 20
+	mdPunctuation  = "!\"#$%&'()*+,-./:;<=>?@[\\]^_`{|}~"
+)
+
+// mdBullets are the bullets of list items by nesting depth
+var mdBullets = []string{"•", "◦", "▪"}
+
+// Patterns of Markdown blocks and inline markup
+var (
+	mdHeading   = regexp.MustCompile(`^ {0,3}(#{1,6})(?:[ \t]+(.*?))?(?:[ \t]+#+)?[ \t]*$`)
+	mdSetext    = regexp.MustCompile(`^ {0,3}(=+|-+)[ \t]*$`)
+	mdFence     = regexp.MustCompile("^( {0,3})(`{3,}|~{3,})[ \t]*([^ \t`]*)")
+	mdBreak     = regexp.MustCompile(`^ {0,3}(?:(?:-[ \t]*){3,}|(?:\*[ \t]*){3,}|(?:_[ \t]*){3,})$`)
+	mdQuote     = regexp.MustCompile(`^ {0,3}> ?`)
+	mdListItem  = regexp.MustCompile(`^( {0,3})([-*+]|[0-9]{1,9}[.)])(?:[ \t]+|$)`)
+	mdTableRule = regexp.MustCompile(`^ *\|? *:?-+:? *(?:\| *:?-+:? *)*\|? *$`)
+	mdHTML      = regexp.MustCompile(`^ {0,3}<[A-Za-z/!?]`)
+	mdAutolink  = regexp.MustCompile(`^<((?:https?|ftp|mailto):[^<>\s]+)>`)
+)
+
+// markdownRenderer renders Markdown for reading in a terminal: headings are
+// styled as heading and header, lists get bullets, block quotes are indented
+// behind a bar, tables are drawn with box-drawing characters, paragraphs are
+// wrapped, and fenced code is highlighted with the lexer of its language.
+type markdownRenderer struct {
+	config   Config
+	language string
+	subs     map[string]Lexer
+	depth    int
+}
+
+// renderMarkdown renders Markdown source, wrapping paragraphs at width
+func renderMarkdown(cfg Config, lang string, src []byte, width int) ([]byte, Lexer) {
+	r := &markdownRenderer{config: cfg, language: lang, subs: make(map[string]Lexer)}
+
+	text := strings.TrimSuffix(strings.ReplaceAll(string(src), "\r\n", "\n"), "\n")
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		// Tabs in indentation count as four spaces
+		trimmed := strings.TrimLeft(line, "\t")
+		lines[i] = strings.Repeat("    ", len(line)-len(trimmed)) + trimmed
+	}
+	return newRenderedLexer(r.blocks(lines, width, true))
+}
+
+// blocks renders lines of Markdown as a sequence of blocks, separated by blank
+// lines when loose
+func (r *markdownRenderer) blocks(lines []string, width int, loose bool) []renderedLine {
+	var out []renderedLine
+	for i := 0; i < len(lines); {
+		line := lines[i]
+		var block []renderedLine
+		switch {
+		case strings.TrimSpace(line) == "":
+			i++
+			continue
+		case leadingSpaces(line) >= 4:
+			block, i = r.indentedCode(lines, i)
+		case mdFence.MatchString(line):
+			block, i = r.fencedCode(lines, i)
+		case mdHeading.MatchString(line):
+			block = r.heading(mdHeading.FindStringSubmatch(line)[2], width)
+			i++
+		case mdBreak.MatchString(line):
+			block = []renderedLine{r.rule(width)}
+			i++
+		case mdQuote.MatchString(line):
+			block, i = r.quote(lines, i, width)
+		case mdListItem.MatchString(line):
+			block, i = r.list(lines, i, width)
+		case isTableStart(lines, i):
+			block, i = r.table(lines, i)
+		case mdHTML.MatchString(line):
+			block, i = r.html(lines, i)
+		default:
+			block, i = r.paragraph(lines, i, width)
+		}
+		if loose && len(out) > 0 {
+			out = append(out, renderedLine{})
+		}
+		out = append(out, block...)
+	}
+	return out
+}
+
+// interrupts reports whether a line starts a block that ends a paragraph
+func interrupts(line string) bool {
+	return mdFence.MatchString(line) || mdHeading.MatchString(line) || mdBreak.MatchString(line) ||
+		mdQuote.MatchString(line) || mdListItem.MatchString(line)
+}
+
+// paragraph renders the paragraph that starts at lines[i], which is a heading
+// when it is underlined with = or -, and returns where it ends
+func (r *markdownRenderer) paragraph(lines []string, i, width int) ([]renderedLine, int) {
+	var out []renderedLine
+	var text []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if len(text) > 0 && len(out) == 0 && mdSetext.MatchString(line) {
+			return r.heading(strings.Join(text, " "), width), i + 1
+		}
+		if strings.TrimSpace(line) == "" || (len(text) > 0 || len(out) > 0) && interrupts(line) {
+			break
+		}
+
+		// A line ending in a backslash or two spaces breaks the line
+		text = append(text, strings.TrimSpace(strings.TrimSuffix(line, "\\")))
+		if strings.HasSuffix(line, "\\") || strings.HasSuffix(line, "  ") {
+			out = append(out, r.wrapped(strings.Join(text, " "), nil, width)...)
+			text = nil
+		}
+	}
+	if len(text) > 0 {
+		out = append(out, r.wrapped(strings.Join(text, " "), nil, width)...)
+	}
+	return out, i
+}
+
+// heading renders the text of a heading
+func (r *markdownRenderer) heading(text string, width int) []renderedLine {
+	return r.wrapped(strings.TrimSpace(text), []string{"heading", "header"}, width)
+}
+
+// rule renders a thematic break as a line across the width
+func (r *markdownRenderer) rule(width int) renderedLine {
+	n := mdMaxRuleWidth
+	if width > 0 && width < n {
+		n = width
+	}
+	text := strings.Repeat("─", n)
+	return renderedLine{text: text, tokens: []Token{r.token(0, len(text), "punctuation", nil)}}
+}
+
+// quote renders the block quote that starts at lines[i] behind a bar, and
+// returns where it ends
+func (r *markdownRenderer) quote(lines []string, i, width int) ([]renderedLine, int) {
+	var inner []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if loc := mdQuote.FindStringIndex(line); loc != nil {
+			inner = append(inner, line[loc[1]:])
+			continue
+		}
+		// A paragraph in the quote can continue on lines without the marker
+		last := len(inner) - 1
+		if strings.TrimSpace(line) == "" || strings.TrimSpace(inner[last]) == "" || interrupts(line) {
+			break
+		}
+		inner = append(inner, line)
+	}
+	content := r.blocks(inner, innerWidth(width, mdQuoteBar), true)
+	return r.prefixed(content, mdQuoteBar, mdQuoteBar, "quote"), i
+}
+
+// list renders the list that starts at lines[i], with bullets or numbers, and
+// returns where it ends
+func (r *markdownRenderer) list(lines []string, i, width int) ([]renderedLine, int) {
+	first := mdListItem.FindStringSubmatch(lines[i])
+	indent, ordered := len(first[1]), isOrdered(first[2])
+	number, _ := strconv.Atoi(strings.TrimRight(first[2], ".)"))
+
+	r.depth++
+	defer func() { r.depth-- }()
+
+	var out []renderedLine
+	for i < len(lines) {
+		m := mdListItem.FindStringSubmatch(lines[i])
+		if m == nil || len(m[1]) != indent || isOrdered(m[2]) != ordered || mdBreak.MatchString(lines[i]) {
+			break
+		}
+
+		// The item holds the lines indented past its marker, and blank lines
+		// followed by such lines
+		contentIndent := len(m[0])
+		if strings.TrimSpace(lines[i][len(m[0]):]) == "" {
+			contentIndent = len(m[1]) + len(m[2]) + 1
+		}
+		item := []string{lines[i][len(m[0]):]}
+		loose := false
+		for i++; i < len(lines); i++ {
+			line := lines[i]
+			if strings.TrimSpace(line) == "" {
+				if i+1 < len(lines) && leadingSpaces(lines[i+1]) >= contentIndent {
+					item = append(item, "")
+					loose = true
+					continue
+				}
+				break
+			}
+			if leadingSpaces(line) >= contentIndent {
+				item = append(item, line[contentIndent:])
+				continue
+			}
+			if interrupts(line) {
+				break
+			}
+			item = append(item, strings.TrimSpace(line))
+		}
+
+		marker := mdBullets[(r.depth-1)%len(mdBullets)]
+		if ordered {
+			marker = strconv.Itoa(number) + "."
+			number++
+		}
+		pad := strings.Repeat(" ", utf8.RuneCountInString(marker)+1)
+		content := r.blocks(item, innerWidth(width, pad), loose)
+		if len(content) == 0 {
+			content = []renderedLine{{}}
+		}
+		out = append(out, r.prefixed(content, marker+" ", pad, "list")...)
+
+		// Blank lines between the items of a loose list are kept
+		next := i
+		for next < len(lines) && strings.TrimSpace(lines[next]) == "" {
+			next++
+		}
+		if next == i || next == len(lines) {
+			continue
+		}
+		if m := mdListItem.FindStringSubmatch(lines[next]); m == nil || len(m[1]) != indent || isOrdered(m[2]) != ordered {
+			break
+		}
+		out = append(out, renderedLine{})
+		i = next
+	}
+	return out, i
+}
+
+// isOrdered reports whether a list marker is a number
+func isOrdered(marker string) bool {
+	return marker[0] >= '0' && marker[0] <= '9'
+}
+
+// fencedCode renders the fenced code block that starts at lines[i], and
+// returns where it ends
+func (r *markdownRenderer) fencedCode(lines []string, i int) ([]renderedLine, int) {
+	m := mdFence.FindStringSubmatch(lines[i])
+	indent, fence, info := len(m[1]), m[2], m[3]
+
+	var code []string
+	for i++; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimSpace(line)
+		if leadingSpaces(line) < 4 && strings.HasPrefix(trimmed, fence) && strings.Trim(trimmed, fence[:1]) == "" {
+			i++
+			break
+		}
+		code = append(code, line[min(indent, leadingSpaces(line)):])
+	}
+	return r.code(info, code), i
+}
+
+// indentedCode renders the code block indented by four spaces that starts at
+// lines[i], and returns where it ends
+func (r *markdownRenderer) indentedCode(lines []string, i int) ([]renderedLine, int) {
+	var code []string
+	for ; i < len(lines); i++ {
+		line := lines[i]
+		if strings.TrimSpace(line) == "" {
+			code = append(code, "")
+			continue
+		}
+		if leadingSpaces(line) < 4 {
+			break
+		}
+		code = append(code, line[4:])
+	}
+	for len(code) > 0 && code[len(code)-1] == "" {
+		code = code[:len(code)-1]
+	}
+	return r.code("", code), i
+}
+
+// code renders the lines of a code block, indented and highlighted with the
+// lexer of the language named by info, or styled as code when there is none
+func (r *markdownRenderer) code(info string, lines []string) []renderedLine {
+	lexer := r.codeLexer(info)
+	out := make([]renderedLine, len(lines))
+	for i, line := range lines {
+		out[i].text = mdIndent + line
+		if lexer == nil {
+			if line != "" {
+				out[i].tokens = []Token{r.token(len(mdIndent), len(out[i].text), "code", nil)}
+			}
+			continue
+		}
+		for _, token := range lexer.Tokenize(line) {
+			token.Start += len(mdIndent)
+			token.End += len(mdIndent)
+			out[i].tokens = append(out[i].tokens, token)
+		}
+	}
+	return out
+}
+
+// codeLexer returns the lexer of the language a fence info string names, or
+// nil when it names none. Each code block starts the lexer afresh.
+func (r *markdownRenderer) codeLexer(info string) Lexer {
+	lang := lookupLanguage(r.config, info)
+	if lang == "" {
+		return nil
+	}
+	lexer, ok := r.subs[lang]
+	if !ok {
+		var err error
+		if lexer, err = newLexer(r.config, lang); err != nil {
+			lexer = nil
+		}
+		r.subs[lang] = lexer
+	}
+	if lexer != nil {
+		lexer.Reset()
+	}
+	return lexer
+}
+
+// html renders the HTML block that starts at lines[i] as it is, up to the next
+// blank line, and returns where it ends
+func (r *markdownRenderer) html(lines []string, i int) ([]renderedLine, int) {
+	var out []renderedLine
+	for ; i < len(lines) && strings.TrimSpace(lines[i]) != ""; i++ {
+		out = append(out, renderedLine{text: lines[i]})
+	}
+	return out, i
+}
+
+// isTableStart reports whether lines[i] is the header row of a table, followed
+// by its delimiter row
+func isTableStart(lines []string, i int) bool {
+	return i+1 < len(lines) && strings.Contains(lines[i], "|") && strings.Contains(lines[i+1], "|") &&
+		mdTableRule.MatchString(lines[i+1])
+}
+
+// table renders the table that starts at lines[i] with box-drawing characters,
+// and returns where it ends
+func (r *markdownRenderer) table(lines []string, i int) ([]renderedLine, int) {
+	header := splitRow(lines[i])
+	var aligns []byte
+	for _, cell := range splitRow(lines[i+1]) {
+		switch {
+		case strings.HasPrefix(cell, ":") && strings.HasSuffix(cell, ":"):
+			aligns = append(aligns, 'c')
+		case strings.HasSuffix(cell, ":"):
+			aligns = append(aligns, 'r')
+		default:
+			aligns = append(aligns, 'l')
+		}
+	}
+	rows := [][]string{header}
+	for i += 2; i < len(lines) && strings.Contains(lines[i], "|"); i++ {
+		rows = append(rows, splitRow(lines[i]))
+	}
+
+	// Render the cells, and find the width of each column
+	widths := make([]int, len(header))
+	cells := make([][]renderedLine, len(rows))
+	for y, row := range rows {
+		var parents []string
+		if y == 0 {
+			parents = []string{"header"}
+		}
+		cells[y] = make([]renderedLine, len(header))
+		for x := range header {
+			if x < len(row) {
+				cells[y][x].text, cells[y][x].tokens = r.inline(row[x], parents)
+			}
+			widths[x] = max(widths[x], utf8.RuneCountInString(cells[y][x].text))
+		}
+	}
+
+	out := []renderedLine{r.border(widths, "┌", "┬", "┐")}
+	for y, row := range cells {
+		out = append(out, r.tableRow(row, widths, aligns))
+		if y == 0 {
+			out = append(out, r.border(widths, "├", "┼", "┤"))
+		}
+	}
+	out = append(out, r.border(widths, "└", "┴", "┘"))
+	return out, i
+}
+
+// splitRow returns the trimmed cells of a table row
+func splitRow(line string) []string {
+	line = strings.TrimSpace(line)
+	line = strings.TrimPrefix(line, "|")
+	if strings.HasSuffix(line, "|") && !strings.HasSuffix(line, "\\|") {
+		line = line[:len(line)-1]
+	}
+
+	// Pipes that are escaped or inside code spans do not separate cells
+	var cells []string
+	start, code := 0, false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '`':
+			code = !code
+		case '|':
+			if !code {
+				cells = append(cells, line[start:i])
+				start = i + 1
+			}
+		}
+	}
+	cells = append(cells, line[start:])
+
+	// Escaped pipes are pipes in the cell, even in code spans
+	for i, cell := range cells {
+		cells[i] = strings.ReplaceAll(strings.TrimSpace(cell), "\\|", "|")
+	}
+	return cells
+}
+
+// border renders a horizontal border of a table
+func (r *markdownRenderer) border(widths []int, left, middle, right string) renderedLine {
+	var b strings.Builder
+	b.WriteString(left)
+	for i, width := range widths {
+		if i > 0 {
+			b.WriteString(middle)
+		}
+		b.WriteString(strings.Repeat("─", width+2))
+	}
+	b.WriteString(right)
+	return renderedLine{text: b.String(), tokens: []Token{r.token(0, b.Len(), "punctuation", nil)}}
+}
+
+// tableRow renders a row of rendered cells between vertical borders, aligning
+// each cell in the width of its column
+func (r *markdownRenderer) tableRow(cells []renderedLine, widths []int, aligns []byte) renderedLine {
+	var line renderedLine
+	var b strings.Builder
+	bar := func(s string) {
+		start := b.Len()
+		b.WriteString(s)
+		line.tokens = append(line.tokens, r.token(start, b.Len(), "punctuation", nil))
+	}
+
+	bar("│")
+	for i, cell := range cells {
+		pad := widths[i] - utf8.RuneCountInString(cell.text)
+		left := 0
+		if i < len(aligns) && aligns[i] == 'r' {
+			left = pad
+		} else if i < len(aligns) && aligns[i] == 'c' {
+			left = pad / 2
+		}
+
+		b.WriteString(" " + strings.Repeat(" ", left))
+		for _, token := range cell.tokens {
+			token.Start += b.Len()
+			token.End += b.Len()
+			line.tokens = append(line.tokens, token)
+		}
+		b.WriteString(cell.text)
+		b.WriteString(strings.Repeat(" ", pad-left) + " ")
+		bar("│")
+	}
+	line.text = b.String()
+	return line
+}
+
+// prefixed puts first before the first of lines and rest before the others,
+// styling the prefixes as style
+func (r *markdownRenderer) prefixed(lines []renderedLine, first, rest, style string) []renderedLine {
+	out := make([]renderedLine, len(lines))
+	for i, line := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if line.text == "" {
+			prefix = strings.TrimRight(prefix, " ")
+		}
+
+		out[i].text = prefix + line.text
+		if marker := strings.TrimRight(prefix, " "); marker != "" {
+			out[i].tokens = append(out[i].tokens, r.token(0, len(marker), style, nil))
+		}
+		for _, token := range line.tokens {
+			token.Start += len(prefix)
+			token.End += len(prefix)
+			out[i].tokens = append(out[i].tokens, token)
+		}
+	}
+	return out
+}
+
+// inline renders the inline markup of text: emphasis, code spans, links,
+// autolinks and escapes. Text outside markup is styled as the last of parents
+// laid over the others, and markup is styled on top of all of them.
+func (r *markdownRenderer) inline(text string, parents []string) (string, []Token) {
+	var out strings.Builder
+	var tokens []Token
+	plain := 0 // start of the text not covered by tokens yet
+
+	flush := func() {
+		if n := len(parents); n > 0 && out.Len() > plain {
+			tokens = append(tokens, r.token(plain, out.Len(), parents[n-1], parents[:n-1]))
+		}
+		plain = out.Len()
+	}
+	styled := func(s, style string) {
+		flush()
+		out.WriteString(s)
+		tokens = append(tokens, r.token(plain, out.Len(), style, parents))
+		plain = out.Len()
+	}
+	nested := func(inner, style string) {
+		flush()
+		text, innerTokens := r.inline(inner, append(append([]string{}, parents...), style))
+		for _, token := range innerTokens {
+			token.Start += out.Len()
+			token.End += out.Len()
+			tokens = append(tokens, token)
+		}
+		out.WriteString(text)
+		plain = out.Len()
+	}
+
+	for i := 0; i < len(text); {
+		c := text[i]
+		switch {
+		case c == '\\' && i+1 < len(text) && strings.IndexByte(mdPunctuation, text[i+1]) >= 0:
+			out.WriteByte(text[i+1])
+			i += 2
+			continue
+		case c == '`':
+			run := len(text[i:]) - len(strings.TrimLeft(text[i:], "`"))
+			code, end, ok := codeSpan(text, i, run)
+			if !ok {
+				out.WriteString(text[i : i+run])
+				i += run
+				continue
+			}
+			styled(code, "code")
+			i = end
+			continue
+		case c == '[' || c == '!' && strings.HasPrefix(text[i+1:], "["):
+			if label, url, end, ok := mdLink(text, i); ok {
+				nested(label, "link")
+				if url != "" && url != label {
+					out.WriteString(" (")
+					styled(url, "url")
+					out.WriteString(")")
+				}
+				i = end
+				continue
+			}
+		case c == '<':
+			if m := mdAutolink.FindStringSubmatch(text[i:]); m != nil {
+				styled(m[1], "url")
+				i += len(m[0])
+				continue
+			}
+		case c == '*' || c == '_':
+			if inner, style, end, ok := mdEmphasis(text, i); ok {
+				nested(inner, style)
+				i = end
+				continue
+			}
+			// A run of delimiters that does not open emphasis is text
+			run := len(text[i:]) - len(strings.TrimLeft(text[i:], string(c)))
+			out.WriteString(text[i : i+run])
+			i += run
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		out.WriteString(text[i : i+size])
+		i += size
+	}
+	flush()
+	return out.String(), tokens
+}
+
+// codeSpan returns the content of the code span opened by run backticks at i,
+// and where it ends
+func codeSpan(text string, i, run int) (string, int, bool) {
+	fence := strings.Repeat("`", run)
+	for j := i + run; j < len(text); {
+		k := strings.Index(text[j:], fence)
+		if k < 0 {
+			break
+		}
+		k += j
+		end := k + run
+		for end < len(text) && text[end] == '`' {
+			end++
+		}
+		if end-k != run {
+			j = end
+			continue
+		}
+
+		// One space on both sides is padding
+		code := text[i+run : k]
+		if len(code) >= 2 && code[0] == ' ' && code[len(code)-1] == ' ' && strings.TrimSpace(code) != "" {
+			code = code[1 : len(code)-1]
+		}
+		return code, end, true
+	}
+	return "", 0, false
+}
+
+// mdLink returns the label and destination of the link or image at i, and where it ends
+func mdLink(text string, i int) (string, string, int, bool) {
+	if text[i] == '!' {
+		i++
+	}
+	depth := 0
+	for j := i; j < len(text); j++ {
+		switch text[j] {
+		case '\\':
+			j++
+		case '[':
+			depth++
+		case ']':
+			depth--
+			if depth > 0 {
+				continue
+			}
+			if j+1 >= len(text) || text[j+1] != '(' {
+				return "", "", 0, false
+			}
+			end := strings.IndexByte(text[j+2:], ')')
+			if end < 0 {
+				return "", "", 0, false
+			}
+			end += j + 2
+
+			// The destination may be followed by a title
+			dest := strings.TrimSpace(text[j+2 : end])
+			if space := strings.IndexAny(dest, " \t"); space >= 0 {
+				dest = dest[:space]
+			}
+			dest = strings.TrimSuffix(strings.TrimPrefix(dest, "<"), ">")
+			return text[i+1 : j], dest, end + 1, true
+		}
+	}
+	return "", "", 0, false
+}
+
+// mdEmphasis returns the text inside the emphasis opened by the run of * or _
+// at i, its style, and where it ends. Three delimiters are strong emphasis
+// around emphasis.
+func mdEmphasis(text string, i int) (string, string, int, bool) {
+	c := text[i]
+	run := len(text[i:]) - len(strings.TrimLeft(text[i:], string(c)))
+	if run > 3 || i+run >= len(text) || text[i+run] == ' ' || c == '_' && i > 0 && isWordByte(text[i-1]) {
+		return "", "", 0, false
+	}
+
+	fence := text[i : i+run]
+	for j := i + run + 1; j < len(text); {
+		k := strings.Index(text[j:], fence)
+		if k < 0 {
+			break
+		}
+		k += j
+		end := k
+		for end < len(text) && text[end] == c {
+			end++
+		}
+		if end-k == run && text[k-1] != ' ' && text[k-1] != '\\' && (c != '_' || end == len(text) || !isWordByte(text[end])) {
+			inner := text[i+run : k]
+			switch run {
+			case 1:
+				return inner, "emphasis", end, true
+			case 2:
+				return inner, "strong", end, true
+			}
+			return string(c) + inner + string(c), "strong", end, true
+		}
+		j = end
+	}
+	return "", "", 0, false
+}
+
+// isWordByte reports whether a byte is a letter or digit, or part of a
+// multi-byte character
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// token returns a token of rendered Markdown, styled as style on top of parents
+func (r *markdownRenderer) token(start, end int, style string, parents []string) Token {
+	token := Token{Start: start, End: end, Rule: style, Style: style, Language: r.language}
+	if len(parents) > 0 {
+		token.Parents = append([]string{}, parents...)
+	}
+	return token
+}
+
+// wrapped renders the inline markup of text, styled on top of parents, in
+// lines of at most width characters split at spaces, or in a single line when
+// width is not positive
+func (r *markdownRenderer) wrapped(text string, parents []string, width int) []renderedLine {
+	text, tokens := r.inline(text, parents)
+
+	var lines []renderedLine
+	start, space, n := 0, -1, 0
+	for i, c := range text {
+		if c == ' ' {
+			space = i
+		}
+		n++
+		if width > 0 && n > width && space > start {
+			lines = append(lines, cutLine(text, tokens, start, space))
+			start = space + 1
+			_, size := utf8.DecodeRuneInString(text[i:])
+			n = utf8.RuneCountInString(text[start : i+size])
+			space = -1
+		}
+	}
+	return append(lines, cutLine(text, tokens, start, len(text)))
+}
+
+// cutLine returns the text from start to end as a line, with the parts of the
+// tokens inside it
+func cutLine(text string, tokens []Token, start, end int) renderedLine {
+	line := renderedLine{text: text[start:end]}
+	for _, token := range tokens {
+		from, to := max(token.Start, start), min(token.End, end)
+		if from < to {
+			token.Start, token.End = from-start, to-start
+			line.tokens = append(line.tokens, token)
+		}
+	}
+	return line
+}
+
+// leadingSpaces returns the number of spaces a line starts with
+func leadingSpaces(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " "))
+}
+
+// innerWidth returns the width left for the content of a block behind prefix
+func innerWidth(width int, prefix string) int {
+	if width <= 0 {
+		return width
+	}
+	return max(width-utf8.RuneCountInString(prefix), mdMinWidth)
+}
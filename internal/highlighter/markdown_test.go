@@ -0,0 +1,194 @@
+package highlighter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// renderedPieces returns the text and style of each token of rendered text,
+// with the styles of the token's parents before its own
+func renderedPieces(text []byte, lexer Lexer) []string {
+	var got []string
+	for _, line := range strings.Split(strings.TrimSuffix(string(text), "\n"), "\n") {
+		for _, token := range lexer.Tokenize(line) {
+			style := strings.Join(append(append([]string{}, token.Parents...), token.Style), "+")
+			got = append(got, line[token.Start:token.End]+":"+style)
+		}
+	}
+	return got
+}
+
+func TestRenderMarkdown(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		width int
+		want  string
+	}{
+		{
+			name:  "headings and paragraphs",
+			input: "# Title #\n\nSome *text*\nand `code`.\n\nSetext\n------\n",
+			want:  "Title\n\nSome text and code.\n\nSetext\n",
+		},
+		{
+			name:  "wrapping",
+			input: "one two three four five six\n",
+			width: 20,
+			want:  "one two three four\nfive six\n",
+		},
+		{
+			name:  "hard breaks",
+			input: "one\\\ntwo  \nthree\n",
+			want:  "one\ntwo\nthree\n",
+		},
+		{
+			name:  "links",
+			input: "See [the **guide**](guide.md \"Guide\"), <https://go.dev> and ![logo](logo.png).\n",
+			want:  "See the guide (guide.md), https://go.dev and logo (logo.png).\n",
+		},
+		{
+			name:  "escapes and stray delimiters",
+			input: "\\*not em\\* 2 * 3 snake_case_name\n",
+			want:  "*not em* 2 * 3 snake_case_name\n",
+		},
+		{
+			name:  "lists",
+			input: "- a\n- b\n  1. one\n  2. two\n     - deep\n- c\n\n7) seven\n8) eight\n",
+			want:  "• a\n• b\n  1. one\n  2. two\n     ▪ deep\n• c\n\n7. seven\n8. eight\n",
+		},
+		{
+			name:  "loose list",
+			input: "* a\n\n* b\n\n  more b\n",
+			want:  "• a\n\n• b\n\n  more b\n",
+		},
+		{
+			name:  "block quote",
+			input: "> quoted text that wraps\nlazily\n>\n> - item\n",
+			width: 20,
+			want:  "│ quoted text that\n│ wraps lazily\n│\n│ • item\n",
+		},
+		{
+			name:  "table",
+			input: "| Name | Size |\n|------|-----:|\n| `a\\|b` | 10 |\n| c |\n",
+			want: "┌──────┬──────┐\n│ Name │ Size │\n├──────┼──────┤\n│ a|b  │   10 │\n│ c    │      │\n" +
+				"└──────┴──────┘\n",
+		},
+		{
+			name:  "code blocks",
+			input: "```\nfenced\n  indented\n```\n\n    indented code\n\n---\n",
+			width: 10,
+			want:  "  fenced\n    indented\n\n  indented code\n\n──────────\n",
+		},
+		{
+			name:  "wrapping invalid UTF-8",
+			input: "one two three four\xff\n",
+			width: 18,
+			want:  "one two three\nfour\xff\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, lexer := renderMarkdown(Config{}, "markdown", []byte(tt.input), tt.width)
+			if string(got) != tt.want {
+				t.Errorf("renderMarkdown() = %q, want %q", got, tt.want)
+			}
+			if lexer == nil {
+				t.Error("renderMarkdown() returned no lexer")
+			}
+		})
+	}
+}
+
+func TestRenderMarkdownStyles(t *testing.T) {
+	cfg := Config{Languages: map[string]Language{
+		"markdown": {Render: RendererMarkdown},
+		"go":       {Extensions: []string{"go"}, Lexer: LexerGo},
+	}}
+
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{
+			name:  "heading",
+			input: "## A **b**\n",
+			want:  []string{"A :heading+header", "b:heading+header+strong"},
+		},
+		{
+			name:  "emphasis",
+			input: "x ***y*** `z`\n",
+			want:  []string{"y:strong+emphasis", "z:code"},
+		},
+		{
+			name:  "list and quote",
+			input: "> 1. [a](b)\n",
+			want:  []string{"│:quote", "1.:list", "a:link", "b:url"},
+		},
+		{
+			name:  "table",
+			input: "|h|\n|-|\n|v|\n",
+			want: []string{
+				"┌───┐:punctuation", "│:punctuation", "h:header", "│:punctuation", "├───┤:punctuation",
+				"│:punctuation", "│:punctuation", "└───┘:punctuation",
+			},
+		},
+		{
+			name:  "fenced code in a configured language",
+			input: "```go\nreturn nil\n```\n\n```cobol\nMOVE\n```\n",
+			want:  []string{"return:keyword", "nil:constant", "MOVE:code"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			text, lexer := Render(cfg, "markdown", []byte(tt.input), 0)
+			if got := renderedPieces(text, lexer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Tokenize() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderWithoutRenderer(t *testing.T) {
+	cfg := Config{Languages: map[string]Language{"go": {Lexer: LexerGo}}}
+	src := []byte("# not a heading\n")
+	if got, lexer := Render(cfg, "go", src, 0); string(got) != string(src) || lexer != nil {
+		t.Errorf("Render() = %q, %v, want the source and no lexer", got, lexer)
+	}
+}
+
+func TestRenderedLexerHighlighter(t *testing.T) {
+	cfg := Config{Languages: map[string]Language{
+		"markdown": {Render: RendererMarkdown, Styles: map[string]string{"list": "red"}},
+	}}
+	text, lexer := Render(cfg, "markdown", []byte("- a\n"), 0)
+	h, err := NewHighlighter(cfg, "markdown", LF, Options{Lexer: lexer})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+
+	want := Red + "•" + Reset + " a" + LF
+	if got := h.ProcessContent(text); got != want {
+		t.Errorf("ProcessContent() = %q, want %q", got, want)
+	}
+}
+
+func TestRenderedLexerSqueezeBlank(t *testing.T) {
+	cfg := Config{Languages: map[string]Language{
+		"markdown": {Render: RendererMarkdown, Styles: map[string]string{"list": "red"}},
+	}}
+	text, lexer := Render(cfg, "markdown", []byte("```\nx\n\n\n\ny\n```\n\n- a\n"), 0)
+	h, err := NewHighlighter(cfg, "markdown", LF, Options{Lexer: lexer, SqueezeBlank: true})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+
+	// The squeezed lines still take their tokens, so the list keeps its own
+	want := "  x\n  \n  y\n\n" + Red + "•" + Reset + " a" + LF
+	if got := h.ProcessContent(text); got != want {
+		t.Errorf("ProcessContent() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,542 @@
+package highlighter
+
+import (
+	"regexp"
+	"regexp/syntax"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Limits for the literals taken from a pattern
+const (
+	maxClassLiterals   = 16
+	maxPatternLiterals = 256
+)
+
+// prefilter scans a line once to find which of a set of patterns can match it,
+// and where. The literals of all patterns are searched at once with an
+// Aho-Corasick automaton over ASCII-lowercased bytes.
+//
+// A pattern whose matches all start with one of a set of literals is only run,
+// anchored, at the positions where one of them occurs. A pattern whose matches
+// only contain one of a set of literals is skipped for lines without any. Other
+// patterns, and the patterns of other engines than RE2, always run. The matches
+// found are the same as FindAll would return.
+type prefilter struct {
+	patterns []Matcher
+	anchors  []*anchoredPattern
+	always   []bool
+	classes  [256]byte
+	width    int
+	delta    []int32
+	outputs  [][]literalOutput
+}
+
+// literalOutput is a literal that ends in an automaton state
+type literalOutput struct {
+	pattern int
+	length  int
+}
+
+// anchoredPattern matches a pattern at a given position of a text, with the
+// rune before that position as context for assertions like \b
+type anchoredPattern struct {
+	atStart *regexp.Regexp
+	atRune  *regexp.Regexp
+}
+
+// scanResult holds what a prefilter found out about a text
+type scanResult struct {
+	anchors  []*anchoredPattern
+	possible []bool
+	starts   [][]int
+}
+
+// newPrefilter builds a prefilter for patterns, or returns nil if no pattern
+// can be reduced to literals
+func newPrefilter(patterns []Matcher) *prefilter {
+	p := &prefilter{
+		patterns: patterns,
+		anchors:  make([]*anchoredPattern, len(patterns)),
+		always:   make([]bool, len(patterns)),
+	}
+
+	var literals [][]byte
+	var owners []int
+	for i, matcher := range patterns {
+		pattern, ok := matcher.(*regexp.Regexp)
+		if !ok {
+			p.always[i] = true
+			continue
+		}
+		lits, leading := patternLiterals(pattern)
+		if lits == nil {
+			p.always[i] = true
+			continue
+		}
+		if leading {
+			p.anchors[i] = anchorPattern(pattern)
+		}
+		for _, lit := range lits {
+			literals = append(literals, lit)
+			owners = append(owners, i)
+		}
+	}
+	if len(literals) == 0 {
+		return nil
+	}
+
+	p.build(literals, owners)
+	return p
+}
+
+// anchorPattern compiles the anchored forms of pattern, or returns nil if they do not compile
+func anchorPattern(pattern *regexp.Regexp) *anchoredPattern {
+	atStart, err := regexp.Compile(`\A(?:` + pattern.String() + `)`)
+	if err != nil {
+		return nil
+	}
+	atRune, err := regexp.Compile(`\A(?s:.)(` + pattern.String() + `)`)
+	if err != nil {
+		return nil
+	}
+	return &anchoredPattern{atStart: atStart, atRune: atRune}
+}
+
+// build constructs the automaton for literals, where owners holds the pattern of each literal
+func (p *prefilter) build(literals [][]byte, owners []int) {
+	// Bytes that appear in no literal share class 0
+	for _, lit := range literals {
+		for _, b := range lit {
+			if p.classes[b] == 0 {
+				p.width++
+				p.classes[b] = byte(p.width)
+			}
+		}
+	}
+	p.width++
+
+	// Build the trie; missing transitions are -1 until the failure links are known
+	p.delta = make([]int32, p.width)
+	p.outputs = [][]literalOutput{nil}
+	for i := range p.delta {
+		p.delta[i] = -1
+	}
+	for i, lit := range literals {
+		state := int32(0)
+		for _, b := range lit {
+			c := int(state)*p.width + int(p.classes[b])
+			if p.delta[c] < 0 {
+				p.delta[c] = int32(len(p.outputs))
+				p.outputs = append(p.outputs, nil)
+				for j := 0; j < p.width; j++ {
+					p.delta = append(p.delta, -1)
+				}
+			}
+			state = p.delta[c]
+		}
+		p.outputs[state] = append(p.outputs[state], literalOutput{pattern: owners[i], length: len(lit)})
+	}
+
+	// Breadth-first pass turning the trie into a complete automaton
+	fail := make([]int32, len(p.outputs))
+	var queue []int32
+	for c := 0; c < p.width; c++ {
+		if next := p.delta[c]; next < 0 {
+			p.delta[c] = 0
+		} else {
+			queue = append(queue, next)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		p.outputs[state] = append(p.outputs[state], p.outputs[fail[state]]...)
+		for c := 0; c < p.width; c++ {
+			i := int(state)*p.width + c
+			fallback := p.delta[int(fail[state])*p.width+c]
+			if next := p.delta[i]; next < 0 {
+				p.delta[i] = fallback
+			} else {
+				fail[next] = fallback
+				queue = append(queue, next)
+			}
+		}
+	}
+}
+
+// scan finds the literals of all patterns in text in a single pass.
+// A nil prefilter gives a nil result, which lets every pattern run.
+func (p *prefilter) scan(text string) *scanResult {
+	if p == nil {
+		return nil
+	}
+
+	result := &scanResult{
+		anchors:  p.anchors,
+		possible: make([]bool, len(p.patterns)),
+		starts:   make([][]int, len(p.patterns)),
+	}
+	copy(result.possible, p.always)
+
+	state := int32(0)
+	for i := 0; i < len(text); i++ {
+		state = p.delta[int(state)*p.width+int(p.classes[lowerASCII(text[i])])]
+		for _, out := range p.outputs[state] {
+			result.possible[out.pattern] = true
+			if p.anchors[out.pattern] != nil {
+				result.starts[out.pattern] = append(result.starts[out.pattern], i+1-out.length)
+			}
+		}
+	}
+
+	// Literals of different lengths can report their starts out of order
+	for i, starts := range result.starts {
+		if !sort.IntsAreSorted(starts) {
+			sort.Ints(starts)
+		}
+		result.starts[i] = dedupSorted(starts)
+	}
+	return result
+}
+
+// canMatch reports whether pattern i can match the scanned text
+func (s *scanResult) canMatch(i int) bool {
+	return s == nil || s.possible[i]
+}
+
+// sub returns the result for the patterns from offset on, or nil for a nil result
+func (s *scanResult) sub(offset int) *scanResult {
+	if s == nil {
+		return nil
+	}
+	return &scanResult{
+		anchors:  s.anchors[offset:],
+		possible: s.possible[offset:],
+		starts:   s.starts[offset:],
+	}
+}
+
+// findAll returns all matches of pattern i in text, exactly as
+// FindAllStringSubmatchIndex would, or as FindAllStringIndex when submatches
+// are not needed
+func (s *scanResult) findAll(i int, pattern Matcher, text string, submatch bool) [][]int {
+	if !s.canMatch(i) {
+		return nil
+	}
+	if s == nil || s.anchors[i] == nil {
+		if submatch {
+			return pattern.FindAllStringSubmatchIndex(text, -1)
+		}
+		return pattern.FindAllStringIndex(text, -1)
+	}
+
+	// Each match starts with a literal, so the leftmost match after the previous
+	// one is the first that succeeds at a literal start
+	anchors := s.anchors[i]
+	var matches [][]int
+	lastEnd := 0
+	for _, start := range s.starts[i] {
+		if start < lastEnd {
+			continue
+		}
+		match := anchors.matchAt(text, start)
+		if match == nil {
+			continue
+		}
+		matches = append(matches, match)
+		lastEnd = match[1]
+	}
+	return matches
+}
+
+// matchAt returns the submatches of a match starting exactly at start in text,
+// or nil if there is none
+func (a *anchoredPattern) matchAt(text string, start int) []int {
+	if start == 0 {
+		return a.atStart.FindStringSubmatchIndex(text)
+	}
+
+	_, size := utf8.DecodeLastRuneInString(text[:start])
+	match := a.atRune.FindStringSubmatchIndex(text[start-size:])
+	if match == nil {
+		return nil
+	}
+
+	// Drop the whole match, which includes the context rune, and shift the
+	// wrapping group and the pattern's own groups back to text offsets
+	match = match[2:]
+	for j := range match {
+		if match[j] >= 0 {
+			match[j] += start - size
+		}
+	}
+	return match
+}
+
+// patternLiterals returns literals, one of which appears in every match of
+// pattern, and whether every match starts with one of them. It returns nil if
+// there is no such set.
+func patternLiterals(pattern *regexp.Regexp) ([][]byte, bool) {
+	re, err := syntax.Parse(pattern.String(), syntax.Perl)
+	if err != nil {
+		return nil, false
+	}
+	re = re.Simplify()
+
+	if lits, _ := prefixLiterals(re); usableLiterals(lits) {
+		return lits, true
+	}
+	if lits := requiredLiterals(re); usableLiterals(lits) {
+		return lits, false
+	}
+	return nil, false
+}
+
+// usableLiterals reports whether lits can be searched for by the prefilter
+func usableLiterals(lits [][]byte) bool {
+	if len(lits) == 0 || len(lits) > maxPatternLiterals {
+		return false
+	}
+	for _, lit := range lits {
+		if len(lit) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// prefixLiterals returns literals, one of which every match of re starts with,
+// and whether every match is exactly one of them. It returns nil if there is
+// no such set.
+func prefixLiterals(re *syntax.Regexp) ([][]byte, bool) {
+	switch re.Op {
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText,
+		syntax.OpEndText, syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		// Assertions match no text
+		return [][]byte{{}}, true
+
+	case syntax.OpLiteral, syntax.OpCharClass:
+		lits := requiredLiterals(re)
+		return lits, lits != nil
+
+	case syntax.OpCapture:
+		return prefixLiterals(re.Sub[0])
+
+	case syntax.OpPlus:
+		lits, _ := prefixLiterals(re.Sub[0])
+		return lits, false
+
+	case syntax.OpRepeat:
+		if re.Min < 1 {
+			return nil, false
+		}
+		lits, _ := prefixLiterals(re.Sub[0])
+		return lits, false
+
+	case syntax.OpQuest:
+		lits, exact := prefixLiterals(re.Sub[0])
+		if lits == nil {
+			return nil, false
+		}
+		return dedupLiterals(append([][]byte{{}}, lits...)), exact
+
+	case syntax.OpConcat:
+		// Extend the prefixes part by part while every part is matched exactly
+		prefixes := [][]byte{{}}
+		for _, sub := range re.Sub {
+			lits, exact := prefixLiterals(sub)
+			if lits == nil || len(prefixes)*len(lits) > maxPatternLiterals {
+				return prefixes, false
+			}
+			prefixes = crossLiterals(prefixes, lits)
+			if !exact {
+				return prefixes, false
+			}
+		}
+		return prefixes, true
+
+	case syntax.OpAlternate:
+		var lits [][]byte
+		exact := true
+		for _, sub := range re.Sub {
+			subLits, subExact := prefixLiterals(sub)
+			if subLits == nil {
+				return nil, false
+			}
+			lits = append(lits, subLits...)
+			exact = exact && subExact
+		}
+		return dedupLiterals(lits), exact
+	}
+
+	return nil, false
+}
+
+// requiredLiterals returns literals, one of which every match of re contains,
+// or nil if there is no such set
+func requiredLiterals(re *syntax.Regexp) [][]byte {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return foldedLiterals(re.Rune)
+		}
+		return [][]byte{lowerLiteral(re.Rune)}
+
+	case syntax.OpCharClass:
+		var runes []rune
+		for i := 0; i < len(re.Rune); i += 2 {
+			if re.Rune[i+1]-re.Rune[i] >= maxClassLiterals {
+				return nil
+			}
+			for r := re.Rune[i]; r <= re.Rune[i+1]; r++ {
+				runes = append(runes, r)
+			}
+			if len(runes) > maxClassLiterals {
+				return nil
+			}
+		}
+		var lits [][]byte
+		for _, r := range runes {
+			lits = append(lits, lowerLiteral([]rune{r}))
+		}
+		return dedupLiterals(lits)
+
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+
+	case syntax.OpRepeat:
+		if re.Min < 1 {
+			return nil
+		}
+		return requiredLiterals(re.Sub[0])
+
+	case syntax.OpConcat:
+		// Every part of the concatenation must appear, so take the most selective one
+		var best [][]byte
+		for _, sub := range re.Sub {
+			if lits := requiredLiterals(sub); lits != nil && betterLiterals(lits, best) {
+				best = lits
+			}
+		}
+		return best
+
+	case syntax.OpAlternate:
+		var lits [][]byte
+		for _, sub := range re.Sub {
+			subLits := requiredLiterals(sub)
+			if subLits == nil {
+				return nil
+			}
+			lits = append(lits, subLits...)
+		}
+		return dedupLiterals(lits)
+	}
+
+	return nil
+}
+
+// crossLiterals returns every literal of a followed by every literal of b
+func crossLiterals(a, b [][]byte) [][]byte {
+	var lits [][]byte
+	for _, x := range a {
+		for _, y := range b {
+			lit := make([]byte, 0, len(x)+len(y))
+			lits = append(lits, append(append(lit, x...), y...))
+		}
+	}
+	return dedupLiterals(lits)
+}
+
+// betterLiterals reports whether a is more selective than b: its shortest
+// literal is longer, or it has fewer literals of the same shortest length
+func betterLiterals(a, b [][]byte) bool {
+	if b == nil {
+		return true
+	}
+	if shortestLiteral(a) != shortestLiteral(b) {
+		return shortestLiteral(a) > shortestLiteral(b)
+	}
+	return len(a) < len(b)
+}
+
+// shortestLiteral returns the length of the shortest of lits
+func shortestLiteral(lits [][]byte) int {
+	shortest := len(lits[0])
+	for _, lit := range lits[1:] {
+		if len(lit) < shortest {
+			shortest = len(lit)
+		}
+	}
+	return shortest
+}
+
+// dedupLiterals removes repeated literals, such as cases folded together
+func dedupLiterals(lits [][]byte) [][]byte {
+	seen := make(map[string]bool, len(lits))
+	var unique [][]byte
+	for _, lit := range lits {
+		if !seen[string(lit)] {
+			seen[string(lit)] = true
+			unique = append(unique, lit)
+		}
+	}
+	return unique
+}
+
+// dedupSorted removes repeated values from a sorted slice
+func dedupSorted(values []int) []int {
+	if len(values) < 2 {
+		return values
+	}
+	unique := values[:1]
+	for _, v := range values[1:] {
+		if v != unique[len(unique)-1] {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
+
+// lowerLiteral encodes runes as UTF-8 with ASCII letters lowercased
+func lowerLiteral(runes []rune) []byte {
+	var lit []byte
+	for _, r := range runes {
+		lit = utf8.AppendRune(lit, r)
+	}
+	for i := range lit {
+		lit[i] = lowerASCII(lit[i])
+	}
+	return lit
+}
+
+// lowerASCII lowercases an ASCII letter and leaves other bytes alone
+func lowerASCII(b byte) byte {
+	if 'A' <= b && b <= 'Z' {
+		return b + 'a' - 'A'
+	}
+	return b
+}
+
+// foldedLiterals returns the literals matched by runes when case is ignored.
+// Only ASCII letters are lowercased in the scan, so other case variants, like
+// the Kelvin sign for K, become literals of their own.
+func foldedLiterals(runes []rune) [][]byte {
+	lits := [][]byte{{}}
+	for _, r := range runes {
+		var variants [][]byte
+		for f := unicode.SimpleFold(r); ; f = unicode.SimpleFold(f) {
+			variants = append(variants, lowerLiteral([]rune{f}))
+			if f == r {
+				break
+			}
+		}
+		variants = dedupLiterals(variants)
+		if len(lits)*len(variants) > maxPatternLiterals {
+			return nil
+		}
+		lits = crossLiterals(lits, variants)
+	}
+	return lits
+}
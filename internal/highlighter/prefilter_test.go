@@ -0,0 +1,172 @@
+package highlighter
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// prefilterTexts are lines that exercise word boundaries, case folding and UTF-8
+var prefilterTexts = []string{
+	"",
+	"func main() { return nil }",
+	"xfunc funcx func_ func",
+	"FUNC Func fUnC",
+	"héllo wörld func—return «func» ñfunc",
+	"\"a\" \"b\"c\" // note \"d\"",
+	"0x1F 12.5 7 a1 1a",
+	"/* a */ /* b */ */ /*",
+	"\xff\xfefunc\xff",
+	"ααα βfunc γ",
+	"\u212aeys keys KEYſ",
+}
+
+func TestPrefilterFindAll(t *testing.T) {
+	patterns := []string{
+		`\b(func|return)\b`,
+		`(?i)\bfunc\b`,
+		`"[^"]*"`,
+		`//.*`,
+		`/\*`,
+		`\*/`,
+		`\b0x[0-9a-fA-F]+\b`,
+		`\b\d+(\.\d+)?\b`,
+		`\b([A-Za-z_]\w*)\(`,
+		`(func|re)(turn)?`,
+		`a*`,
+		`ñ\w+`,
+		`\Bunc`,
+		`^func`,
+		`(?:fu|re)nc?`,
+		`(?i)keys`,
+		`(?i)\bks?\b`,
+	}
+
+	// Add the rule and region patterns of every shipped language
+	for _, language := range loadShippedConfig(t).Languages {
+		for _, rule := range language.Rules {
+			patterns = append(patterns, rule.Pattern)
+		}
+		for _, region := range language.Regions {
+			patterns = append(patterns, region.Begin)
+		}
+	}
+
+	texts := append([]string{}, prefilterTexts...)
+	texts = append(texts, strings.Split(string(sampleSource(8)), "\n")...)
+
+	var compiled []Matcher
+	for _, pattern := range patterns {
+		compiled = append(compiled, regexp.MustCompile(pattern))
+	}
+	filter := newPrefilter(compiled)
+
+	for _, text := range texts {
+		scan := filter.scan(text)
+		for i, pattern := range compiled {
+			got := scan.findAll(i, pattern, text, true)
+			want := pattern.FindAllStringSubmatchIndex(text, -1)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("findAll(%q, %q) = %v, want %v", pattern, text, got, want)
+			}
+
+			got = scan.findAll(i, pattern, text, false)
+			want = pattern.FindAllStringIndex(text, -1)
+			for j := range got {
+				got[j] = got[j][:2]
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("findAll(%q, %q) without submatches = %v, want %v", pattern, text, got, want)
+			}
+		}
+	}
+}
+
+func TestPatternLiterals(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    []string
+		leading bool
+	}{
+		{`\b(func|return)\b`, []string{"func", "return"}, true},
+		{`(?i)Select`, []string{"select", "ſelect"}, true},
+		{`"[^"]*"`, []string{`"`}, true},
+		{`\w+\(`, []string{"("}, false},
+		{`\w+`, nil, false},
+		{`a*`, nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern, func(t *testing.T) {
+			lits, leading := patternLiterals(regexp.MustCompile(tt.pattern))
+			var got []string
+			for _, lit := range lits {
+				got = append(got, string(lit))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("patternLiterals() literals = %q, want %q", got, tt.want)
+			}
+			if got != nil && leading != tt.leading {
+				t.Errorf("patternLiterals() leading = %v, want %v", leading, tt.leading)
+			}
+		})
+	}
+}
+
+func TestProcessContentWithoutPrefilter(t *testing.T) {
+	cfg := loadShippedConfig(t)
+	cfg.Languages["large"] = largeRuleSet()
+	data := sampleSource(64)
+
+	for name := range cfg.Languages {
+		t.Run(name, func(t *testing.T) {
+			filtered, err := NewHighlighter(cfg, name, LF, Options{})
+			if err != nil {
+				t.Fatalf("NewHighlighter() error = %v", err)
+			}
+			plain, err := NewHighlighter(cfg, name, LF, Options{})
+			if err != nil {
+				t.Fatalf("NewHighlighter() error = %v", err)
+			}
+			disablePrefilters(plain)
+
+			if got, want := filtered.ProcessContent(data), plain.ProcessContent(data); got != want {
+				t.Errorf("ProcessContent() with prefilter differs:\n got %q\nwant %q", got, want)
+			}
+		})
+	}
+}
+
+// disablePrefilters removes the prefilters of a highlighter, of the child rules
+// of its rules and of every state reachable from it, so all patterns run with
+// FindAll
+func disablePrefilters(h *Highlighter) {
+	l, ok := h.lexer.(*RegexLexer)
+	if !ok {
+		return
+	}
+	var clearRules func(rules []CompiledRule)
+	clearRules = func(rules []CompiledRule) {
+		for i := range rules {
+			rules[i].filter = nil
+			clearRules(rules[i].Rules)
+		}
+	}
+	l.filter = nil
+	clearRules(l.rules)
+	seen := map[*compiledState]bool{}
+	var walk func(regions []compiledRegion)
+	walk = func(regions []compiledRegion) {
+		for _, region := range regions {
+			if seen[region.state] {
+				continue
+			}
+			seen[region.state] = true
+			region.state.filter = nil
+			clearRules(region.state.rules)
+			walk(region.state.regions)
+		}
+	}
+	walk(l.regions)
+}
@@ -0,0 +1,144 @@
+package highlighter
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"go/format"
+	"io"
+	"strings"
+)
+
+// Built-in reformatters a language can select
+const (
+	ReformatJSON = "json"
+	ReformatXML  = "xml"
+	ReformatGo   = "go"
+)
+
+// reformatIndent is the indentation of the built-in reformatters, except Go's,
+// which uses tabs as gofmt does
+const reformatIndent = "  "
+
+// Reformatter pretty-prints the whole source of a language, returning an error
+// if the source cannot be parsed
+type Reformatter func(src []byte) ([]byte, error)
+
+// reformatters holds the reformatters languages can select by name
+var reformatters = map[string]Reformatter{
+	ReformatJSON: reformatJSON,
+	ReformatXML:  reformatXML,
+	ReformatGo:   format.Source,
+}
+
+// RegisterReformatter makes a reformatter available to the languages that
+// select it by name. It is meant to be called during initialization.
+func RegisterReformatter(name string, reformat Reformatter) {
+	reformatters[name] = reformat
+}
+
+// Reformat pretty-prints src with the reformatter the language lang selects.
+// It returns src unchanged for languages without a reformatter, and src with an
+// error when the reformatter is unknown or fails, so the caller can always
+// highlight the result.
+func Reformat(cfg Config, lang string, src []byte) ([]byte, error) {
+	name := cfg.Languages[lang].Reformat
+	if name == "" {
+		return src, nil
+	}
+	reformat, ok := reformatters[name]
+	if !ok {
+		return src, fmt.Errorf("unknown reformatter for %s: %s", lang, name)
+	}
+	out, err := reformat(src)
+	if err != nil {
+		return src, err
+	}
+	return out, nil
+}
+
+// reformatJSON indents each of the JSON values in src, so that JSON Lines
+// become a sequence of indented values
+func reformatJSON(src []byte) ([]byte, error) {
+	var out bytes.Buffer
+	decoder := json.NewDecoder(bytes.NewReader(src))
+	for {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if err := json.Indent(&out, value, "", reformatIndent); err != nil {
+			return nil, err
+		}
+		out.WriteByte('\n')
+	}
+	return out.Bytes(), nil
+}
+
+// reformatXML puts each element, comment and processing instruction of src on a
+// line of its own, indented by depth. Elements holding only text stay on one
+// line. The tokens are copied from src as written, so entities, namespace
+// prefixes and quoting are kept.
+func reformatXML(src []byte) ([]byte, error) {
+	var out bytes.Buffer
+	decoder := xml.NewDecoder(bytes.NewReader(src))
+	depth := 0
+	// Whether the last token opened an element, or was the only text in it so far
+	opened, text := false, false
+
+	newline := func() {
+		if out.Len() > 0 {
+			out.WriteByte('\n')
+		}
+		out.WriteString(strings.Repeat(reformatIndent, depth))
+	}
+
+	for {
+		start := decoder.InputOffset()
+		tok, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		raw := src[start:decoder.InputOffset()]
+
+		switch tok.(type) {
+		case xml.StartElement:
+			newline()
+			out.Write(raw)
+			depth++
+			opened, text = true, false
+		case xml.EndElement:
+			// Self-closing elements end where they start, with an empty raw token
+			depth--
+			if !opened && !text {
+				newline()
+			}
+			out.Write(raw)
+			opened, text = false, false
+		case xml.CharData:
+			trimmed := bytes.TrimSpace(raw)
+			if len(trimmed) == 0 {
+				continue
+			}
+			if !opened {
+				newline()
+			}
+			out.Write(trimmed)
+			opened, text = false, opened
+		default:
+			newline()
+			out.Write(raw)
+			opened, text = false, false
+		}
+	}
+	if out.Len() == 0 {
+		return nil, fmt.Errorf("no XML content")
+	}
+	out.WriteByte('\n')
+	return out.Bytes(), nil
+}
@@ -0,0 +1,93 @@
+package highlighter
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestReformat(t *testing.T) {
+	tests := []struct {
+		name     string
+		reformat string
+		input    string
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "json",
+			reformat: ReformatJSON,
+			input:    `{"a":[1,{"b":null}]}`,
+			want:     "{\n  \"a\": [\n    1,\n    {\n      \"b\": null\n    }\n  ]\n}\n",
+		},
+		{
+			name:     "json lines",
+			reformat: ReformatJSON,
+			input:    "{\"a\":1}\n[]\n",
+			want:     "{\n  \"a\": 1\n}\n[]\n",
+		},
+		{
+			name:     "invalid json",
+			reformat: ReformatJSON,
+			input:    `{"a":}`,
+			wantErr:  true,
+		},
+		{
+			name:     "xml",
+			reformat: ReformatXML,
+			input:    `<?xml version="1.0"?><a x='1'><!-- c --><b>&amp; "t"</b><c/><d>m<e/>n</d></a>`,
+			want: "<?xml version=\"1.0\"?>\n<a x='1'>\n  <!-- c -->\n  <b>&amp; \"t\"</b>\n  <c/>\n" +
+				"  <d>m\n    <e/>\n    n\n  </d>\n</a>\n",
+		},
+		{
+			name:     "mismatched xml",
+			reformat: ReformatXML,
+			input:    `<a><b></a>`,
+			wantErr:  true,
+		},
+		{
+			name:     "go",
+			reformat: ReformatGo,
+			input:    "package main\nfunc main(){x:=1;_=x}\n",
+			want:     "package main\n\nfunc main() { x := 1; _ = x }\n",
+		},
+		{
+			name:     "unknown reformatter",
+			reformat: "cobol",
+			input:    "x",
+			wantErr:  true,
+		},
+		{
+			name:  "no reformatter",
+			input: "as is",
+			want:  "as is",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Config{Languages: map[string]Language{"test": {Reformat: tt.reformat}}}
+			got, err := Reformat(cfg, "test", []byte(tt.input))
+			if tt.wantErr {
+				// The source is returned as is, so it can still be highlighted
+				if err == nil || string(got) != tt.input {
+					t.Errorf("Reformat() = %q, %v, want the input and an error", got, err)
+				}
+				return
+			}
+			if err != nil || string(got) != tt.want {
+				t.Errorf("Reformat() = %q, %v, want %q", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestRegisterReformatter(t *testing.T) {
+	RegisterReformatter("upper", func(src []byte) ([]byte, error) { return bytes.ToUpper(src), nil })
+	defer delete(reformatters, "upper")
+
+	cfg := Config{Languages: map[string]Language{"shout": {Reformat: "upper"}}}
+	got, err := Reformat(cfg, "shout", []byte("quiet"))
+	if err != nil || string(got) != "QUIET" {
+		t.Errorf("Reformat() = %q, %v, want %q", got, err, "QUIET")
+	}
+}
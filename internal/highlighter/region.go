@@ -0,0 +1,501 @@
+package highlighter
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// RootState is the state name that refers to the top-level rules and regions of a language
+const RootState = "root"
+
+// State is a named set of rules and regions that applies inside a region
+type State struct {
+	Rules   []HighlightRule
+	Regions []Region
+}
+
+// Region defines a span of text delimited by begin and end patterns that may cross lines.
+// The region text is styled with Style, and the rules of State apply inside it.
+// Instead of a state, the region can hand its text to another configured language,
+// either named by Language or read from the LanguageCapture group of the begin match.
+// End can refer to the text of a begin capture group with \1 to \9 or \k<name>,
+// for delimiters chosen where the region starts, such as the word after << that
+// ends a heredoc.
+type Region struct {
+	Name            string
+	Begin           string
+	End             string
+	Style           string
+	State           string
+	Language        string
+	LanguageCapture string
+}
+
+// compiledState is a compiled version of State
+type compiledState struct {
+	rules   []CompiledRule
+	regions []compiledRegion
+	filter  *prefilter
+}
+
+// compiledRegion is a compiled version of Region.
+// A region whose end refers to begin captures keeps the end pattern as a
+// template, and caches the patterns built from it by the text they match.
+type compiledRegion struct {
+	name          string
+	begin         *regexp.Regexp
+	end           *regexp.Regexp
+	endTemplate   string
+	ends          map[string]*regexp.Regexp
+	style         string
+	state         *compiledState
+	language      string
+	languageGroup int
+}
+
+// frame is an open region with its end pattern, and the lexer of its
+// embedded language if it has one
+type frame struct {
+	region *compiledRegion
+	end    *regexp.Regexp
+	sub    Lexer
+}
+
+// compileStates compiles the top-level rules and regions of a language together
+// with every state they can switch to, and returns the top-level state
+func compileStates(language Language) (*compiledState, error) {
+	root := &compiledState{}
+	states := map[string]*compiledState{RootState: root}
+	for name := range language.States {
+		if name == RootState {
+			return nil, fmt.Errorf("state name %q is reserved", RootState)
+		}
+		states[name] = &compiledState{}
+	}
+
+	var err error
+	if root.rules, err = compileRules(language.Rules); err != nil {
+		return nil, err
+	}
+	if root.regions, err = compileRegions(language.Regions, states); err != nil {
+		return nil, err
+	}
+	root.filter = stateFilter(root)
+
+	for name, state := range language.States {
+		compiled := states[name]
+		if compiled.rules, err = compileRules(state.Rules); err != nil {
+			return nil, err
+		}
+		if compiled.regions, err = compileRegions(state.Regions, states); err != nil {
+			return nil, err
+		}
+		compiled.filter = stateFilter(compiled)
+	}
+
+	return root, nil
+}
+
+// stateFilter builds the prefilter for the rule patterns of a state, followed by
+// the begin patterns of its regions
+func stateFilter(state *compiledState) *prefilter {
+	var patterns []Matcher
+	for _, rule := range state.rules {
+		patterns = append(patterns, rule.Pattern)
+	}
+	for _, region := range state.regions {
+		patterns = append(patterns, region.begin)
+	}
+	return newPrefilter(patterns)
+}
+
+// compileRegions compiles the begin and end patterns of a region list
+func compileRegions(regions []Region, states map[string]*compiledState) ([]compiledRegion, error) {
+	var compiled []compiledRegion
+	for _, region := range regions {
+		begin, err := regexp.Compile(region.Begin)
+		if err != nil {
+			return nil, fmt.Errorf("invalid begin pattern for region %s: %v", region.Name, err)
+		}
+		// An end that refers to begin captures is checked with the references empty
+		pattern, dynamic, err := expandReferences(region.End, begin, func(int) string { return "" })
+		if err != nil {
+			return nil, fmt.Errorf("invalid end pattern for region %s: %v", region.Name, err)
+		}
+		end, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid end pattern for region %s: %v", region.Name, err)
+		}
+		endTemplate := ""
+		if dynamic {
+			endTemplate = region.End
+		}
+
+		// A region without a state only styles its text
+		state := &compiledState{}
+		if region.State != "" {
+			var ok bool
+			if state, ok = states[region.State]; !ok {
+				return nil, fmt.Errorf("unknown state %q for region %s", region.State, region.Name)
+			}
+		}
+
+		languageGroup := 0
+		if region.LanguageCapture != "" {
+			if languageGroup, err = groupIndex(begin, region.LanguageCapture); err != nil {
+				return nil, fmt.Errorf("unknown language capture group %q for region %s", region.LanguageCapture, region.Name)
+			}
+		}
+
+		compiled = append(compiled, compiledRegion{
+			name:          region.Name,
+			begin:         begin,
+			end:           end,
+			endTemplate:   endTemplate,
+			style:         region.Style,
+			state:         state,
+			language:      region.Language,
+			languageGroup: languageGroup,
+		})
+	}
+	return compiled, nil
+}
+
+// expandReferences replaces the references to begin capture groups in an end
+// pattern, \1 to \9 or \k<name>, with the text group returns for the group
+// index. It reports whether the pattern has any references, and fails for
+// references to groups the begin pattern does not have.
+func expandReferences(pattern string, begin *regexp.Regexp, group func(int) string) (string, bool, error) {
+	var b strings.Builder
+	found := false
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '\\' || i+1 == len(pattern) {
+			b.WriteByte(pattern[i])
+			continue
+		}
+
+		next := pattern[i+1]
+		switch {
+		case next >= '1' && next <= '9':
+			index := int(next - '0')
+			if index > begin.NumSubexp() {
+				return "", false, fmt.Errorf("reference \\%c to a group the begin pattern does not have", next)
+			}
+			b.WriteString(group(index))
+			found = true
+			i++
+		case next == 'k' && strings.HasPrefix(pattern[i+2:], "<") && strings.Contains(pattern[i+2:], ">"):
+			name := pattern[i+3 : i+2+strings.IndexByte(pattern[i+2:], '>')]
+			index := begin.SubexpIndex(name)
+			if index < 0 {
+				return "", false, fmt.Errorf("reference \\k<%s> to a group the begin pattern does not have", name)
+			}
+			b.WriteString(group(index))
+			found = true
+			i += 3 + len(name)
+		default:
+			// Other escapes are copied whole, so \\1 stays a backslash and a digit
+			b.WriteString(pattern[i : i+2])
+			i++
+		}
+	}
+	return b.String(), found, nil
+}
+
+// endPattern returns the end pattern of a region opened by the begin match
+// delim in line, with the references to begin captures replaced by their text
+func (r *compiledRegion) endPattern(line string, delim []int) *regexp.Regexp {
+	if r.endTemplate == "" {
+		return r.end
+	}
+
+	pattern, _, _ := expandReferences(r.endTemplate, r.begin, func(index int) string {
+		if delim[2*index] < 0 {
+			return ""
+		}
+		return regexp.QuoteMeta(line[delim[2*index]:delim[2*index+1]])
+	})
+	if end, ok := r.ends[pattern]; ok {
+		return end
+	}
+
+	end, err := regexp.Compile(pattern)
+	if err != nil {
+		// Quoted text keeps a valid template valid, but fall back just in case
+		end = r.end
+	}
+	if r.ends == nil {
+		r.ends = make(map[string]*regexp.Regexp)
+	}
+	r.ends[pattern] = end
+	return end
+}
+
+// current returns the innermost open region, or nil at the top level
+func (l *RegexLexer) current() *frame {
+	if len(l.stack) == 0 {
+		return nil
+	}
+	return &l.stack[len(l.stack)-1]
+}
+
+// active returns the rules, regions and prefilter that apply in the current state
+func (l *RegexLexer) active() ([]CompiledRule, []compiledRegion, *prefilter) {
+	if current := l.current(); current != nil {
+		state := current.region.state
+		return state.rules, state.regions, state.filter
+	}
+	return l.rules, l.regions, l.filter
+}
+
+// lineTokens tokenizes a line, entering and leaving regions as their delimiters
+// are found. The open regions are kept on the highlighter so they carry over to
+// the next line.
+func (l *RegexLexer) lineTokens(line string) []span {
+	var tokens []span
+	pos := 0
+
+	for {
+		current := l.current()
+
+		// An embedded language highlights everything up to the end of its region
+		if current != nil && current.sub != nil {
+			end := firstUnmasked(current.end.FindAllStringSubmatchIndex(line[pos:], -1), pos, nil, true)
+			limit := len(line)
+			if end != nil {
+				limit = end[0]
+			}
+			// The empty rest of a line after a delimiter, or before one, is no
+			// line of the embedded text, so its lexer does not see it
+			if limit > pos || (pos == 0 && end == nil) {
+				tokens = append(tokens, shiftTokens(tokenSpans(current.sub.Tokenize(line[pos:limit])), pos)...)
+			}
+
+			if end == nil {
+				return tokens
+			}
+			tokens = l.closeRegion(tokens, end)
+			pos = end[1]
+			continue
+		}
+
+		rules, regions, filter := l.active()
+
+		// Rules are matched against the rest of the line in the current state,
+		// skipping those the prefilter rules out
+		scan := filter.scan(line[pos:])
+		ruleTokens := l.ruleTokens(rules, scan, line[pos:], pos)
+
+		delim, opens := l.nextDelimiter(line, pos, current, regions, scan.sub(len(rules)), ruleTokens)
+		limit := len(line)
+		if delim != nil {
+			limit = delim[0]
+		}
+
+		// Emit the rule tokens before the delimiter, filling the gaps with the
+		// region style, which the styles of the rule tokens are laid over
+		last := pos
+		for _, token := range ruleTokens {
+			if token.start >= limit {
+				break
+			}
+			if token.start > last {
+				tokens = l.appendRegionSpan(tokens, current, last, token.start)
+			}
+			if current != nil {
+				token = token.within(current.region.style)
+			}
+			tokens = append(tokens, token)
+			last = token.end
+		}
+		if limit > last {
+			tokens = l.appendRegionSpan(tokens, current, last, limit)
+		}
+
+		if delim == nil {
+			return tokens
+		}
+
+		if opens != nil {
+			tokens = l.openRegion(tokens, opens, line, delim)
+		} else {
+			tokens = l.closeRegion(tokens, delim)
+		}
+		pos = delim[1]
+	}
+}
+
+// openRegion pushes a region opened by the begin match delim and appends the
+// delimiter token, styled like the region
+func (l *RegexLexer) openRegion(tokens []span, region *compiledRegion, line string, delim []int) []span {
+	language := region.language
+	if group := region.languageGroup; group > 0 && delim[2*group] >= 0 {
+		language = line[delim[2*group]:delim[2*group+1]]
+	}
+
+	l.stack = append(l.stack, frame{region: region, end: region.endPattern(line, delim), sub: l.subLexer(language)})
+	if delim[1] > delim[0] {
+		tokens = l.appendRegionSpan(tokens, l.current(), delim[0], delim[1])
+	}
+	return tokens
+}
+
+// closeRegion pops the current region at the end match delim and appends the
+// delimiter token, styled like the region
+func (l *RegexLexer) closeRegion(tokens []span, delim []int) []span {
+	if delim[1] > delim[0] {
+		tokens = l.appendRegionSpan(tokens, l.current(), delim[0], delim[1])
+	}
+	l.stack = l.stack[:len(l.stack)-1]
+	return tokens
+}
+
+// appendRegionSpan appends a span from start to end styled like the region of
+// current, unless there is no open region or it has no style
+func (l *RegexLexer) appendRegionSpan(tokens []span, current *frame, start, end int) []span {
+	if current == nil || current.region.style == "" {
+		return tokens
+	}
+	return append(tokens, span{
+		start:    start,
+		end:      end,
+		style:    current.region.style,
+		name:     current.region.name,
+		language: l.language,
+	})
+}
+
+// within returns the span, and its parts, with style added as their outermost parent
+func (s span) within(style string) span {
+	if style == "" {
+		return s
+	}
+	s.parents = withParent(style, s.parents)
+	if len(s.parts) > 0 {
+		parts := make([]span, len(s.parts))
+		for i, part := range s.parts {
+			part.parents = withParent(style, part.parents)
+			parts[i] = part
+		}
+		s.parts = parts
+	}
+	return s
+}
+
+// subLexer returns a lexer for an embedded language, built from the same
+// configuration and cached for later regions. It returns nil when the language
+// is not configured, in which case the region is highlighted as if it had no
+// language.
+func (l *RegexLexer) subLexer(name string) Lexer {
+	lang := lookupLanguage(l.config, name)
+	if lang == "" {
+		return nil
+	}
+
+	if sub, ok := l.subs[lang]; ok {
+		if sub != nil {
+			// Each region starts the embedded language afresh
+			sub.Reset()
+		}
+		return sub
+	}
+
+	sub, err := newLexer(l.config, lang)
+	if err != nil {
+		sub = nil
+	}
+	if l.subs == nil {
+		l.subs = make(map[string]Lexer)
+	}
+	l.subs[lang] = sub
+	return sub
+}
+
+// lookupLanguage resolves an embedded language name, such as a Markdown fence
+// info string, to a configured language by name or by file extension
+func lookupLanguage(cfg Config, name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" {
+		return ""
+	}
+	if _, ok := cfg.Languages[name]; ok {
+		return name
+	}
+	for lang, language := range cfg.Languages {
+		for _, ext := range language.Extensions {
+			if ext == name {
+				return lang
+			}
+		}
+	}
+	return ""
+}
+
+// shiftTokens moves tokens found in a part of a line by offset
+func shiftTokens(tokens []span, offset int) []span {
+	for i := range tokens {
+		tokens[i].start += offset
+		tokens[i].end += offset
+		for j := range tokens[i].parts {
+			tokens[i].parts[j].start += offset
+			tokens[i].parts[j].end += offset
+		}
+	}
+	return tokens
+}
+
+// nextDelimiter finds the earliest end of the current region or begin of a new
+// region that is not inside a rule token. It returns the delimiter position and
+// the region it opens, which is nil when the delimiter closes the current region.
+// At the same position an end wins over a begin, and begins win over rule tokens.
+func (l *RegexLexer) nextDelimiter(line string, pos int, current *frame, regions []compiledRegion, scan *scanResult, tokens []span) ([]int, *compiledRegion) {
+	var best []int
+	var opens *compiledRegion
+
+	if current != nil {
+		best = firstUnmasked(current.end.FindAllStringSubmatchIndex(line[pos:], -1), pos, tokens, true)
+	}
+
+	for i := range regions {
+		matches := scan.findAll(i, regions[i].begin, line[pos:], true)
+		match := firstUnmasked(matches, pos, tokens, false)
+		if match != nil && (best == nil || match[0] < best[0]) {
+			best = match
+			opens = &regions[i]
+		}
+	}
+
+	return best, opens
+}
+
+// firstUnmasked returns the first of matches, found in the line from pos, that
+// does not start inside one of tokens, with its submatch offsets relative to the
+// line. Empty matches are only accepted when allowEmpty is set.
+func firstUnmasked(matches [][]int, pos int, tokens []span, allowEmpty bool) []int {
+	for _, match := range matches {
+		if match[0] == match[1] && !allowEmpty {
+			continue
+		}
+		if insideToken(pos+match[0], tokens) {
+			continue
+		}
+		for i := range match {
+			if match[i] >= 0 {
+				match[i] += pos
+			}
+		}
+		return match
+	}
+	return nil
+}
+
+// insideToken reports whether offset falls strictly after the start of one of tokens
+func insideToken(offset int, tokens []span) bool {
+	for _, token := range tokens {
+		if token.start < offset && offset < token.end {
+			return true
+		}
+	}
+	return false
+}
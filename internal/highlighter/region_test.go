@@ -0,0 +1,319 @@
+package highlighter
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// newRegionHighlighter creates a highlighter for a small language with regions
+func newRegionHighlighter(t *testing.T) *Highlighter {
+	t.Helper()
+
+	cfg := Config{
+		Languages: map[string]Language{
+			"test": {
+				Rules: []HighlightRule{
+					{Name: "keywords", Pattern: `\b(func|return)\b`, Style: "keyword"},
+					{Name: "strings", Pattern: `"[^"]*"`, Style: "string"},
+				},
+				Regions: []Region{
+					{Name: "block_comments", Begin: `/\*`, End: `\*/`, Style: "comment"},
+					{Name: "docstrings", Begin: `"""`, End: `"""`, Style: "string", State: "docstring"},
+				},
+				States: map[string]State{
+					"docstring": {
+						Rules: []HighlightRule{
+							{Name: "escapes", Pattern: `\\.`, Style: "escape"},
+						},
+					},
+				},
+				Styles: map[string]string{
+					"keyword": "cyan",
+					"string":  "green",
+					"comment": "yellow",
+					"escape":  "magenta",
+				},
+			},
+		},
+	}
+
+	h, err := NewHighlighter(cfg, "test", LF, Options{})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+	return h
+}
+
+func TestRegionAcrossLines(t *testing.T) {
+	h := newRegionHighlighter(t)
+
+	output := h.ProcessContent([]byte("x /* start\nfunc inside\nend */ return\n"))
+	lines := strings.Split(output, LF)
+
+	if want := "x " + Yellow + "/*" + Reset + Yellow + " start" + Reset; lines[0] != want {
+		t.Errorf("line 1 = %q, want %q", lines[0], want)
+	}
+	if want := Yellow + "func inside" + Reset; lines[1] != want {
+		t.Errorf("line 2 = %q, want %q", lines[1], want)
+	}
+	if want := Yellow + "end " + Reset + Yellow + "*/" + Reset + " " + Cyan + "return" + Reset; lines[2] != want {
+		t.Errorf("line 3 = %q, want %q", lines[2], want)
+	}
+}
+
+func TestRegionAcrossProcessContentCalls(t *testing.T) {
+	h := newRegionHighlighter(t)
+
+	h.ProcessContent([]byte("\"\"\"doc\n"))
+	output := h.ProcessContent([]byte("a\\n b\n"))
+
+	want := Green + "a" + Reset + Magenta + "\\n" + Reset + Green + " b" + Reset + LF
+	if output != want {
+		t.Errorf("ProcessContent() = %q, want %q", output, want)
+	}
+
+	output = h.ProcessContent([]byte("\"\"\" return\n"))
+	want = Green + "\"\"\"" + Reset + " " + Cyan + "return" + Reset + LF
+	if output != want {
+		t.Errorf("ProcessContent() after region end = %q, want %q", output, want)
+	}
+}
+
+func TestRegionMaskedByRule(t *testing.T) {
+	h := newRegionHighlighter(t)
+
+	// The begin delimiter inside a string must not open a region
+	h.ProcessContent([]byte("\"/*\"\n"))
+	if len(h.lexer.(*RegexLexer).stack) != 0 {
+		t.Errorf("region opened inside a string, stack depth = %d", len(h.lexer.(*RegexLexer).stack))
+	}
+
+	// An escaped delimiter inside a region must not close it
+	h.ProcessContent([]byte("\"\"\" \\\"\"\"\n"))
+	if len(h.lexer.(*RegexLexer).stack) != 1 {
+		t.Errorf("escaped delimiter closed the region, stack depth = %d", len(h.lexer.(*RegexLexer).stack))
+	}
+}
+
+func TestRegionUnknownState(t *testing.T) {
+	cfg := Config{
+		Languages: map[string]Language{
+			"test": {
+				Regions: []Region{
+					{Name: "broken", Begin: `<`, End: `>`, State: "missing"},
+				},
+			},
+		},
+	}
+
+	if _, err := NewHighlighter(cfg, "test", LF, Options{}); err == nil {
+		t.Error("NewHighlighter() expected error for unknown state")
+	}
+}
+
+func TestRegionEndReferences(t *testing.T) {
+	cfg := Config{
+		Languages: map[string]Language{
+			"test": {
+				Regions: []Region{
+					{Name: "raw_strings", Begin: `r(#*)"`, End: `"\1`, Style: "string"},
+					{Name: "heredocs", Begin: `<<(?P<tag>\w+)`, End: `^\k<tag>$`, Style: "heredoc"},
+					{Name: "long_strings", Begin: `\[(=*)\[`, End: `\]\1\]`, Style: "string"},
+				},
+				Styles: map[string]string{"string": "green", "heredoc": "yellow"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name   string
+		input  string
+		closes []bool
+	}{
+		{"raw string", "r##\"a \"# b\n\"##\n", []bool{false, true}},
+		{"raw string without hashes", "r\"a\nb\" c\n", []bool{false, true}},
+		{"heredoc", "<<EOF\n EOF\nEOFX\nEOF\n", []bool{false, false, false, true}},
+		{"long string", "[==[ ]] ]=]\n]==]\n", []bool{false, true}},
+		{"short delimiter", "<<a\n.\na\n", []bool{false, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := NewHighlighter(cfg, "test", LF, Options{})
+			if err != nil {
+				t.Fatalf("NewHighlighter() error = %v", err)
+			}
+
+			// The end delimiter carries over lines until the captured text is found
+			for i, line := range strings.SplitAfter(strings.TrimSuffix(tt.input, LF), LF) {
+				h.ProcessContent([]byte(line))
+				if closed := len(h.lexer.(*RegexLexer).stack) == 0; closed != tt.closes[i] {
+					t.Errorf("after line %d closed = %v, want %v", i+1, closed, tt.closes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRegionEndReferenceErrors(t *testing.T) {
+	for _, end := range []string{`"\2`, `\k<missing>`, `(`} {
+		cfg := Config{
+			Languages: map[string]Language{
+				"test": {Regions: []Region{{Name: "broken", Begin: `r(#*)"`, End: end}}},
+			},
+		}
+		if _, err := NewHighlighter(cfg, "test", LF, Options{}); err == nil {
+			t.Errorf("NewHighlighter() expected error for end pattern %q", end)
+		}
+	}
+}
+
+func TestExpandReferences(t *testing.T) {
+	begin := regexp.MustCompile(`(a)(?P<b>b)?`)
+	group := func(index int) string { return fmt.Sprintf("<%d>", index) }
+
+	tests := []struct {
+		pattern string
+		want    string
+		found   bool
+	}{
+		{`x\1y`, "x<1>y", true},
+		{`\k<b>\2`, "<2><2>", true},
+		{`\\1\d`, `\\1\d`, false},
+		{`\k`, `\k`, false},
+		{`end\`, `end\`, false},
+	}
+
+	for _, tt := range tests {
+		got, found, err := expandReferences(tt.pattern, begin, group)
+		if err != nil || got != tt.want || found != tt.found {
+			t.Errorf("expandReferences(%q) = %q, %v, %v, want %q, %v", tt.pattern, got, found, err, tt.want, tt.found)
+		}
+	}
+}
+
+// newEmbeddingHighlighter creates a highlighter for a Markdown-like language
+// whose fenced blocks are highlighted with the language named after the fence
+func newEmbeddingHighlighter(t *testing.T) *Highlighter {
+	t.Helper()
+
+	cfg := Config{
+		Languages: map[string]Language{
+			"markdown": {
+				Rules: []HighlightRule{
+					{Name: "bold", Pattern: `\*\*[^*]+\*\*`, Style: "bold"},
+				},
+				Regions: []Region{
+					{Name: "fences", Begin: "^```(\\w*)$", End: "^```$", Style: "code", LanguageCapture: "1"},
+					{Name: "scripts", Begin: `<script>`, End: `</script>`, Style: "tag", Language: "go"},
+				},
+				Styles: map[string]string{
+					"bold": "bold",
+					"code": "magenta",
+					"tag":  "blue",
+				},
+			},
+			"go": {
+				Extensions: []string{"go", "golang"},
+				Rules: []HighlightRule{
+					{Name: "keywords", Pattern: `\b(func|return)\b`, Style: "keyword"},
+				},
+				Regions: []Region{
+					{Name: "block_comments", Begin: `/\*`, End: `\*/`, Style: "comment"},
+				},
+				Styles: map[string]string{
+					"keyword": "cyan",
+					"comment": "yellow",
+				},
+			},
+		},
+	}
+
+	h, err := NewHighlighter(cfg, "markdown", LF, Options{})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+	return h
+}
+
+func TestEmbeddedLanguageFromCapture(t *testing.T) {
+	h := newEmbeddingHighlighter(t)
+
+	output := h.ProcessContent([]byte("```golang\nfunc /* a\n**b** */\n```\n**c**\n"))
+	lines := strings.Split(output, LF)
+
+	want := []string{
+		Magenta + "```golang" + Reset,
+		Cyan + "func" + Reset + " " + Yellow + "/*" + Reset + Yellow + " a" + Reset,
+		Yellow + "**b** " + Reset + Yellow + "*/" + Reset,
+		Magenta + "```" + Reset,
+		Bold + "**c**" + Reset,
+	}
+	for i, line := range want {
+		if lines[i] != line {
+			t.Errorf("line %d = %q, want %q", i+1, lines[i], line)
+		}
+	}
+}
+
+func TestEmbeddedLanguageByName(t *testing.T) {
+	h := newEmbeddingHighlighter(t)
+
+	got := h.highlightLine("**a** <script>return</script> **b**")
+	want := Bold + "**a**" + Reset + " " + Blue + "<script>" + Reset + Cyan + "return" + Reset +
+		Blue + "</script>" + Reset + " " + Bold + "**b**" + Reset
+	if got != want {
+		t.Errorf("highlightLine() = %q, want %q", got, want)
+	}
+}
+
+func TestEmbeddedLanguageUnknown(t *testing.T) {
+	h := newEmbeddingHighlighter(t)
+
+	// A fence with an unknown language is styled like a region without a language
+	output := h.ProcessContent([]byte("```cobol\nfunc\n```\n"))
+	lines := strings.Split(output, LF)
+	if want := Magenta + "func" + Reset; lines[1] != want {
+		t.Errorf("line 2 = %q, want %q", lines[1], want)
+	}
+}
+
+func TestEmbeddedLanguageTokens(t *testing.T) {
+	h := newEmbeddingHighlighter(t)
+
+	// Tokens inside an embedded language carry its name, so its styles apply
+	got := h.Tokenize([]byte("<script>return</script>\n"))
+	want := []Token{
+		{Start: 0, End: 8, Line: 1, Rule: "scripts", Style: "tag", Language: "markdown"},
+		{Start: 8, End: 14, Line: 1, Rule: "keywords", Style: "keyword", Language: "go"},
+		{Start: 14, End: 23, Line: 1, Rule: "scripts", Style: "tag", Language: "markdown"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize() = %+v, want %+v", got, want)
+	}
+}
+
+func TestShippedStyleRegion(t *testing.T) {
+	h, err := NewHighlighter(loadShippedConfig(t), "xml", LF, Options{})
+	if err != nil {
+		t.Fatalf("NewHighlighter() error = %v", err)
+	}
+
+	// The rules of css apply inside the style block, and those of xml after it
+	src := "<style>\n  a:hover { color: #fff; }\n</style>\n<p>\n"
+	var got []string
+	for _, token := range h.Tokenize([]byte(src)) {
+		if token.Style != "" {
+			got = append(got, src[token.Start:token.End]+":"+token.Style+"@"+token.Language)
+		}
+	}
+	want := []string{
+		"<style>:tag@xml", ":hover:keyword@css", "color:property@css", "#fff:constant@css", "</style>:tag@xml", "<p>:tag@xml",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,74 @@
+package highlighter
+
+// Built-in renderers a language can select
+const (
+	RendererMarkdown = "markdown"
+)
+
+// Renderer lays out the whole source of a language for reading rather than
+// editing, such as Markdown with its markup applied, wrapping paragraphs at
+// width characters when width is positive. It returns the rendered text and
+// a lexer that styles it.
+type Renderer func(cfg Config, lang string, src []byte, width int) ([]byte, Lexer)
+
+// renderers holds the renderers languages can select by name
+var renderers = map[string]Renderer{
+	RendererMarkdown: renderMarkdown,
+}
+
+// RegisterRenderer makes a renderer available to the languages that select it
+// by name. It is meant to be called during initialization.
+func RegisterRenderer(name string, render Renderer) {
+	renderers[name] = render
+}
+
+// Render renders src with the renderer the language lang selects. It returns
+// src and a nil lexer for languages without a known renderer, which are
+// highlighted as they are.
+func Render(cfg Config, lang string, src []byte, width int) ([]byte, Lexer) {
+	render, ok := renderers[cfg.Languages[lang].Render]
+	if !ok {
+		return src, nil
+	}
+	return render(cfg, lang, src, width)
+}
+
+// renderedLine is a line of rendered text with its tokens
+type renderedLine struct {
+	text   string
+	tokens []Token
+}
+
+// renderedLexer returns the tokens of rendered lines in turn, since a renderer
+// knows the styles of the text it lays out
+type renderedLexer struct {
+	lines [][]Token
+	next  int
+}
+
+// newRenderedLexer returns a lexer for rendered lines, and their text
+func newRenderedLexer(lines []renderedLine) ([]byte, *renderedLexer) {
+	var text []byte
+	lexer := &renderedLexer{lines: make([][]Token, len(lines))}
+	for i, line := range lines {
+		text = append(text, line.text...)
+		text = append(text, '\n')
+		lexer.lines[i] = line.tokens
+	}
+	return text, lexer
+}
+
+// Tokenize returns the tokens of the next rendered line
+func (l *renderedLexer) Tokenize(line string) []Token {
+	if l.next >= len(l.lines) {
+		return nil
+	}
+	tokens := l.lines[l.next]
+	l.next++
+	return tokens
+}
+
+// Reset starts again from the first rendered line
+func (l *renderedLexer) Reset() {
+	l.next = 0
+}
@@ -0,0 +1,254 @@
+package highlighter
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/AmirMahdyJebreily/hili-cat/pkg/diff"
+)
+
+// Layout of the views of SideBySide
+const (
+	sideSeparator = " │ "
+	sideTabWidth  = 4
+	sideMinWidth  = 4
+)
+
+// SideBySide compares two versions of a source in the language lang line by
+// line, and lays them out side by side with their line numbers, the old
+// version on the left, both highlighted in the language. Removed lines are
+// tinted by diff.removed and added lines by diff.added across the width of
+// their side. A run of removed lines followed by added lines is paired first
+// with first, and the words that changed between them are also styled as
+// diff.removed.word and diff.added.word. When width is positive each side is
+// cut to fit half of it, with an ellipsis; otherwise the sides are as wide as
+// the longest line. Tabs are expanded to spaces so the sides stay aligned.
+// It returns the view and a lexer that styles it.
+func SideBySide(cfg Config, lang, oldName string, oldSrc []byte, newName string, newSrc []byte, width int) ([]byte, Lexer, error) {
+	oldLines, oldCode, err := sideLines(cfg, lang, oldSrc)
+	if err != nil {
+		return nil, nil, err
+	}
+	newLines, newCode, err := sideLines(cfg, lang, newSrc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	view := sideView{language: lang, numbers: len(strconv.Itoa(max(len(oldLines), len(newLines))))}
+	if width > 0 {
+		view.width = (width-utf8.RuneCountInString(sideSeparator))/2 - view.numbers - 1
+	} else {
+		for _, line := range append(append([]string{oldName, newName}, oldLines...), newLines...) {
+			view.width = max(view.width, utf8.RuneCountInString(line))
+		}
+	}
+	view.width = max(view.width, sideMinWidth)
+
+	lines := []renderedLine{view.header(oldName, newName)}
+	for _, row := range sideRows(diff.Diff(oldLines, newLines)) {
+		var left, right sideCell
+		if row.old >= 0 {
+			left = sideCell{number: row.old + 1, text: oldLines[row.old], code: oldCode[row.old]}
+		}
+		if row.new >= 0 {
+			right = sideCell{number: row.new + 1, text: newLines[row.new], code: newCode[row.new]}
+		}
+		if row.changed {
+			left.kind, right.kind = "removed", "added"
+			if row.old >= 0 && row.new >= 0 {
+				left.words, right.words = wordChanges(left.text, right.text)
+			}
+		}
+		lines = append(lines, view.row(left, right))
+	}
+
+	text, lexer := newRenderedLexer(lines)
+	return text, lexer, nil
+}
+
+// sideLines splits a source into lines with tabs expanded, and tokenizes them
+// in the language lang
+func sideLines(cfg Config, lang string, src []byte) ([]string, [][]Token, error) {
+	lexer, err := newLexer(cfg, lang)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lines := sourceLines(src)
+	code := make([][]Token, len(lines))
+	for i, line := range lines {
+		lines[i] = expandTabs(line)
+		code[i] = lexer.Tokenize(lines[i])
+	}
+	return lines, code, nil
+}
+
+// expandTabs replaces the tabs of a line with spaces up to the next tab stop
+func expandTabs(line string) string {
+	if !strings.Contains(line, "\t") {
+		return line
+	}
+	var b strings.Builder
+	column := 0
+	for _, r := range line {
+		if r == '\t' {
+			n := sideTabWidth - column%sideTabWidth
+			b.WriteString(strings.Repeat(" ", n))
+			column += n
+			continue
+		}
+		b.WriteRune(r)
+		column++
+	}
+	return b.String()
+}
+
+// sideRow is a row of a side-by-side view, with the indexes of its old and new
+// lines, or -1 for a side left blank
+type sideRow struct {
+	old     int
+	new     int
+	changed bool
+}
+
+// sideRows lines the edits of a diff up into rows. Deleted lines share their
+// rows with the lines inserted right after them, first with first.
+func sideRows(edits []diff.Edit) []sideRow {
+	var rows []sideRow
+	for i := 0; i < len(edits); i++ {
+		edit := edits[i]
+		if edit.Op == diff.Equal {
+			for n := 0; n < edit.Len; n++ {
+				rows = append(rows, sideRow{old: edit.A + n, new: edit.B + n})
+			}
+			continue
+		}
+
+		var deleted, inserted diff.Edit
+		if edit.Op == diff.Delete {
+			deleted = edit
+			if i+1 < len(edits) && edits[i+1].Op == diff.Insert {
+				inserted = edits[i+1]
+				i++
+			}
+		} else {
+			inserted = edit
+		}
+		for n := 0; n < max(deleted.Len, inserted.Len); n++ {
+			row := sideRow{old: -1, new: -1, changed: true}
+			if n < deleted.Len {
+				row.old = deleted.A + n
+			}
+			if n < inserted.Len {
+				row.new = inserted.B + n
+			}
+			rows = append(rows, row)
+		}
+	}
+	return rows
+}
+
+// sideCell is one side of a row: a line with its number, code tokens and
+// changed words, and the kind of change tinting it, or a blank side when its
+// number is 0
+type sideCell struct {
+	number int
+	text   string
+	code   []Token
+	words  []wordRange
+	kind   string
+}
+
+// sideView lays out the rows of a side-by-side view, with line numbers of
+// numbers digits and lines of width characters
+type sideView struct {
+	language string
+	numbers  int
+	width    int
+}
+
+// header returns the row naming the two versions
+func (v sideView) header(oldName, newName string) renderedLine {
+	var b strings.Builder
+	var tokens []Token
+	for i, name := range []string{oldName, newName} {
+		if i > 0 {
+			tokens = append(tokens, Token{Start: b.Len(), End: b.Len() + len(sideSeparator), Rule: "separator", Style: "punctuation", Language: v.language})
+			b.WriteString(sideSeparator)
+		}
+		side := v.numbers + 1 + v.width
+		n := utf8.RuneCountInString(name)
+		if n > side {
+			name = truncateRunes(name, side-1) + tableEllipsis
+			n = side
+		}
+		tokens = append(tokens, Token{Start: b.Len(), End: b.Len() + len(name), Rule: "header", Style: "diff.header", Language: v.language})
+		b.WriteString(name)
+		if i == 0 {
+			b.WriteString(strings.Repeat(" ", side-n))
+		}
+	}
+	return renderedLine{text: b.String(), tokens: tokens}
+}
+
+// row returns a row with the old line on the left and the new one on the right
+func (v sideView) row(left, right sideCell) renderedLine {
+	var b strings.Builder
+	tokens := v.cell(&b, left, true)
+	separator := sideSeparator
+	if right.number == 0 {
+		separator = strings.TrimRight(separator, " ")
+	}
+	tokens = append(tokens, Token{Start: b.Len(), End: b.Len() + len(separator), Rule: "separator", Style: "punctuation", Language: v.language})
+	b.WriteString(separator)
+	tokens = append(tokens, v.cell(&b, right, false)...)
+	return renderedLine{text: b.String(), tokens: tokens}
+}
+
+// cell writes one side of a row to b and returns its tokens. The line is cut
+// to the width of the view, and padded to it if pad is set or the line is
+// tinted, so the tint reaches the edge of its side.
+func (v sideView) cell(b *strings.Builder, cell sideCell, pad bool) []Token {
+	if cell.number == 0 {
+		if pad {
+			b.WriteString(strings.Repeat(" ", v.numbers+1+v.width))
+		}
+		return nil
+	}
+
+	number := strconv.Itoa(cell.number)
+	b.WriteString(strings.Repeat(" ", v.numbers-len(number)))
+	tokens := []Token{{Start: b.Len(), End: b.Len() + len(number), Rule: "number", Style: "diff.number", Language: v.language}}
+	b.WriteString(number)
+	b.WriteByte(' ')
+
+	code := cell.code
+	if cell.kind != "" {
+		code = tintTokens(v.language, len(cell.text), code, cell.words, cell.kind)
+	}
+	cut, n := len(cell.text), utf8.RuneCountInString(cell.text)
+	if n > v.width {
+		cut, n = len(truncateRunes(cell.text, v.width-1)), v.width
+	}
+	start := b.Len()
+	for _, token := range code {
+		token.Start, token.End = start+token.Start, start+min(token.End, cut)
+		if token.Start < token.End {
+			tokens = append(tokens, token)
+		}
+	}
+	b.WriteString(cell.text[:cut])
+
+	end := b.Len()
+	if cut < len(cell.text) {
+		b.WriteString(tableEllipsis)
+	}
+	if pad || cell.kind != "" {
+		b.WriteString(strings.Repeat(" ", v.width-n))
+	}
+	if cell.kind != "" && b.Len() > end {
+		tokens = append(tokens, Token{Start: end, End: b.Len(), Rule: cell.kind, Style: "diff." + cell.kind, Language: v.language})
+	}
+	return tokens
+}
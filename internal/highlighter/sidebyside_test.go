@@ -0,0 +1,77 @@
+package highlighter
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSideBySide(t *testing.T) {
+	cfg := Config{Languages: map[string]Language{"text": {}}}
+
+	tests := []struct {
+		name  string
+		old   string
+		new   string
+		width int
+		want  string
+	}{
+		{
+			name: "changed, removed and added lines",
+			old:  "a\nb\nc\n",
+			new:  "a\nB\nc\nd\n",
+			want: "old    │ new\n" +
+				"1 a    │ 1 a\n" +
+				"2 b    │ 2 B   \n" +
+				"3 c    │ 3 c\n" +
+				"       │ 4 d   \n",
+		},
+		{
+			name:  "cut to width",
+			old:   "short\n",
+			new:   "\tlonger line\n",
+			width: 23,
+			want: "old        │ new\n" +
+				"1 short    │ 1     lon…\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, lexer, err := SideBySide(cfg, "text", "old", []byte(tt.old), "new", []byte(tt.new), tt.width)
+			if err != nil {
+				t.Fatalf("SideBySide() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("SideBySide() = %q, want %q", got, tt.want)
+			}
+			if lexer == nil {
+				t.Error("SideBySide() returned no lexer")
+			}
+		})
+	}
+}
+
+func TestSideBySideStyles(t *testing.T) {
+	cfg := Config{Languages: map[string]Language{"go": {Extensions: []string{"go"}, Lexer: LexerGo}}}
+	text, lexer, err := SideBySide(cfg, "go", "a.go", []byte("x := nil\n"), "b.go", []byte("y := nil\n"), 0)
+	if err != nil {
+		t.Fatalf("SideBySide() error = %v", err)
+	}
+
+	want := []string{
+		"a.go:diff.header", " │ :punctuation", "b.go:diff.header",
+		"1:diff.number", "x:diff.removed+diff.removed.word", " := :diff.removed", "nil:diff.removed+constant",
+		" │ :punctuation",
+		"1:diff.number", "y:diff.added+diff.added.word", " := :diff.added", "nil:diff.added+constant",
+	}
+	if got := renderedPieces(text, lexer); !reflect.DeepEqual(got, want) {
+		t.Errorf("Tokenize() = %q, want %q", got, want)
+	}
+}
+
+func TestSideBySideUnknownLanguage(t *testing.T) {
+	if _, _, err := SideBySide(Config{}, "cobol", "a", nil, "b", nil, 0); err == nil || !strings.Contains(err.Error(), "cobol") {
+		t.Errorf("SideBySide() error = %v, want an error naming the language", err)
+	}
+}
@@ -1,6 +1,7 @@
 package io
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
@@ -57,19 +58,31 @@ func (r *Reader) ProcessFile(filePath string, dataCh chan<- []byte, wg *sync.Wai
 		reader = file
 	}
 
+	r.Process(reader, dataCh)
+}
+
+// Process reads from reader until its end and sends the data to the channel,
+// in chunks of whole lines
+func (r *Reader) Process(reader io.Reader, dataCh chan<- []byte) {
 	// Use buffered reader for performance
 	buf := make([]byte, r.bufSize)
 
+	// Only whole lines are sent, the rest is carried over to the next read
+	var lines LineBuffer
+
 	for {
 		n, err := reader.Read(buf)
 		if n > 0 {
-			// Create a copy of the buffer to avoid race conditions
-			data := make([]byte, n)
-			copy(data, buf[:n])
-			dataCh <- data
+			if data := lines.Write(buf[:n]); data != nil {
+				dataCh <- data
+			}
 		}
 
 		if err == io.EOF {
+			// Send the last line even if it has no line ending
+			if data := lines.Flush(); data != nil {
+				dataCh <- data
+			}
 			break
 		}
 
@@ -80,6 +93,41 @@ func (r *Reader) ProcessFile(filePath string, dataCh chan<- []byte, wg *sync.Wai
 	}
 }
 
+// LineBuffer reassembles a stream of chunks into chunks that end on a line boundary
+type LineBuffer struct {
+	carry []byte
+}
+
+// Write adds a chunk to the buffer and returns all complete lines buffered so far,
+// or nil if no line is complete yet. The returned slice is a copy and safe to send
+// to another goroutine. Lines have no length limit.
+func (b *LineBuffer) Write(chunk []byte) []byte {
+	end := bytes.LastIndexByte(chunk, '\n')
+	if end < 0 {
+		b.carry = append(b.carry, chunk...)
+		return nil
+	}
+
+	data := make([]byte, 0, len(b.carry)+end+1)
+	data = append(data, b.carry...)
+	data = append(data, chunk[:end+1]...)
+
+	// Keep the partial line after the last line ending for the next chunk
+	b.carry = append(b.carry[:0], chunk[end+1:]...)
+	return data
+}
+
+// Flush returns the remaining partial line and empties the buffer,
+// or nil if nothing is buffered
+func (b *LineBuffer) Flush() []byte {
+	if len(b.carry) == 0 {
+		return nil
+	}
+	data := b.carry
+	b.carry = nil
+	return data
+}
+
 // DetectLineEnding examines a byte slice to determine the line ending
 func DetectLineEnding(data []byte) string {
 	const (
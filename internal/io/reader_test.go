@@ -1,6 +1,9 @@
 package io
 
 import (
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -42,5 +45,59 @@ func TestDetectLineEnding(t *testing.T) {
 	}
 }
 
-// Note: Testing OpenFile and ProcessFile requires more sophisticated setup
+func TestLineBuffer(t *testing.T) {
+	var b LineBuffer
+
+	if data := b.Write([]byte("par")); data != nil {
+		t.Errorf("Write() without line ending = %q, want nil", data)
+	}
+	if data := b.Write([]byte("tial\nnext")); string(data) != "partial\n" {
+		t.Errorf("Write() = %q, want %q", data, "partial\n")
+	}
+	if data := b.Write([]byte(" line\r\nlast")); string(data) != "next line\r\n" {
+		t.Errorf("Write() = %q, want %q", data, "next line\r\n")
+	}
+	if data := b.Flush(); string(data) != "last" {
+		t.Errorf("Flush() = %q, want %q", data, "last")
+	}
+	if data := b.Flush(); data != nil {
+		t.Errorf("Flush() on empty buffer = %q, want nil", data)
+	}
+}
+
+func TestProcessFileWholeLines(t *testing.T) {
+	tmpDir := t.TempDir()
+	path := filepath.Join(tmpDir, "input.txt")
+
+	// The long line is far bigger than the read buffer
+	content := "short\n" + strings.Repeat("x", 100) + "\nend"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+
+	dataCh := make(chan []byte, 100)
+	NewReader(7).ProcessFile(path, dataCh, nil)
+	close(dataCh)
+
+	var got strings.Builder
+	var chunks [][]byte
+	for data := range dataCh {
+		chunks = append(chunks, data)
+		got.Write(data)
+	}
+
+	if got.String() != content {
+		t.Errorf("ProcessFile() content = %q, want %q", got.String(), content)
+	}
+	for _, data := range chunks[:len(chunks)-1] {
+		if data[len(data)-1] != '\n' {
+			t.Errorf("ProcessFile() sent a chunk that does not end a line: %q", data)
+		}
+	}
+	if last := string(chunks[len(chunks)-1]); last != "end" {
+		t.Errorf("ProcessFile() last chunk = %q, want %q", last, "end")
+	}
+}
+
+// Note: Testing OpenFile and ProcessFile on stdin requires more sophisticated setup
 // with mock syscalls, which we'd implement in a production environment
@@ -0,0 +1,28 @@
+package io
+
+import (
+	"os"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// winsize is the terminal size filled in by the TIOCGWINSZ ioctl
+type winsize struct {
+	rows, cols, xpixel, ypixel uint16
+}
+
+// TerminalWidth returns the width in characters of the terminal file is
+// attached to, or else the width in the COLUMNS environment variable, or else
+// 0 when the width is unknown, such as when the output is piped
+func TerminalWidth(file *os.File) int {
+	var ws winsize
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, file.Fd(), uintptr(syscall.TIOCGWINSZ), uintptr(unsafe.Pointer(&ws)))
+	if errno == 0 && ws.cols > 0 {
+		return int(ws.cols)
+	}
+	if columns, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && columns > 0 {
+		return columns
+	}
+	return 0
+}
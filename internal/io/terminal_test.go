@@ -0,0 +1,32 @@
+package io
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTerminalWidth(t *testing.T) {
+	// A regular file is not a terminal, so the width comes from COLUMNS
+	file, err := os.Create(filepath.Join(t.TempDir(), "out"))
+	if err != nil {
+		t.Fatalf("Failed to create file: %v", err)
+	}
+	defer file.Close()
+
+	tests := []struct {
+		columns string
+		want    int
+	}{
+		{"120", 120},
+		{"", 0},
+		{"wide", 0},
+	}
+
+	for _, tt := range tests {
+		t.Setenv("COLUMNS", tt.columns)
+		if got := TerminalWidth(file); got != tt.want {
+			t.Errorf("TerminalWidth() with COLUMNS=%q = %d, want %d", tt.columns, got, tt.want)
+		}
+	}
+}
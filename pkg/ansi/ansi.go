@@ -4,10 +4,14 @@ package ansi
 
 // Color codes
 const (
-	Reset     = "\033[0m"
-	Bold      = "\033[1m"
-	Italic    = "\033[3m"
-	Underline = "\033[4m"
+	Reset         = "\033[0m"
+	Bold          = "\033[1m"
+	Dim           = "\033[2m"
+	Italic        = "\033[3m"
+	Underline     = "\033[4m"
+	Blink         = "\033[5m"
+	Reverse       = "\033[7m"
+	Strikethrough = "\033[9m"
 
 	Black   = "\033[30m"
 	Red     = "\033[31m"
@@ -26,41 +30,25 @@ const (
 	BrightMagenta = "\033[95m"
 	BrightCyan    = "\033[96m"
 	BrightWhite   = "\033[97m"
-)
-
-// StyleMap maps style names to their ANSI escape codes
-var StyleMap = map[string]string{
-	"reset":     Reset,
-	"bold":      Bold,
-	"italic":    Italic,
-	"underline": Underline,
-
-	"black":   Black,
-	"red":     Red,
-	"green":   Green,
-	"yellow":  Yellow,
-	"blue":    Blue,
-	"magenta": Magenta,
-	"cyan":    Cyan,
-	"white":   White,
 
-	"brightblack":   BrightBlack,
-	"brightred":     BrightRed,
-	"brightgreen":   BrightGreen,
-	"brightyellow":  BrightYellow,
-	"brightblue":    BrightBlue,
-	"brightmagenta": BrightMagenta,
-	"brightcyan":    BrightCyan,
-	"brightwhite":   BrightWhite,
-}
+	BgBlack   = "\033[40m"
+	BgRed     = "\033[41m"
+	BgGreen   = "\033[42m"
+	BgYellow  = "\033[43m"
+	BgBlue    = "\033[44m"
+	BgMagenta = "\033[45m"
+	BgCyan    = "\033[46m"
+	BgWhite   = "\033[47m"
+)
 
-// GetStyleCode returns the ANSI escape code for the specified style
-// If the style is not found, it returns the Reset code
+// GetStyleCode returns the ANSI escape code for the specified style spec, as
+// parsed by ParseStyle. If the spec is invalid or empty, it returns the Reset code
 func GetStyleCode(style string) string {
-	if code, ok := StyleMap[style]; ok {
-		return code
+	parsed, err := ParseStyle(style)
+	if err != nil || parsed.IsZero() {
+		return Reset
 	}
-	return Reset
+	return parsed.Code(ColorsTrue)
 }
 
 // Colorize applies the specified style to the text and adds a reset code at the end
@@ -14,6 +14,7 @@ func TestGetStyleCode(t *testing.T) {
 		{"Valid style", "red", Red},
 		{"Valid bold style", "bold", Bold},
 		{"Valid bright style", "brightgreen", BrightGreen},
+		{"Compound style", "bold underline brightred on_blue", "\033[1;4;91;44m"},
 		{"Invalid style", "nonexistent", Reset},
 		{"Empty style", "", Reset},
 	}
@@ -53,65 +54,50 @@ func TestColorize(t *testing.T) {
 	}
 }
 
-func TestStyleMapCompleteness(t *testing.T) {
-	// Check that all constants have corresponding entries in the StyleMap
-	expectedStyles := []struct {
-		name string
-		code string
-	}{
-		{"reset", Reset},
-		{"bold", Bold},
-		{"italic", Italic},
-		{"underline", Underline},
-		{"black", Black},
-		{"red", Red},
-		{"green", Green},
-		{"yellow", Yellow},
-		{"blue", Blue},
-		{"magenta", Magenta},
-		{"cyan", Cyan},
-		{"white", White},
-		{"brightblack", BrightBlack},
-		{"brightred", BrightRed},
-		{"brightgreen", BrightGreen},
-		{"brightyellow", BrightYellow},
-		{"brightblue", BrightBlue},
-		{"brightmagenta", BrightMagenta},
-		{"brightcyan", BrightCyan},
-		{"brightwhite", BrightWhite},
-	}
-
-	for _, style := range expectedStyles {
-		code, ok := StyleMap[style.name]
-		if !ok {
-			t.Errorf("StyleMap is missing entry for %q", style.name)
-			continue
-		}
-		if code != style.code {
-			t.Errorf("StyleMap[%q] = %q, want %q", style.name, code, style.code)
-		}
-	}
+// namedStyles are the style names ParseStyle knows, with the codes of their constants
+var namedStyles = []struct {
+	name string
+	code string
+}{
+	{"bold", Bold},
+	{"dim", Dim},
+	{"italic", Italic},
+	{"underline", Underline},
+	{"blink", Blink},
+	{"reverse", Reverse},
+	{"strikethrough", Strikethrough},
+	{"black", Black},
+	{"red", Red},
+	{"green", Green},
+	{"yellow", Yellow},
+	{"blue", Blue},
+	{"magenta", Magenta},
+	{"cyan", Cyan},
+	{"white", White},
+	{"brightblack", BrightBlack},
+	{"brightred", BrightRed},
+	{"brightgreen", BrightGreen},
+	{"brightyellow", BrightYellow},
+	{"brightblue", BrightBlue},
+	{"brightmagenta", BrightMagenta},
+	{"brightcyan", BrightCyan},
+	{"brightwhite", BrightWhite},
+}
 
-	// Check for any extra entries in StyleMap
-	for name := range StyleMap {
-		found := false
-		for _, style := range expectedStyles {
-			if name == style.name {
-				found = true
-				break
-			}
-		}
-		if !found {
-			t.Errorf("StyleMap has unexpected entry %q", name)
+func TestStyleNames(t *testing.T) {
+	// Every constant is what its name parses to
+	for _, style := range namedStyles {
+		if code := GetStyleCode(style.name); code != style.code {
+			t.Errorf("GetStyleCode(%q) = %q, want %q", style.name, code, style.code)
 		}
 	}
 }
 
 func TestCodeValidity(t *testing.T) {
 	// Ensure all ANSI codes follow the correct format
-	for name, code := range StyleMap {
-		if !strings.HasPrefix(code, "\033[") || !strings.HasSuffix(code, "m") {
-			t.Errorf("ANSI code for %q doesn't follow expected format: %q", name, code)
+	for _, style := range namedStyles {
+		if code := GetStyleCode(style.name); !strings.HasPrefix(code, "\033[") || !strings.HasSuffix(code, "m") {
+			t.Errorf("ANSI code for %q doesn't follow expected format: %q", style.name, code)
 		}
 	}
 }
@@ -0,0 +1,256 @@
+package ansi
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ColorDepth is the number of colors a terminal can show.
+// Colors beyond the depth are replaced by the nearest color it can show.
+type ColorDepth int
+
+// Color depths, from full 24-bit color down to the 16 basic colors
+const (
+	ColorsTrue ColorDepth = iota
+	Colors256
+	Colors16
+)
+
+// colorKind tells how a color is given
+type colorKind int
+
+const (
+	colorBasic colorKind = iota
+	colorIndexed
+	colorRGB
+)
+
+// Color is one of the 16 basic colors, a 256-color palette index or an RGB value
+type Color struct {
+	kind    colorKind
+	index   int
+	r, g, b int
+}
+
+// basicColors maps the names of the 16 basic colors to their palette indexes
+var basicColors = map[string]int{
+	"black":         0,
+	"red":           1,
+	"green":         2,
+	"yellow":        3,
+	"blue":          4,
+	"magenta":       5,
+	"cyan":          6,
+	"white":         7,
+	"brightblack":   8,
+	"brightred":     9,
+	"brightgreen":   10,
+	"brightyellow":  11,
+	"brightblue":    12,
+	"brightmagenta": 13,
+	"brightcyan":    14,
+	"brightwhite":   15,
+}
+
+// basicRGB holds the RGB values of the 16 basic colors, as in the xterm defaults
+var basicRGB = [16][3]int{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0},
+	{0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0},
+	{92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// minChroma is the least difference between the channels of an RGB value for it
+// to have a hue when it is reduced to the basic colors
+const minChroma = 32
+
+// cubeLevels are the channel values of the 6x6x6 color cube of the 256-color palette
+var cubeLevels = [6]int{0, 95, 135, 175, 215, 255}
+
+// ParseColor parses a color name, a #RRGGBB value, an rgb(r,g,b) value or a
+// 256-color palette index
+func ParseColor(spec string) (Color, error) {
+	if index, ok := basicColors[spec]; ok {
+		return Color{kind: colorBasic, index: index}, nil
+	}
+
+	switch {
+	case strings.HasPrefix(spec, "#"):
+		if len(spec) != 7 {
+			return Color{}, fmt.Errorf("invalid color %q: want #RRGGBB", spec)
+		}
+		value, err := strconv.ParseUint(spec[1:], 16, 32)
+		if err != nil {
+			return Color{}, fmt.Errorf("invalid color %q: want #RRGGBB", spec)
+		}
+		return Color{kind: colorRGB, r: int(value >> 16), g: int(value >> 8 & 0xff), b: int(value & 0xff)}, nil
+
+	case strings.HasPrefix(spec, "rgb(") && strings.HasSuffix(spec, ")"):
+		parts := strings.Split(spec[len("rgb("):len(spec)-1], ",")
+		if len(parts) != 3 {
+			return Color{}, fmt.Errorf("invalid color %q: want rgb(r,g,b)", spec)
+		}
+		var channels [3]int
+		for i, part := range parts {
+			value, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || value < 0 || value > 255 {
+				return Color{}, fmt.Errorf("invalid color %q: channels must be 0 to 255", spec)
+			}
+			channels[i] = value
+		}
+		return Color{kind: colorRGB, r: channels[0], g: channels[1], b: channels[2]}, nil
+	}
+
+	index, err := strconv.Atoi(spec)
+	if err != nil {
+		return Color{}, fmt.Errorf("unknown color %q", spec)
+	}
+	if index < 0 || index > 255 {
+		return Color{}, fmt.Errorf("invalid color %q: palette indexes are 0 to 255", spec)
+	}
+	return Color{kind: colorIndexed, index: index}, nil
+}
+
+// RGB returns the RGB value of a color
+func (c Color) RGB() (int, int, int) {
+	switch {
+	case c.kind == colorRGB:
+		return c.r, c.g, c.b
+	case c.index < 16:
+		return basicRGB[c.index][0], basicRGB[c.index][1], basicRGB[c.index][2]
+	case c.index < 232:
+		i := c.index - 16
+		return cubeLevels[i/36], cubeLevels[i/6%6], cubeLevels[i%6]
+	default:
+		level := 8 + 10*(c.index-232)
+		return level, level, level
+	}
+}
+
+// Downsample returns the nearest color that can be shown at depth
+func (c Color) Downsample(depth ColorDepth) Color {
+	switch {
+	case c.kind == colorBasic:
+		return c
+	case c.kind == colorIndexed && c.index < 16:
+		return Color{kind: colorBasic, index: c.index}
+	case depth == Colors16:
+		return Color{kind: colorBasic, index: nearestBasic(c.RGB())}
+	case depth == Colors256 && c.kind == colorRGB:
+		return Color{kind: colorIndexed, index: nearestIndexed(c.r, c.g, c.b)}
+	}
+	return c
+}
+
+// parameters returns the SGR parameters that set the color as foreground, or
+// as background if bg is set
+func (c Color) parameters(bg bool) string {
+	base := 38
+	if bg {
+		base = 48
+	}
+
+	switch c.kind {
+	case colorBasic:
+		if c.index < 8 {
+			return strconv.Itoa(base - 8 + c.index)
+		}
+		return strconv.Itoa(base + 52 + c.index - 8)
+	case colorIndexed:
+		return fmt.Sprintf("%d;5;%d", base, c.index)
+	default:
+		return fmt.Sprintf("%d;2;%d;%d;%d", base, c.r, c.g, c.b)
+	}
+}
+
+// nearestBasic returns the index of the basic color nearest to an RGB value.
+// Colors with a clear hue keep it, so a dark or pale tint whose nearest basic
+// color is black, white or gray takes the basic color of its hue instead.
+func nearestBasic(r, g, b int) int {
+	best, bestDistance := 0, -1
+	for i, rgb := range basicRGB {
+		if d := distance(r, g, b, rgb[0], rgb[1], rgb[2]); bestDistance < 0 || d < bestDistance {
+			best, bestDistance = i, d
+		}
+	}
+	if best%8 == 0 || best%8 == 7 {
+		if hue := nearestHue(r, g, b); hue >= 0 {
+			return hue
+		}
+	}
+	return best
+}
+
+// nearestHue returns the index of the basic color of the hue of an RGB value,
+// bright for light colors, or -1 for colors too close to gray to have one
+func nearestHue(r, g, b int) int {
+	high, low := max(r, g, b), min(r, g, b)
+	if high-low < minChroma {
+		return -1
+	}
+
+	// Hue in sixths of the color wheel, starting from red
+	chroma := float64(high - low)
+	var hue float64
+	switch high {
+	case r:
+		hue = float64(g-b) / chroma
+	case g:
+		hue = 2 + float64(b-r)/chroma
+	default:
+		hue = 4 + float64(r-g)/chroma
+	}
+	sector := (int(math.Round(hue)) + 6) % 6
+
+	index := []int{1, 3, 2, 6, 4, 5}[sector]
+	if high+low > 255 {
+		index += 8
+	}
+	return index
+}
+
+// nearestIndexed returns the 256-color palette index nearest to an RGB value,
+// choosing between the nearest color of the cube and the nearest gray
+func nearestIndexed(r, g, b int) int {
+	ri, gi, bi := nearestLevel(r), nearestLevel(g), nearestLevel(b)
+	cube := 16 + 36*ri + 6*gi + bi
+	cubeDistance := distance(r, g, b, cubeLevels[ri], cubeLevels[gi], cubeLevels[bi])
+
+	gray := 232 + ((r+g+b)/3-3)/10
+	if gray < 232 {
+		gray = 232
+	} else if gray > 255 {
+		gray = 255
+	}
+	level := 8 + 10*(gray-232)
+	if distance(r, g, b, level, level, level) < cubeDistance {
+		return gray
+	}
+	return cube
+}
+
+// nearestLevel returns the index of the cube level nearest to a channel value
+func nearestLevel(value int) int {
+	best := 0
+	for i, level := range cubeLevels {
+		if abs(value-level) < abs(value-cubeLevels[best]) {
+			best = i
+		}
+	}
+	return best
+}
+
+// distance returns the squared distance between two RGB values
+func distance(r1, g1, b1, r2, g2, b2 int) int {
+	return (r1-r2)*(r1-r2) + (g1-g2)*(g1-g2) + (b1-b2)*(b1-b2)
+}
+
+// abs returns the absolute value of an integer
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,82 @@
+package ansi
+
+import "testing"
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		spec string
+		want Color
+		ok   bool
+	}{
+		{"red", Color{kind: colorBasic, index: 1}, true},
+		{"brightcyan", Color{kind: colorBasic, index: 14}, true},
+		{"#ff8000", Color{kind: colorRGB, r: 255, g: 128, b: 0}, true},
+		{"#FF8000", Color{kind: colorRGB, r: 255, g: 128, b: 0}, true},
+		{"rgb(1, 2,3)", Color{kind: colorRGB, r: 1, g: 2, b: 3}, true},
+		{"208", Color{kind: colorIndexed, index: 208}, true},
+		{"#fff", Color{}, false},
+		{"#gg0000", Color{}, false},
+		{"rgb(1,2)", Color{}, false},
+		{"rgb(1,2,256)", Color{}, false},
+		{"256", Color{}, false},
+		{"-1", Color{}, false},
+		{"orange", Color{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := ParseColor(tt.spec)
+			if got != tt.want || (err == nil) != tt.ok {
+				t.Errorf("ParseColor(%q) = %+v, %v, want %+v, ok %v", tt.spec, got, err, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestColorDownsample(t *testing.T) {
+	tests := []struct {
+		style string
+		depth ColorDepth
+		want  string
+	}{
+		{"#ff8000", ColorsTrue, "\033[38;2;255;128;0m"},
+		{"on_rgb(0,0,255)", ColorsTrue, "\033[48;2;0;0;255m"},
+		{"208", ColorsTrue, "\033[38;5;208m"},
+		{"bg_208", ColorsTrue, "\033[48;5;208m"},
+		{"brightred", ColorsTrue, BrightRed},
+		{"on_brightred", ColorsTrue, "\033[101m"},
+		{"9", ColorsTrue, BrightRed},
+
+		// Truecolor values map onto the color cube or the gray ramp
+		{"#ff8700", Colors256, "\033[38;5;208m"},
+		{"#808080", Colors256, "\033[38;5;244m"},
+		{"on_#000000", Colors256, "\033[48;5;16m"},
+		{"208", Colors256, "\033[38;5;208m"},
+
+		// Everything maps onto the 16 basic colors
+		{"#ff0000", Colors16, BrightRed},
+		{"#0000c0", Colors16, Blue},
+		{"on_#ffffff", Colors16, "\033[107m"},
+		{"46", Colors16, BrightGreen},
+		{"cyan", Colors16, Cyan},
+
+		// Dark and pale tints keep their hue rather than turning black or white
+		{"on_#005f00", Colors16, "\033[42m"},
+		{"on_#5f0000", Colors16, "\033[41m"},
+		{"on_#d7ffd7", Colors16, "\033[102m"},
+		{"on_#ffd7d7", Colors16, "\033[101m"},
+		{"#303030", Colors16, Black},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.style, func(t *testing.T) {
+			style, err := ParseStyle(tt.style)
+			if err != nil {
+				t.Fatalf("ParseStyle(%q) error = %v", tt.style, err)
+			}
+			if got := style.Code(tt.depth); got != tt.want {
+				t.Errorf("Code(%v) of %q = %q, want %q", tt.depth, tt.style, got, tt.want)
+			}
+		})
+	}
+}
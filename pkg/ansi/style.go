@@ -0,0 +1,159 @@
+package ansi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Attribute is a set of text attributes
+type Attribute int
+
+// Text attributes a style can turn on
+const (
+	AttrBold Attribute = 1 << iota
+	AttrDim
+	AttrItalic
+	AttrUnderline
+	AttrBlink
+	AttrReverse
+	AttrStrikethrough
+)
+
+// attributeNames maps attribute names to attributes; reset turns nothing on
+var attributeNames = map[string]Attribute{
+	"reset":         0,
+	"bold":          AttrBold,
+	"dim":           AttrDim,
+	"italic":        AttrItalic,
+	"underline":     AttrUnderline,
+	"blink":         AttrBlink,
+	"reverse":       AttrReverse,
+	"strikethrough": AttrStrikethrough,
+}
+
+// attributeCodes holds the SGR parameter of each attribute, in output order
+var attributeCodes = []struct {
+	attribute Attribute
+	code      int
+}{
+	{AttrBold, 1},
+	{AttrDim, 2},
+	{AttrItalic, 3},
+	{AttrUnderline, 4},
+	{AttrBlink, 5},
+	{AttrReverse, 7},
+	{AttrStrikethrough, 9},
+}
+
+// Style is a parsed style spec: text attributes with optional foreground and
+// background colors
+type Style struct {
+	Attributes Attribute
+	Foreground *Color
+	Background *Color
+}
+
+// ParseStyle parses a style spec of space-separated words, such as
+// "bold underline brightred on_blue" or "dim italic #ff8800". A word is an
+// attribute name, a foreground color, or a background color prefixed with
+// on_ or bg_. Colors are any that ParseColor accepts.
+func ParseStyle(spec string) (Style, error) {
+	var style Style
+	for _, word := range splitSpec(spec) {
+		if attribute, ok := attributeNames[word]; ok {
+			style.Attributes |= attribute
+			continue
+		}
+
+		target, name := &style.Foreground, word
+		for _, prefix := range []string{"on_", "bg_"} {
+			if strings.HasPrefix(word, prefix) {
+				target, name = &style.Background, strings.TrimPrefix(word, prefix)
+			}
+		}
+
+		color, err := ParseColor(name)
+		if err != nil {
+			return Style{}, fmt.Errorf("unknown style %q in %q", word, spec)
+		}
+		if *target != nil {
+			return Style{}, fmt.Errorf("more than one color for the same layer in %q", spec)
+		}
+		*target = &color
+	}
+	return style, nil
+}
+
+// splitSpec splits a style spec at spaces outside parentheses, so that
+// rgb(r, g, b) stays one word
+func splitSpec(spec string) []string {
+	var words []string
+	depth, start := 0, -1
+	for i, r := range spec {
+		switch {
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case (r == ' ' || r == '\t') && depth <= 0:
+			if start >= 0 {
+				words = append(words, spec[start:i])
+				start = -1
+			}
+			continue
+		}
+		if start < 0 {
+			start = i
+		}
+	}
+	if start >= 0 {
+		words = append(words, spec[start:])
+	}
+	return words
+}
+
+// Has reports whether the style turns on all attributes of a
+func (s Style) Has(a Attribute) bool {
+	return s.Attributes&a == a
+}
+
+// IsZero reports whether the style changes nothing
+func (s Style) IsZero() bool {
+	return s.Attributes == 0 && s.Foreground == nil && s.Background == nil
+}
+
+// Layer returns the style with top laid over it: the attributes of both, and
+// the colors of top where it has them
+func (s Style) Layer(top Style) Style {
+	s.Attributes |= top.Attributes
+	if top.Foreground != nil {
+		s.Foreground = top.Foreground
+	}
+	if top.Background != nil {
+		s.Background = top.Background
+	}
+	return s
+}
+
+// Code returns the ANSI escape code that turns the style on, with its colors
+// downsampled to depth, or an empty string for a style that changes nothing
+func (s Style) Code(depth ColorDepth) string {
+	if s.IsZero() {
+		return ""
+	}
+
+	var parameters []string
+	for _, attribute := range attributeCodes {
+		if s.Has(attribute.attribute) {
+			parameters = append(parameters, strconv.Itoa(attribute.code))
+		}
+	}
+	if s.Foreground != nil {
+		parameters = append(parameters, s.Foreground.Downsample(depth).parameters(false))
+	}
+	if s.Background != nil {
+		parameters = append(parameters, s.Background.Downsample(depth).parameters(true))
+	}
+	return "\033[" + strings.Join(parameters, ";") + "m"
+}
@@ -0,0 +1,80 @@
+package ansi
+
+import "testing"
+
+func TestParseStyle(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"bold", Bold},
+		{"bold underline brightred on_blue", "\033[1;4;91;44m"},
+		{"dim italic #ff8800", "\033[2;3;38;2;255;136;0m"},
+		{"strikethrough reverse blink", "\033[5;7;9m"},
+		{"bg_yellow black", "\033[30;43m"},
+		{"  green   on_rgb(1, 2, 3) ", "\033[32;48;2;1;2;3m"},
+		{"reset", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			style, err := ParseStyle(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseStyle(%q) error = %v", tt.spec, err)
+			}
+			if got := style.Code(ColorsTrue); got != tt.want {
+				t.Errorf("ParseStyle(%q).Code() = %q, want %q", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseStyleErrors(t *testing.T) {
+	specs := []string{
+		"brightblu",
+		"bold sparkly",
+		"red blue",
+		"on_red bg_blue",
+		"on_#12",
+	}
+
+	for _, spec := range specs {
+		if _, err := ParseStyle(spec); err == nil {
+			t.Errorf("ParseStyle(%q) expected error", spec)
+		}
+	}
+}
+
+func TestStyleHas(t *testing.T) {
+	style, err := ParseStyle("bold italic")
+	if err != nil {
+		t.Fatalf("ParseStyle() error = %v", err)
+	}
+	if !style.Has(AttrBold) || !style.Has(AttrBold|AttrItalic) || style.Has(AttrDim) {
+		t.Errorf("Has() gives wrong results for %+v", style)
+	}
+}
+
+func TestStyleLayer(t *testing.T) {
+	tests := []struct {
+		base string
+		top  string
+		want string
+	}{
+		{"green", "bold", "bold green"},
+		{"italic green on_black", "bold yellow", "bold italic yellow on_black"},
+		{"green", "on_red", "green on_red"},
+		{"bold red", "reset", "bold red"},
+		{"reset", "underline blue", "underline blue"},
+	}
+
+	for _, tt := range tests {
+		base, _ := ParseStyle(tt.base)
+		top, _ := ParseStyle(tt.top)
+		want, _ := ParseStyle(tt.want)
+		if got := base.Layer(top); got.Code(ColorsTrue) != want.Code(ColorsTrue) {
+			t.Errorf("%q layered with %q = %q, want %q", tt.base, tt.top, got.Code(ColorsTrue), want.Code(ColorsTrue))
+		}
+	}
+}
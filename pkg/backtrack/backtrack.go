@@ -0,0 +1,527 @@
+// Package backtrack provides a backtracking regular expression matcher for
+// patterns that the RE2 engine of package regexp does not accept.
+//
+// The syntax is that of package regexp, extended with lookahead (?=...) and
+// (?!...), lookbehind (?<=...) and (?<!...), atomic groups (?>...),
+// possessive quantifiers such as a*+, and backreferences \1 to \9 and
+// \k<name>. Named groups can also be written (?<name>...). Alternatives are
+// tried from left to right, so the first alternative that leads to a match
+// wins, as in Perl.
+//
+// Backtracking can take time exponential in the length of the text, so
+// every search has a budget of steps. A search that runs out of steps stops
+// and finds no further matches, rather than hanging.
+package backtrack
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// DefaultMaxSteps is the step budget of a search, enough for typical
+// patterns on long lines while bounding pathological ones to milliseconds
+const DefaultMaxSteps = 1000000
+
+// Regexp is a compiled backtracking regular expression. It is safe for
+// concurrent use.
+type Regexp struct {
+	expr     string
+	root     *node
+	groups   int
+	names    []string
+	prefix   string
+	maxSteps int
+}
+
+// Compile parses a regular expression and returns a Regexp that matches it
+func Compile(expr string) (*Regexp, error) {
+	root, groups, names, err := parse(expr)
+	if err != nil {
+		return nil, err
+	}
+	return &Regexp{
+		expr:     expr,
+		root:     root,
+		groups:   groups,
+		names:    names,
+		prefix:   literalPrefix(root),
+		maxSteps: DefaultMaxSteps,
+	}, nil
+}
+
+// MustCompile is like Compile but panics if the expression cannot be parsed
+func MustCompile(expr string) *Regexp {
+	re, err := Compile(expr)
+	if err != nil {
+		panic(`backtrack: Compile(` + expr + `): ` + err.Error())
+	}
+	return re
+}
+
+// WithMaxSteps returns a copy of the Regexp whose searches stop after steps
+// steps instead of DefaultMaxSteps
+func (re *Regexp) WithMaxSteps(steps int) *Regexp {
+	copied := *re
+	copied.maxSteps = steps
+	return &copied
+}
+
+// String returns the source text of the regular expression
+func (re *Regexp) String() string {
+	return re.expr
+}
+
+// NumSubexp returns the number of capture groups
+func (re *Regexp) NumSubexp() int {
+	return re.groups
+}
+
+// SubexpNames returns the names of the capture groups, with an empty name
+// for the whole match and for unnamed groups
+func (re *Regexp) SubexpNames() []string {
+	return re.names
+}
+
+// SubexpIndex returns the index of the group with the given name, or -1
+func (re *Regexp) SubexpIndex(name string) int {
+	return indexOf(re.names, name)
+}
+
+// MatchString reports whether s contains a match
+func (re *Regexp) MatchString(s string) bool {
+	return re.FindStringSubmatchIndex(s) != nil
+}
+
+// FindStringIndex returns the start and end of the leftmost match in s, or nil
+func (re *Regexp) FindStringIndex(s string) []int {
+	if match := re.FindStringSubmatchIndex(s); match != nil {
+		return match[:2]
+	}
+	return nil
+}
+
+// FindStringSubmatchIndex returns the start and end of the leftmost match in
+// s and of its groups, with -1 for groups that did not take part, or nil
+func (re *Regexp) FindStringSubmatchIndex(s string) []int {
+	m := re.machine(s)
+	return m.search(0)
+}
+
+// FindAllStringIndex returns the successive non-overlapping matches in s, at
+// most n of them unless n is negative, as regexp does
+func (re *Regexp) FindAllStringIndex(s string, n int) [][]int {
+	matches := re.FindAllStringSubmatchIndex(s, n)
+	for i, match := range matches {
+		matches[i] = match[:2]
+	}
+	return matches
+}
+
+// FindAllStringSubmatchIndex is like FindAllStringIndex, with the positions of
+// the groups of each match as in FindStringSubmatchIndex
+func (re *Regexp) FindAllStringSubmatchIndex(s string, n int) [][]int {
+	if n < 0 {
+		n = len(s) + 1
+	}
+
+	// The steps are shared by all searches, so a text with many failing
+	// start positions cannot multiply the budget
+	m := re.machine(s)
+	var matches [][]int
+	for pos, prevEnd := 0, -1; len(matches) < n && pos <= len(s); {
+		match := m.search(pos)
+		if match == nil {
+			break
+		}
+
+		accept := true
+		if match[1] == pos {
+			// An empty match right after the previous match is skipped
+			accept = match[0] != prevEnd
+			if pos < len(s) {
+				_, width := utf8.DecodeRuneInString(s[pos:])
+				pos += width
+			} else {
+				pos++
+			}
+		} else {
+			pos = match[1]
+		}
+		prevEnd = match[1]
+
+		if accept {
+			matches = append(matches, match)
+		}
+	}
+	return matches
+}
+
+// machine holds the state of the searches in a text
+type machine struct {
+	re    *Regexp
+	input string
+	caps  []int
+	steps int
+}
+
+// machine returns a machine for searches in s
+func (re *Regexp) machine(s string) *machine {
+	return &machine{re: re, input: s, caps: make([]int, 2*(re.groups+1))}
+}
+
+// search returns the submatches of the leftmost match starting at or after
+// pos, or nil if there is none or the steps run out
+func (m *machine) search(pos int) []int {
+	for start := pos; start <= len(m.input); {
+		// Skip ahead to where the literal prefix occurs
+		if m.re.prefix != "" {
+			i := strings.Index(m.input[start:], m.re.prefix)
+			if i < 0 {
+				return nil
+			}
+			start += i
+		}
+
+		for i := range m.caps {
+			m.caps[i] = -1
+		}
+		// A match found after the steps run out may be cut short, as when a
+		// greedy repeat or a negative lookaround gave up early, so it counts
+		// as no match
+		end := -1
+		if m.match(m.re.root, start, func(p int) bool { end = p; return !m.exhausted() }) && !m.exhausted() {
+			m.caps[0], m.caps[1] = start, end
+			return append([]int(nil), m.caps...)
+		}
+		if m.exhausted() {
+			return nil
+		}
+
+		if start == len(m.input) {
+			break
+		}
+		_, width := utf8.DecodeRuneInString(m.input[start:])
+		start += width
+	}
+	return nil
+}
+
+// exhausted reports whether the search has taken more steps than allowed
+func (m *machine) exhausted() bool {
+	return m.steps > m.re.maxSteps
+}
+
+// match matches n at pos and calls k with the position after it, trying
+// the next way n can match whenever k fails. It reports whether k succeeded.
+func (m *machine) match(n *node, pos int, k func(int) bool) bool {
+	m.steps++
+	if m.exhausted() {
+		return false
+	}
+
+	input := m.input
+	switch n.kind {
+	case nodeLiteral, nodeClass, nodeAny:
+		if pos < len(input) {
+			if r, width := utf8.DecodeRuneInString(input[pos:]); n.matchRune(r) {
+				return k(pos + width)
+			}
+		}
+		return false
+
+	case nodeBeginText:
+		return pos == 0 && k(pos)
+	case nodeEndText:
+		return pos == len(input) && k(pos)
+	case nodeBeginLine:
+		return (pos == 0 || input[pos-1] == '\n') && k(pos)
+	case nodeEndLine:
+		return (pos == len(input) || input[pos] == '\n') && k(pos)
+	case nodeWordBoundary:
+		return m.atWordBoundary(pos) && k(pos)
+	case nodeNoWordBoundary:
+		return !m.atWordBoundary(pos) && k(pos)
+
+	case nodeConcat:
+		return m.matchSequence(n.subs, pos, k)
+
+	case nodeAlternate:
+		for _, sub := range n.subs {
+			if m.match(sub, pos, k) {
+				return true
+			}
+		}
+		return false
+
+	case nodeGroup:
+		i := 2 * n.index
+		oldStart, oldEnd := m.caps[i], m.caps[i+1]
+		if m.match(n.subs[0], pos, func(end int) bool {
+			prevStart, prevEnd := m.caps[i], m.caps[i+1]
+			m.caps[i], m.caps[i+1] = pos, end
+			if k(end) {
+				return true
+			}
+			m.caps[i], m.caps[i+1] = prevStart, prevEnd
+			return false
+		}) {
+			return true
+		}
+		m.caps[i], m.caps[i+1] = oldStart, oldEnd
+		return false
+
+	case nodeRepeat:
+		if sub := n.subs[0]; sub.kind == nodeLiteral || sub.kind == nodeClass || sub.kind == nodeAny {
+			return m.matchRuns(n, pos, k)
+		}
+		return m.matchRepeat(n, 0, pos, false, k)
+
+	case nodeBackref:
+		start, end := m.caps[2*n.index], m.caps[2*n.index+1]
+		if start < 0 {
+			return false
+		}
+		if next, ok := m.matchText(input[start:end], pos, n.fold); ok {
+			return k(next)
+		}
+		return false
+
+	case nodeLookahead:
+		saved := m.saveCaps()
+		found := m.match(n.subs[0], pos, func(int) bool { return true })
+		if found == n.negate {
+			m.restoreCaps(saved)
+			return false
+		}
+		if n.negate {
+			m.restoreCaps(saved)
+		}
+		if k(pos) {
+			return true
+		}
+		m.restoreCaps(saved)
+		return false
+
+	case nodeLookbehind:
+		saved := m.saveCaps()
+		found := m.matchBehind(n.subs[0], pos)
+		if found == n.negate {
+			m.restoreCaps(saved)
+			return false
+		}
+		if n.negate {
+			m.restoreCaps(saved)
+		}
+		if k(pos) {
+			return true
+		}
+		m.restoreCaps(saved)
+		return false
+
+	case nodeAtomic:
+		// Only the first way the group matches is tried
+		saved := m.saveCaps()
+		end := -1
+		if !m.match(n.subs[0], pos, func(p int) bool { end = p; return true }) {
+			return false
+		}
+		if k(end) {
+			return true
+		}
+		m.restoreCaps(saved)
+		return false
+	}
+	return false
+}
+
+// matchSequence matches the nodes of a concatenation one after the other
+func (m *machine) matchSequence(subs []*node, pos int, k func(int) bool) bool {
+	if len(subs) == 0 {
+		return k(pos)
+	}
+	return m.match(subs[0], pos, func(next int) bool {
+		return m.matchSequence(subs[1:], next, k)
+	})
+}
+
+// matchRepeat matches the repetition n after count repeats, ending at pos;
+// empty reports whether the last repeat matched the empty string
+func (m *machine) matchRepeat(n *node, count, pos int, empty bool, k func(int) bool) bool {
+	more := func() bool {
+		if n.max >= 0 && count >= n.max {
+			return false
+		}
+		// An unbounded repeat may match the empty string once, but
+		// repeating an empty match would loop forever, as in regexp
+		if n.max < 0 && empty && count >= n.min {
+			return false
+		}
+		return m.match(n.subs[0], pos, func(next int) bool {
+			if n.max < 0 && next == pos && count >= n.min && count > 0 {
+				return false
+			}
+			return m.matchRepeat(n, count+1, next, next == pos, k)
+		})
+	}
+
+	if n.greedy {
+		return more() || count >= n.min && k(pos)
+	}
+	return count >= n.min && k(pos) || more()
+}
+
+// matchRuns matches the repetition of a node that matches a single rune,
+// without recursing for every repeat
+func (m *machine) matchRuns(n *node, pos int, k func(int) bool) bool {
+	sub := n.subs[0]
+	ends := []int{pos}
+	for n.max < 0 || len(ends) <= n.max {
+		if !n.greedy && len(ends) > n.min {
+			break
+		}
+		end := ends[len(ends)-1]
+		if end >= len(m.input) {
+			break
+		}
+		r, width := utf8.DecodeRuneInString(m.input[end:])
+		if !sub.matchRune(r) {
+			break
+		}
+		ends = append(ends, end+width)
+	}
+	if len(ends) <= n.min {
+		return false
+	}
+
+	if n.greedy {
+		for i := len(ends) - 1; i >= n.min; i-- {
+			m.steps++
+			if m.exhausted() {
+				return false
+			}
+			if k(ends[i]) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// A lazy repeat takes one more rune each time the rest fails
+	end := ends[n.min]
+	for count := n.min; ; count++ {
+		m.steps++
+		if m.exhausted() {
+			return false
+		}
+		if k(end) {
+			return true
+		}
+		if n.max >= 0 && count >= n.max || end >= len(m.input) {
+			return false
+		}
+		r, width := utf8.DecodeRuneInString(m.input[end:])
+		if !sub.matchRune(r) {
+			return false
+		}
+		end += width
+	}
+}
+
+// matchBehind reports whether n matches text that ends exactly at pos,
+// trying the nearest start first
+func (m *machine) matchBehind(n *node, pos int) bool {
+	for start := pos; start >= 0; {
+		if m.match(n, start, func(end int) bool { return end == pos }) {
+			return true
+		}
+		if start == 0 || m.exhausted() {
+			return false
+		}
+		_, width := utf8.DecodeLastRuneInString(m.input[:start])
+		start -= width
+	}
+	return false
+}
+
+// matchText matches text at pos, ignoring case if fold is set, and returns
+// the position after it
+func (m *machine) matchText(text string, pos int, fold bool) (int, bool) {
+	for _, want := range text {
+		if pos >= len(m.input) {
+			return 0, false
+		}
+		r, width := utf8.DecodeRuneInString(m.input[pos:])
+		if r != want && !(fold && equalFold(r, want)) {
+			return 0, false
+		}
+		pos += width
+	}
+	return pos, true
+}
+
+// atWordBoundary reports whether pos is between a word and a non-word
+// character, with ASCII word characters as in regexp
+func (m *machine) atWordBoundary(pos int) bool {
+	before := pos > 0 && isWordRune(rune(m.input[pos-1]))
+	after := pos < len(m.input) && isWordRune(rune(m.input[pos]))
+	return before != after
+}
+
+// saveCaps returns a copy of the current submatch positions
+func (m *machine) saveCaps() []int {
+	return append([]int(nil), m.caps...)
+}
+
+// restoreCaps restores submatch positions saved by saveCaps
+func (m *machine) restoreCaps(saved []int) {
+	copy(m.caps, saved)
+}
+
+// matchRune reports whether a literal, class or any node matches r
+func (n *node) matchRune(r rune) bool {
+	switch n.kind {
+	case nodeLiteral:
+		return r == n.r || n.fold && equalFold(r, n.r)
+	case nodeAny:
+		return n.dotNL || r != '\n'
+	}
+
+	if !n.fold {
+		return n.class.contains(r)
+	}
+
+	// Case folding applies to the class before it is negated, so a rune is in
+	// it when any of its case variants is
+	return n.class.hasFold(r) != n.class.negate
+}
+
+// equalFold reports whether two runes are equal under simple case folding
+func equalFold(a, b rune) bool {
+	if a == b {
+		return true
+	}
+	for f := unicode.SimpleFold(a); f != a; f = unicode.SimpleFold(f) {
+		if f == b {
+			return true
+		}
+	}
+	return false
+}
+
+// literalPrefix returns the literal text every match starts with, used to
+// skip start positions that cannot match
+func literalPrefix(n *node) string {
+	var prefix []rune
+	items := []*node{n}
+	if n.kind == nodeConcat {
+		items = n.subs
+	}
+	for _, item := range items {
+		if item.kind != nodeLiteral || item.fold {
+			break
+		}
+		prefix = append(prefix, item.r)
+	}
+	return string(prefix)
+}
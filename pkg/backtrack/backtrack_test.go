@@ -0,0 +1,143 @@
+package backtrack
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMatchesLikeRegexp(t *testing.T) {
+	patterns := []string{
+		`a`, `abc`, `a|b`, `ab|a`, `a*`, `a+?`, `a??b`, `(a|ab)(c|bcd)(d*)`,
+		`\b\w+\b`, `\bif\b`, `[a-c]+`, `[^a-c\s]+`, `[[:alpha:]]+`, `[[:^digit:]]`, `\d+(\.\d+)?`,
+		`"[^"]*"`, `//.*`, `/\*.*?\*/`, `^\s*#`, `x*$`, `(?i)select`, `(?i:Ab)c`, `(?s)a.b`, `a.b`,
+		`(?m)^\w+$`, `\pL+`, `\p{Greek}+`, `\PL`, `[\p{Lu}\d]+`, `\x41\x{263a}`, `\Q.*\E+`,
+		`(a*)*`, `(a*)+b`, `(|a)+`, `a{2}`, `a{2,}`, `a{1,3}?`, `x{`, `(?U)a+`, `(?P<word>\w+)`,
+		`\B\w`, `[\d-]+`, `\A\w`, `\w\z`, `.`, `ſ|K`, `(?i)k`, `[^\n]`, ``,
+		`(?i)[^a]`, `(?i)[^a-z]+`, `(?i)[^k]`, `(?i)\W`, `(?i)[\W]`,
+	}
+	texts := []string{
+		"", "a", "aaab", "abcd", "if (x) { return \"a\" } // end", "SELECT Select sElEcT",
+		"a\nb a\rb", "first line\nsecond\n", "pi is 3.14 or 3", "Ωμέγα and Ω", "A☺ AA",
+		"/* a */ b /* c */", ".*.*+", "ab aab xxx", "  # comment", "aaaa", "x{2}", "ſelect KELVIN",
+		"Hello, 世界!", "\xff\xfea", "A", "AbC1", "K",
+	}
+
+	for _, pattern := range patterns {
+		want := regexp.MustCompile(pattern)
+		got, err := Compile(pattern)
+		if err != nil {
+			t.Errorf("Compile(%q) error = %v", pattern, err)
+			continue
+		}
+		if got.NumSubexp() != want.NumSubexp() {
+			t.Errorf("Compile(%q).NumSubexp() = %d, want %d", pattern, got.NumSubexp(), want.NumSubexp())
+		}
+		for _, text := range texts {
+			if g, w := got.FindAllStringSubmatchIndex(text, -1), want.FindAllStringSubmatchIndex(text, -1); !reflect.DeepEqual(g, w) {
+				t.Errorf("FindAllStringSubmatchIndex(%q, %q) = %v, want %v", pattern, text, g, w)
+			}
+		}
+	}
+}
+
+func TestExtendedSyntax(t *testing.T) {
+	tests := []struct {
+		pattern string
+		text    string
+		want    []string
+	}{
+		{`foo(?=bar)`, "foobaz foobar", []string{"foo"}},
+		{`\w+(?!\()`, "call(x) y", []string{"cal", "x", "y"}},
+		{`(?<=\$)\w+`, "$a b $cd", []string{"a", "cd"}},
+		{`(?<!\.)\b\d+`, "1.5 and 42", []string{"1", "42"}},
+		{`(?<=^|,)\w+`, "a,b c", []string{"a", "b"}},
+		{`(?<=ab+)c`, "abbbc ac", []string{"c"}},
+		{`(['"]).*?\1`, `'a' "b'c" 'd"`, []string{"'a'", `"b'c"`}},
+		{`(?<q>['"])\w*\k<q>`, `"ab" 'c"`, []string{`"ab"`}},
+		{`(?i)(a)\1`, "aA Ab", []string{"aA"}},
+		{`(?>a+)b`, "aaab", []string{"aaab"}},
+		{`(?>a|ab)c`, "abc ac", []string{"ac"}},
+		{`a*+a`, "aaa", nil},
+		{`"[^"]*+"`, `"x"`, []string{`"x"`}},
+		{`<<(\w+)\n(?s:.*?)\n\1\b`, "<<EOF\nbody\nEOF\nEOFX", []string{"<<EOF\nbody\nEOF"}},
+		{`\b(\w+)\s+\1\b`, "the the cat cat", []string{"the the", "cat cat"}},
+	}
+
+	for _, tt := range tests {
+		re, err := Compile(tt.pattern)
+		if err != nil {
+			t.Errorf("Compile(%q) error = %v", tt.pattern, err)
+			continue
+		}
+		var got []string
+		for _, match := range re.FindAllStringIndex(tt.text, -1) {
+			got = append(got, tt.text[match[0]:match[1]])
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q in %q = %q, want %q", tt.pattern, tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSubexpIndex(t *testing.T) {
+	re := MustCompile(`(?P<a>x)(y)(?<b>z)(?=(w))`)
+	if re.NumSubexp() != 4 || re.SubexpIndex("a") != 1 || re.SubexpIndex("b") != 3 || re.SubexpIndex("c") != -1 {
+		t.Errorf("groups of %s = %d, %v", re, re.NumSubexp(), re.SubexpNames())
+	}
+	if got, want := re.FindStringSubmatchIndex("xyzw"), []int{0, 3, 0, 1, 1, 2, 2, 3, 3, 4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FindStringSubmatchIndex() = %v, want %v", got, want)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, pattern := range []string{
+		`(`, `)`, `[a`, `a**`, `*a`, `\1(a)(b)\3`, `\k<x>`, `(?P<a-b>x)`, `(?P<a>x)(?P<a>y)`,
+		`\p{Nope}`, `[z-a]`, `(?z)`, `a{1001}`, `\`, `\j`,
+	} {
+		if _, err := Compile(pattern); err == nil {
+			t.Errorf("Compile(%q) expected error", pattern)
+		}
+	}
+}
+
+func TestStepLimit(t *testing.T) {
+	// Nested repetition backtracks exponentially on a text that never matches
+	re := MustCompile(`(a+)+b`)
+	text := strings.Repeat("a", 40) + "c"
+
+	start := time.Now()
+	if matches := re.FindAllStringIndex(text, -1); matches != nil {
+		t.Errorf("FindAllStringIndex() = %v, want no match", matches)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("search took %v despite the step limit", elapsed)
+	}
+
+	// A budget spent on the failing prefix finds nothing after it
+	text = strings.Repeat("a", 12) + "c ab"
+	if re.WithMaxSteps(1000).MatchString(text) {
+		t.Error("MatchString() found a match beyond the step limit")
+	}
+	if !re.MatchString(text) {
+		t.Error("MatchString() found no match within the default step limit")
+	}
+
+	// Running out of steps partway through a match rejects it rather than
+	// returning what was matched so far
+	tests := []struct {
+		pattern string
+		text    string
+	}{
+		{`(?:ab)*`, strings.Repeat("ab", 50)},
+		{`a(?!(?:b|bb)*c)`, "a" + strings.Repeat("b", 50)},
+		{`b*(?<!(?:b|bb)*c)`, strings.Repeat("b", 50)},
+	}
+	for _, tt := range tests {
+		if loc := MustCompile(tt.pattern).WithMaxSteps(100).FindStringIndex(tt.text); loc != nil {
+			t.Errorf("FindStringIndex(%q) = %v beyond the step limit, want no match", tt.pattern, loc)
+		}
+	}
+}
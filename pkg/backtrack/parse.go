@@ -0,0 +1,752 @@
+package backtrack
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxRepeat is the largest count a {n,m} repetition may use, as in regexp
+const maxRepeat = 1000
+
+// nodeKind is the kind of a node of a parsed pattern
+type nodeKind int
+
+const (
+	nodeLiteral nodeKind = iota
+	nodeClass
+	nodeAny
+	nodeBeginText
+	nodeEndText
+	nodeBeginLine
+	nodeEndLine
+	nodeWordBoundary
+	nodeNoWordBoundary
+	nodeConcat
+	nodeAlternate
+	nodeGroup
+	nodeRepeat
+	nodeBackref
+	nodeLookahead
+	nodeLookbehind
+	nodeAtomic
+)
+
+// node is a part of a parsed pattern
+type node struct {
+	kind   nodeKind
+	r      rune
+	class  *charClass
+	fold   bool
+	dotNL  bool
+	negate bool
+	subs   []*node
+	index  int
+	name   string
+	min    int
+	max    int
+	greedy bool
+}
+
+// charClass is a set of runes, given by ranges and Unicode tables
+type charClass struct {
+	ranges    []rune
+	tables    []*unicode.RangeTable
+	notTables []*unicode.RangeTable
+	negate    bool
+}
+
+// flags are the inline flags in effect at a point of a pattern
+type flags struct {
+	fold      bool
+	multiLine bool
+	dotNL     bool
+	ungreedy  bool
+}
+
+// parser turns a pattern into a tree of nodes
+type parser struct {
+	expr     string
+	pos      int
+	groups   int
+	names    []string
+	backrefs []*node
+}
+
+// parse parses a pattern and returns its tree, the number of capture groups
+// and their names
+func parse(expr string) (*node, int, []string, error) {
+	p := &parser{expr: expr, names: []string{""}}
+	root, err := p.alternate(flags{})
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if p.pos < len(p.expr) {
+		return nil, 0, nil, p.errorf("unexpected )")
+	}
+
+	// Backreferences may refer to groups that come after them
+	for _, ref := range p.backrefs {
+		if ref.name != "" {
+			ref.index = indexOf(p.names, ref.name)
+			if ref.index < 0 {
+				return nil, 0, nil, p.errorf("unknown group name %s", ref.name)
+			}
+		}
+		if ref.index > p.groups {
+			return nil, 0, nil, p.errorf("invalid backreference \\%d", ref.index)
+		}
+	}
+	return root, p.groups, p.names, nil
+}
+
+// errorf returns a parse error for the pattern
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("error parsing regexp: %s: `%s`", fmt.Sprintf(format, args...), p.expr)
+}
+
+// more reports whether there is pattern text left
+func (p *parser) more() bool {
+	return p.pos < len(p.expr)
+}
+
+// peek returns the next rune of the pattern without consuming it
+func (p *parser) peek() rune {
+	r, _ := utf8.DecodeRuneInString(p.expr[p.pos:])
+	return r
+}
+
+// next consumes and returns the next rune of the pattern
+func (p *parser) next() rune {
+	r, width := utf8.DecodeRuneInString(p.expr[p.pos:])
+	p.pos += width
+	return r
+}
+
+// consume consumes prefix if the pattern continues with it
+func (p *parser) consume(prefix string) bool {
+	if strings.HasPrefix(p.expr[p.pos:], prefix) {
+		p.pos += len(prefix)
+		return true
+	}
+	return false
+}
+
+// alternate parses alternatives separated by |, up to a closing parenthesis
+// or the end of the pattern
+func (p *parser) alternate(f flags) (*node, error) {
+	var alternatives []*node
+	for {
+		concat, err := p.concat(&f)
+		if err != nil {
+			return nil, err
+		}
+		alternatives = append(alternatives, concat)
+		if !p.consume("|") {
+			break
+		}
+	}
+	if len(alternatives) == 1 {
+		return alternatives[0], nil
+	}
+	return &node{kind: nodeAlternate, subs: alternatives}, nil
+}
+
+// concat parses a sequence of repeated atoms. Inline flags such as (?i)
+// change f for the rest of the enclosing group.
+func (p *parser) concat(f *flags) (*node, error) {
+	var items []*node
+	for p.more() && p.peek() != '|' && p.peek() != ')' {
+		quoted := strings.HasPrefix(p.expr[p.pos:], `\Q`)
+		atom, err := p.atom(f)
+		if err != nil {
+			return nil, err
+		}
+		if quoted {
+			// A quantifier after \Q...\E applies to the last quoted rune only
+			if len(atom.subs) == 0 {
+				continue
+			}
+			last := len(atom.subs) - 1
+			items = append(items, atom.subs[:last]...)
+			atom = atom.subs[last]
+		}
+		if atom == nil {
+			continue
+		}
+		if atom, err = p.repeat(atom, *f); err != nil {
+			return nil, err
+		}
+		items = append(items, atom)
+	}
+	if len(items) == 1 {
+		return items[0], nil
+	}
+	return &node{kind: nodeConcat, subs: items}, nil
+}
+
+// repeat parses the quantifiers after an atom
+func (p *parser) repeat(atom *node, f flags) (*node, error) {
+	repeated := false
+	for p.more() {
+		start := p.pos
+		min, max := 0, 0
+		switch p.peek() {
+		case '*':
+			p.next()
+			min, max = 0, -1
+		case '+':
+			p.next()
+			min, max = 1, -1
+		case '?':
+			p.next()
+			min, max = 0, 1
+		case '{':
+			var ok bool
+			if min, max, ok = p.counts(); !ok {
+				return atom, nil
+			}
+			if min > maxRepeat || max > maxRepeat {
+				return nil, p.errorf("invalid repeat count %s", p.expr[start:p.pos])
+			}
+		default:
+			return atom, nil
+		}
+
+		if repeated {
+			return nil, p.errorf("invalid nested repetition operator %s", p.expr[start:p.pos])
+		}
+		repeated = true
+
+		greedy := !f.ungreedy
+		if p.consume("?") {
+			greedy = !greedy
+		}
+		atom = &node{kind: nodeRepeat, subs: []*node{atom}, min: min, max: max, greedy: greedy}
+
+		// A possessive quantifier never gives back what it matched
+		if p.consume("+") {
+			atom = &node{kind: nodeAtomic, subs: []*node{atom}}
+		}
+	}
+	return atom, nil
+}
+
+// counts parses a {n}, {n,} or {n,m} repetition. A brace that does not start
+// one is left to be read as a literal.
+func (p *parser) counts() (int, int, bool) {
+	end := strings.IndexByte(p.expr[p.pos:], '}')
+	if end < 0 {
+		return 0, 0, false
+	}
+	body := p.expr[p.pos+1 : p.pos+end]
+
+	lo, hi, comma := strings.Cut(body, ",")
+	min, err := strconv.Atoi(lo)
+	if err != nil || min < 0 {
+		return 0, 0, false
+	}
+	max := min
+	if comma {
+		max = -1
+		if hi != "" {
+			if max, err = strconv.Atoi(hi); err != nil || max < min {
+				return 0, 0, false
+			}
+		}
+	}
+	p.pos += end + 1
+	return min, max, true
+}
+
+// atom parses a single item of a pattern. It returns nil for items that only
+// change flags.
+func (p *parser) atom(f *flags) (*node, error) {
+	switch r := p.next(); r {
+	case '(':
+		return p.group(f)
+	case '[':
+		class, err := p.class(*f)
+		if err != nil {
+			return nil, err
+		}
+		return &node{kind: nodeClass, class: class, fold: f.fold}, nil
+	case '.':
+		return &node{kind: nodeAny, dotNL: f.dotNL}, nil
+	case '^':
+		if f.multiLine {
+			return &node{kind: nodeBeginLine}, nil
+		}
+		return &node{kind: nodeBeginText}, nil
+	case '$':
+		if f.multiLine {
+			return &node{kind: nodeEndLine}, nil
+		}
+		return &node{kind: nodeEndText}, nil
+	case '*', '+', '?':
+		return nil, p.errorf("missing argument to repetition operator %c", r)
+	case '\\':
+		return p.escape(*f)
+	default:
+		return &node{kind: nodeLiteral, r: r, fold: f.fold}, nil
+	}
+}
+
+// group parses a group after its opening parenthesis
+func (p *parser) group(f *flags) (*node, error) {
+	kind, negate := nodeGroup, false
+	index, inner := 0, *f
+
+	switch {
+	case p.consume("?:"):
+		kind = nodeConcat
+	case p.consume("?="):
+		kind = nodeLookahead
+	case p.consume("?!"):
+		kind, negate = nodeLookahead, true
+	case p.consume("?<="):
+		kind = nodeLookbehind
+	case p.consume("?<!"):
+		kind, negate = nodeLookbehind, true
+	case p.consume("?>"):
+		kind = nodeAtomic
+	case p.consume("?P<"), p.consume("?<"):
+		end := strings.IndexByte(p.expr[p.pos:], '>')
+		if end < 0 {
+			return nil, p.errorf("invalid named capture")
+		}
+		name := p.expr[p.pos : p.pos+end]
+		if !validName(name) {
+			return nil, p.errorf("invalid named capture %s", name)
+		}
+		if indexOf(p.names, name) >= 0 {
+			return nil, p.errorf("duplicate capture group name %s", name)
+		}
+		p.pos += end + 1
+		p.groups++
+		index = p.groups
+		p.names = append(p.names, name)
+	case p.consume("?"):
+		// Inline flags, for the rest of the group or only inside (?flags:...)
+		scoped, err := p.flags(&inner)
+		if err != nil {
+			return nil, err
+		}
+		if !scoped {
+			*f = inner
+			return nil, nil
+		}
+		kind = nodeConcat
+	default:
+		p.groups++
+		index = p.groups
+		p.names = append(p.names, "")
+	}
+
+	sub, err := p.alternate(inner)
+	if err != nil {
+		return nil, err
+	}
+	if !p.consume(")") {
+		return nil, p.errorf("missing closing )")
+	}
+
+	switch kind {
+	case nodeConcat:
+		return sub, nil
+	case nodeGroup:
+		return &node{kind: nodeGroup, subs: []*node{sub}, index: index}, nil
+	default:
+		return &node{kind: kind, subs: []*node{sub}, negate: negate}, nil
+	}
+}
+
+// flags parses the flags of (?flags) or (?flags: into f, and reports whether
+// they only apply inside a group
+func (p *parser) flags(f *flags) (bool, error) {
+	on := true
+	for p.more() {
+		switch r := p.next(); r {
+		case 'i':
+			f.fold = on
+		case 'm':
+			f.multiLine = on
+		case 's':
+			f.dotNL = on
+		case 'U':
+			f.ungreedy = on
+		case '-':
+			if !on {
+				return false, p.errorf("invalid flags")
+			}
+			on = false
+		case ')':
+			return false, nil
+		case ':':
+			return true, nil
+		default:
+			return false, p.errorf("invalid or unsupported flag %c", r)
+		}
+	}
+	return false, p.errorf("missing closing )")
+}
+
+// escape parses an escape sequence outside a class, after its backslash
+func (p *parser) escape(f flags) (*node, error) {
+	if !p.more() {
+		return nil, p.errorf("trailing backslash at end of expression")
+	}
+
+	switch r := p.peek(); {
+	case r == 'A':
+		p.next()
+		return &node{kind: nodeBeginText}, nil
+	case r == 'z':
+		p.next()
+		return &node{kind: nodeEndText}, nil
+	case r == 'b':
+		p.next()
+		return &node{kind: nodeWordBoundary}, nil
+	case r == 'B':
+		p.next()
+		return &node{kind: nodeNoWordBoundary}, nil
+	case r >= '1' && r <= '9':
+		p.next()
+		ref := &node{kind: nodeBackref, index: int(r - '0'), fold: f.fold}
+		p.backrefs = append(p.backrefs, ref)
+		return ref, nil
+	case r == 'k':
+		p.next()
+		if !p.consume("<") {
+			return nil, p.errorf("invalid escape sequence \\k")
+		}
+		end := strings.IndexByte(p.expr[p.pos:], '>')
+		if end < 0 {
+			return nil, p.errorf("invalid escape sequence \\k<")
+		}
+		ref := &node{kind: nodeBackref, name: p.expr[p.pos : p.pos+end], fold: f.fold}
+		p.pos += end + 1
+		p.backrefs = append(p.backrefs, ref)
+		return ref, nil
+	case r == 'Q':
+		// Everything up to \E is literal
+		p.next()
+		text := p.expr[p.pos:]
+		if end := strings.Index(text, `\E`); end >= 0 {
+			text = text[:end]
+			p.pos += 2
+		}
+		p.pos += len(text)
+		var items []*node
+		for _, r := range text {
+			items = append(items, &node{kind: nodeLiteral, r: r, fold: f.fold})
+		}
+		return &node{kind: nodeConcat, subs: items}, nil
+	}
+
+	class, r, err := p.classEscape()
+	if err != nil {
+		return nil, err
+	}
+	if class != nil {
+		return &node{kind: nodeClass, class: class, fold: f.fold}, nil
+	}
+	return &node{kind: nodeLiteral, r: r, fold: f.fold}, nil
+}
+
+// classEscape parses an escape that may also appear inside a class: either
+// a class such as \d or \pL, or a single rune
+func (p *parser) classEscape() (*charClass, rune, error) {
+	switch r := p.next(); r {
+	case 'd', 'D':
+		return &charClass{ranges: []rune{'0', '9'}, negate: r == 'D'}, 0, nil
+	case 'w', 'W':
+		return &charClass{ranges: wordRanges, negate: r == 'W'}, 0, nil
+	case 's', 'S':
+		return &charClass{ranges: spaceRanges, negate: r == 'S'}, 0, nil
+	case 'p', 'P':
+		name := ""
+		if p.consume("{") {
+			end := strings.IndexByte(p.expr[p.pos:], '}')
+			if end < 0 {
+				return nil, 0, p.errorf("invalid character class range")
+			}
+			name = p.expr[p.pos : p.pos+end]
+			p.pos += end + 1
+		} else if p.more() {
+			name = string(p.next())
+		}
+		negate := r == 'P'
+		if strings.HasPrefix(name, "^") {
+			name, negate = name[1:], !negate
+		}
+		table := unicodeTable(name)
+		if table == nil {
+			return nil, 0, p.errorf("invalid character class range \\p{%s}", name)
+		}
+		return &charClass{tables: []*unicode.RangeTable{table}, negate: negate}, 0, nil
+	case 'a':
+		return nil, '\a', nil
+	case 'f':
+		return nil, '\f', nil
+	case 't':
+		return nil, '\t', nil
+	case 'n':
+		return nil, '\n', nil
+	case 'r':
+		return nil, '\r', nil
+	case 'v':
+		return nil, '\v', nil
+	case 'x':
+		var digits string
+		if p.consume("{") {
+			end := strings.IndexByte(p.expr[p.pos:], '}')
+			if end < 0 {
+				return nil, 0, p.errorf("invalid escape sequence \\x{")
+			}
+			digits = p.expr[p.pos : p.pos+end]
+			p.pos += end + 1
+		} else if p.pos+2 <= len(p.expr) {
+			digits = p.expr[p.pos : p.pos+2]
+			p.pos += 2
+		}
+		value, err := strconv.ParseUint(digits, 16, 32)
+		if err != nil || value > unicode.MaxRune {
+			return nil, 0, p.errorf("invalid escape sequence \\x%s", digits)
+		}
+		return nil, rune(value), nil
+	default:
+		if r < utf8.RuneSelf && !isWordRune(r) {
+			return nil, r, nil
+		}
+		return nil, 0, p.errorf("invalid escape sequence \\%c", r)
+	}
+}
+
+// class parses a bracketed class after its opening bracket
+func (p *parser) class(f flags) (*charClass, error) {
+	class := &charClass{}
+	if p.consume("^") {
+		class.negate = true
+	}
+
+	first := true
+	for {
+		if !p.more() {
+			return nil, p.errorf("missing closing ]")
+		}
+		if p.peek() == ']' && !first {
+			p.next()
+			return class, nil
+		}
+		first = false
+
+		// POSIX classes such as [:alpha:]
+		if strings.HasPrefix(p.expr[p.pos:], "[:") {
+			if end := strings.Index(p.expr[p.pos+2:], ":]"); end >= 0 {
+				name := p.expr[p.pos+2 : p.pos+2+end]
+				negate := strings.HasPrefix(name, "^")
+				ranges, ok := posixClasses[strings.TrimPrefix(name, "^")]
+				if !ok {
+					return nil, p.errorf("invalid character class range [:%s:]", name)
+				}
+				p.pos += end + 4
+				class.add(&charClass{ranges: ranges, negate: negate})
+				continue
+			}
+		}
+
+		lo, err := p.classRune(class)
+		if err != nil {
+			return nil, err
+		}
+		if lo < 0 {
+			continue
+		}
+
+		// A range, unless the dash is the last item
+		hi := lo
+		if strings.HasPrefix(p.expr[p.pos:], "-") && !strings.HasPrefix(p.expr[p.pos:], "-]") {
+			p.next()
+			if hi, err = p.classRune(nil); err != nil {
+				return nil, err
+			}
+			if hi < lo {
+				return nil, p.errorf("invalid character class range %c-%c", lo, hi)
+			}
+		}
+		class.ranges = append(class.ranges, lo, hi)
+	}
+}
+
+// classRune parses a rune inside a class. Escaped classes such as \d are added
+// to class and give -1; they are not allowed where class is nil, as the end of
+// a range.
+func (p *parser) classRune(class *charClass) (rune, error) {
+	if !p.more() {
+		return 0, p.errorf("missing closing ]")
+	}
+	r := p.next()
+	if r != '\\' {
+		return r, nil
+	}
+	if !p.more() {
+		return 0, p.errorf("trailing backslash at end of expression")
+	}
+
+	sub, r, err := p.classEscape()
+	if err != nil {
+		return 0, err
+	}
+	if sub == nil {
+		return r, nil
+	}
+	if class == nil {
+		return 0, p.errorf("invalid character class range")
+	}
+	class.add(sub)
+	return -1, nil
+}
+
+// add adds the runes of sub to the class
+func (c *charClass) add(sub *charClass) {
+	if !sub.negate {
+		c.ranges = append(c.ranges, sub.ranges...)
+		c.tables = append(c.tables, sub.tables...)
+		c.notTables = append(c.notTables, sub.notTables...)
+		return
+	}
+
+	// The complement of ranges or of a table is kept as a table
+	if len(sub.ranges) > 0 {
+		c.notTables = append(c.notTables, rangeTable(sub.ranges))
+	}
+	c.notTables = append(c.notTables, sub.tables...)
+}
+
+// contains reports whether the class contains r
+func (c *charClass) contains(r rune) bool {
+	return c.has(r) != c.negate
+}
+
+// has reports whether r is in the ranges and tables of the class, before the
+// class is negated
+func (c *charClass) has(r rune) bool {
+	found := c.hasPositive(r)
+	for _, table := range c.notTables {
+		found = found || !unicode.Is(table, r)
+	}
+	return found
+}
+
+// hasFold is has under case folding. Each part of the class takes in the case
+// variants of its runes before the negated tables are complemented, so (?i)[\W]
+// doesn't match K through the Kelvin sign
+func (c *charClass) hasFold(r rune) bool {
+	found := c.hasPositive(r)
+	for f := unicode.SimpleFold(r); f != r && !found; f = unicode.SimpleFold(f) {
+		found = c.hasPositive(f)
+	}
+	for _, table := range c.notTables {
+		found = found || !isFold(table, r)
+	}
+	return found
+}
+
+// hasPositive reports whether r is in the ranges or tables of the class
+func (c *charClass) hasPositive(r rune) bool {
+	found := false
+	for i := 0; i < len(c.ranges) && !found; i += 2 {
+		found = c.ranges[i] <= r && r <= c.ranges[i+1]
+	}
+	for _, table := range c.tables {
+		found = found || unicode.Is(table, r)
+	}
+	return found
+}
+
+// isFold reports whether r or any of its case variants is in table
+func isFold(table *unicode.RangeTable, r rune) bool {
+	if unicode.Is(table, r) {
+		return true
+	}
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if unicode.Is(table, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// rangeTable converts rune ranges to a Unicode table
+func rangeTable(ranges []rune) *unicode.RangeTable {
+	table := &unicode.RangeTable{}
+	for i := 0; i < len(ranges); i += 2 {
+		table.R32 = append(table.R32, unicode.Range32{Lo: uint32(ranges[i]), Hi: uint32(ranges[i+1]), Stride: 1})
+	}
+	return table
+}
+
+// unicodeTable returns the Unicode category or script called name, or nil
+func unicodeTable(name string) *unicode.RangeTable {
+	if name == "Any" {
+		return &unicode.RangeTable{R32: []unicode.Range32{{Lo: 0, Hi: unicode.MaxRune, Stride: 1}}}
+	}
+	if table, ok := unicode.Categories[name]; ok {
+		return table
+	}
+	return unicode.Scripts[name]
+}
+
+// Runes of the Perl and POSIX classes, which are ASCII only as in regexp
+var (
+	wordRanges  = []rune{'0', '9', 'A', 'Z', '_', '_', 'a', 'z'}
+	spaceRanges = []rune{'\t', '\n', '\f', '\r', ' ', ' '}
+
+	posixClasses = map[string][]rune{
+		"alnum":  {'0', '9', 'A', 'Z', 'a', 'z'},
+		"alpha":  {'A', 'Z', 'a', 'z'},
+		"ascii":  {0, 0x7f},
+		"blank":  {'\t', '\t', ' ', ' '},
+		"cntrl":  {0, 0x1f, 0x7f, 0x7f},
+		"digit":  {'0', '9'},
+		"graph":  {'!', '~'},
+		"lower":  {'a', 'z'},
+		"print":  {' ', '~'},
+		"punct":  {'!', '/', ':', '@', '[', '`', '{', '~'},
+		"space":  {'\t', '\r', ' ', ' '},
+		"upper":  {'A', 'Z'},
+		"word":   wordRanges,
+		"xdigit": {'0', '9', 'A', 'F', 'a', 'f'},
+	}
+)
+
+// isWordRune reports whether r is an ASCII word character, as \w matches
+func isWordRune(r rune) bool {
+	return r >= '0' && r <= '9' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r == '_'
+}
+
+// validName reports whether a capture group name is made of word characters
+func validName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !isWordRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// indexOf returns the index of name in names, or -1
+func indexOf(names []string, name string) int {
+	for i, n := range names {
+		if n == name && n != "" {
+			return i
+		}
+	}
+	return -1
+}
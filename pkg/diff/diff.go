@@ -0,0 +1,165 @@
+// Package diff finds the differences between two sequences with Myers' O(ND)
+// algorithm, in its linear space variant that splits the sequences at the
+// middle snake of a shortest edit script.
+package diff
+
+// Op is the kind of an Edit
+type Op int
+
+// Kinds of edits
+const (
+	Equal Op = iota
+	Delete
+	Insert
+)
+
+// Edit is a run of elements that are equal in both sequences, deleted from the
+// first sequence, or inserted from the second one. A and B are the indexes where
+// the run starts in the first and the second sequence, and Len is its length.
+type Edit struct {
+	Op  Op
+	A   int
+	B   int
+	Len int
+}
+
+// Diff returns a shortest edit script that turns a into b, as runs of equal,
+// deleted and inserted elements in order. Where a deletion and an insertion
+// meet, the deletion comes first.
+func Diff[T comparable](a, b []T) []Edit {
+	size := (len(a)+len(b)+1)/2 + 1
+	d := &differ[T]{
+		a:        a,
+		b:        b,
+		deleted:  make([]bool, len(a)),
+		inserted: make([]bool, len(b)),
+		forward:  make([]int, 2*size+1),
+		backward: make([]int, 2*size+1),
+	}
+	d.compare(0, len(a), 0, len(b))
+	return d.edits()
+}
+
+// differ holds the sequences being compared, the elements found to be deleted
+// and inserted so far, and the furthest reaching paths of the current search
+type differ[T comparable] struct {
+	a, b     []T
+	deleted  []bool
+	inserted []bool
+	forward  []int
+	backward []int
+}
+
+// compare marks the elements deleted from a[aLo:aHi] and inserted from b[bLo:bHi]
+func (d *differ[T]) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		aLo++
+		bLo++
+	}
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+	}
+
+	switch {
+	case aLo == aHi:
+		for j := bLo; j < bHi; j++ {
+			d.inserted[j] = true
+		}
+	case bLo == bHi:
+		for i := aLo; i < aHi; i++ {
+			d.deleted[i] = true
+		}
+	default:
+		// Without a common prefix or suffix there are at least two edits, so
+		// both halves around the middle snake are smaller than the whole
+		x0, y0, x1, y1 := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x0, bLo, y0)
+		d.compare(x1, aHi, y1, bHi)
+	}
+}
+
+// middleSnake searches from both ends of a[aLo:aHi] and b[bLo:bHi] at once, and
+// returns where the two searches meet: the start and end of a run of equal
+// elements that a shortest edit script goes through.
+func (d *differ[T]) middleSnake(aLo, aHi, bLo, bHi int) (x0, y0, x1, y1 int) {
+	a, b := d.a[aLo:aHi], d.b[bLo:bHi]
+	n, m := len(a), len(b)
+	delta := n - m
+	odd := delta%2 != 0
+	limit := (n + m + 1) / 2
+	offset := limit + 1
+
+	// forward[offset+k] is how far along a the furthest path on diagonal
+	// k = x - y has come from the start, and backward[offset+k] the same for
+	// paths coming back from the end, with both sequences reversed
+	forward, backward := d.forward, d.backward
+	forward[offset+1], backward[offset+1] = 0, 0
+	for e := 0; e <= limit; e++ {
+		for k := -e; k <= e; k += 2 {
+			x := furthest(forward, offset, k, e)
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			if back := delta - k; odd && back >= 1-e && back <= e-1 && x+backward[offset+back] >= n {
+				return aLo + startX, bLo + startY, aLo + x, bLo + y
+			}
+		}
+		for k := -e; k <= e; k += 2 {
+			x := furthest(backward, offset, k, e)
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && a[n-1-x] == b[m-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			if ahead := delta - k; !odd && ahead >= -e && ahead <= e && x+forward[offset+ahead] >= n {
+				return aLo + n - x, bLo + m - y, aLo + n - startX, bLo + m - startY
+			}
+		}
+	}
+	panic("diff: no middle snake")
+}
+
+// furthest returns where a path with e edits on diagonal k starts its snake, from
+// the furthest paths with e-1 edits on the neighboring diagonals
+func furthest(v []int, offset, k, e int) int {
+	if k == -e || k != e && v[offset+k-1] < v[offset+k+1] {
+		return v[offset+k+1]
+	}
+	return v[offset+k-1] + 1
+}
+
+// edits collects the marked elements into runs
+func (d *differ[T]) edits() []Edit {
+	var edits []Edit
+	add := func(op Op, i, j int) {
+		if n := len(edits); n > 0 && edits[n-1].Op == op {
+			edits[n-1].Len++
+			return
+		}
+		edits = append(edits, Edit{Op: op, A: i, B: j, Len: 1})
+	}
+
+	i, j := 0, 0
+	for i < len(d.a) || j < len(d.b) {
+		switch {
+		case i < len(d.a) && d.deleted[i]:
+			add(Delete, i, j)
+			i++
+		case j < len(d.b) && d.inserted[j]:
+			add(Insert, i, j)
+			j++
+		default:
+			add(Equal, i, j)
+			i++
+			j++
+		}
+	}
+	return edits
+}
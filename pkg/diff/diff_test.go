@@ -0,0 +1,88 @@
+package diff
+
+import (
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want []Edit
+	}{
+		{"equal", "abc", "abc", []Edit{{Equal, 0, 0, 3}}},
+		{"empty", "", "", nil},
+		{"all inserted", "", "ab", []Edit{{Insert, 0, 0, 2}}},
+		{"all deleted", "ab", "", []Edit{{Delete, 0, 0, 2}}},
+		{"replaced", "abc", "axc", []Edit{{Equal, 0, 0, 1}, {Delete, 1, 1, 1}, {Insert, 2, 1, 1}, {Equal, 2, 2, 1}}},
+		{"swapped", "ab", "ba", []Edit{{Delete, 0, 0, 1}, {Equal, 1, 0, 1}, {Insert, 2, 1, 1}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Diff(strings.Split(tt.a, ""), strings.Split(tt.b, ""))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestDiffShortest checks that random edit scripts turn a into b with as few
+// edits as the longest common subsequence allows
+func TestDiffShortest(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	sequence := func() []byte {
+		s := make([]byte, random.Intn(40))
+		for i := range s {
+			s[i] = "abc"[random.Intn(3)]
+		}
+		return s
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := sequence(), sequence()
+		edits := Diff(a, b)
+
+		var got []byte
+		changes := 0
+		for _, edit := range edits {
+			switch edit.Op {
+			case Equal:
+				got = append(got, a[edit.A:edit.A+edit.Len]...)
+			case Insert:
+				got = append(got, b[edit.B:edit.B+edit.Len]...)
+			}
+			if edit.Op != Equal {
+				changes += edit.Len
+			}
+		}
+		if string(got) != string(b) {
+			t.Fatalf("Diff(%q, %q) applied = %q", a, b, got)
+		}
+		if want := len(a) + len(b) - 2*lcs(a, b); changes != want {
+			t.Fatalf("Diff(%q, %q) has %d edits, want %d", a, b, changes, want)
+		}
+	}
+}
+
+// lcs returns the length of the longest common subsequence of a and b
+func lcs(a, b []byte) int {
+	row := make([]int, len(b)+1)
+	for i := range a {
+		prev := 0
+		for j := range b {
+			cur := row[j+1]
+			if a[i] == b[j] {
+				row[j+1] = prev + 1
+			} else if row[j] > row[j+1] {
+				row[j+1] = row[j]
+			}
+			prev = cur
+		}
+	}
+	return row[len(b)]
+}